@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"net"
@@ -8,6 +9,7 @@ import (
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -130,7 +132,14 @@ connects to Kafka, and forwards messages to Kafka.`,
 					fmt.Fprintln(os.Stderr, err)
 					os.Exit(-1)
 				}
-				err = syscall.Exec(exe, os.Args, []string{"PATH=/bin:/usr/bin", "SKEWER_DROPPED=TRUE"})
+				env := []string{"PATH=/bin:/usr/bin", "SKEWER_DROPPED=TRUE"}
+				// keep the sockets given by systemd or by the previous binary
+				for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", "SKEWER_UPGRADE_FD"} {
+					if value, ok := os.LookupEnv(name); ok {
+						env = append(env, name+"="+value)
+					}
+				}
+				err = syscall.Exec(exe, os.Args, env)
 				if err != nil {
 					fmt.Fprintln(os.Stderr, err)
 					os.Exit(-1)
@@ -141,6 +150,15 @@ connects to Kafka, and forwards messages to Kafka.`,
 		rootlogger := utils.SetLogging(loglevelFlag, logjsonFlag, syslogFlag, logfilenameFlag)
 		logger := rootlogger.New("proc", "parent")
 
+		pool, err := sys.NewSocketPool()
+		if err != nil {
+			logger.Crit("Error inheriting the listening sockets", "error", err)
+			os.Exit(-1)
+		}
+		for _, socket := range pool.Sockets() {
+			logger.Info("Inherited listening socket", "name", socket.Name, "addr", socket.Addr.String())
+		}
+
 		mustSocketPair := func(typ int) (int, int) {
 			a, b, err := sys.SocketPair(typ)
 			if err != nil {
@@ -170,8 +188,9 @@ connects to Kafka, and forwards messages to Kafka.`,
 		binderTcpHandle, binderParentTcpHandle := mustSocketPair(syscall.SOCK_STREAM)
 		binderUdpHandle, binderParentUdpHandle := mustSocketPair(syscall.SOCK_STREAM)
 		binderRelpHandle, binderParentRelpHandle := mustSocketPair(syscall.SOCK_STREAM)
+		binderHttpHandle, binderParentHttpHandle := mustSocketPair(syscall.SOCK_STREAM)
 
-		err = sys.Binder([]int{binderParentHandle, binderParentTcpHandle, binderParentUdpHandle, binderParentRelpHandle}, logger) // returns immediately
+		err = sys.Binder([]int{binderParentHandle, binderParentTcpHandle, binderParentUdpHandle, binderParentRelpHandle, binderParentHttpHandle}, pool, logger) // returns immediately
 		if err != nil {
 			logger.Crit("Error setting the root binder", "error", err)
 			os.Exit(-1)
@@ -195,8 +214,15 @@ connects to Kafka, and forwards messages to Kafka.`,
 		loggerAuditHandle, loggerParentAuditHandle := mustSocketPair(syscall.SOCK_DGRAM)
 		loggerAuditConn := getLoggerConn(loggerParentAuditHandle)
 
+		loggerHttpHandle, loggerParentHttpHandle := mustSocketPair(syscall.SOCK_DGRAM)
+		loggerHttpConn := getLoggerConn(loggerParentHttpHandle)
+
+		loggerWatcherHandle, loggerParentWatcherHandle := mustSocketPair(syscall.SOCK_DGRAM)
+		loggerWatcherConn := getLoggerConn(loggerParentWatcherHandle)
+
 		utils.LogReceiver(context.Background(), rootlogger, []net.Conn{
-			loggerChildConn, loggerTcpConn, loggerUdpConn, loggerRelpConn, loggerJournalConn, loggerAuditConn,
+			loggerChildConn, loggerTcpConn, loggerUdpConn, loggerRelpConn, loggerJournalConn, loggerAuditConn, loggerHttpConn,
+			loggerWatcherConn,
 		})
 
 		logger.Debug("Target user", "uid", numuid, "gid", numgid)
@@ -225,6 +251,9 @@ connects to Kafka, and forwards messages to Kafka.`,
 				os.NewFile(uintptr(loggerRelpHandle), "relp_logger_file"),
 				os.NewFile(uintptr(loggerJournalHandle), "journal_logger_file"),
 				os.NewFile(uintptr(loggerAuditHandle), "audit_logger_file"),
+				os.NewFile(uintptr(binderHttpHandle), "http_binder_file"),
+				os.NewFile(uintptr(loggerHttpHandle), "http_logger_file"),
+				os.NewFile(uintptr(loggerWatcherHandle), "watcher_logger_file"),
 			},
 			Env: []string{"SKEWER_CHILD=TRUE", "PATH=/bin:/usr/bin"},
 		}
@@ -246,28 +275,121 @@ connects to Kafka, and forwards messages to Kafka.`,
 		syscall.Close(loggerRelpHandle)
 		syscall.Close(loggerJournalHandle)
 		syscall.Close(loggerAuditHandle)
+		syscall.Close(binderHttpHandle)
+		syscall.Close(loggerHttpHandle)
+		syscall.Close(loggerWatcherHandle)
+
+		// upgrade starts the new skewer binary and sends it the listening
+		// sockets over a socketpair. The new binary answers when it has
+		// inherited them, then waits until the connection is closed: the
+		// Store can only be opened by one process, so the new child can
+		// not start before the old one has stopped. On error, the new
+		// binary is killed and the current child keeps running.
+		upgrade := func() (conn *net.UnixConn, err error) {
+			// the executable may have been replaced on disk: look it up
+			// again instead of using /proc/self/exe
+			newExe, err := exec.LookPath(os.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			parentHandle, childHandle, err := sys.SocketPair(syscall.SOCK_STREAM)
+			if err != nil {
+				return nil, err
+			}
+			childFile := os.NewFile(uintptr(childHandle), "upgrade")
+			defer childFile.Close()
+			parentFile := os.NewFile(uintptr(parentHandle), "upgrade")
+			genconn, err := net.FileConn(parentFile)
+			parentFile.Close()
+			if err != nil {
+				return nil, err
+			}
+			conn = genconn.(*net.UnixConn)
+
+			newProcess := exec.Command(newExe)
+			newProcess.Args = os.Args
+			newProcess.Stdin = os.Stdin
+			newProcess.Stdout = os.Stdout
+			newProcess.Stderr = os.Stderr
+			newProcess.ExtraFiles = []*os.File{childFile}
+			newProcess.Env = append(os.Environ(), "SKEWER_UPGRADE_FD=3")
+			err = newProcess.Start()
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			defer func() {
+				if err != nil {
+					conn.Close()
+					newProcess.Process.Kill()
+					newProcess.Wait()
+					pool.CancelHandover()
+				}
+			}()
+			err = pool.HandoverTo(conn)
+			if err != nil {
+				return nil, err
+			}
+			conn.SetReadDeadline(time.Now().Add(upgradeTimeout))
+			answer, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				return nil, err
+			}
+			if strings.TrimSpace(answer) != "ready" {
+				return nil, fmt.Errorf("Unexpected answer from the new skewer binary: %s", answer)
+			}
+			conn.SetReadDeadline(time.Time{})
+			return conn, nil
+		}
 
 		sig_chan := make(chan os.Signal, 10)
 		once := sync.Once{}
+		var handoverConn *net.UnixConn
 		go func() {
+			stopping := false
 			for sig := range sig_chan {
 				logger.Debug("parent received signal", "signal", sig)
 				if sig == syscall.SIGTERM {
+					stopping = true
 					once.Do(func() { childProcess.Process.Signal(sig) })
 				} else if sig == syscall.SIGHUP {
 					childProcess.Process.Signal(sig)
+				} else if sig == syscall.SIGUSR2 && !stopping {
+					logger.Info("Upgrading skewer")
+					conn, err := upgrade()
+					if err != nil {
+						logger.Error("Upgrade failed, the current skewer keeps running", "error", err)
+						continue
+					}
+					// the new binary has the sockets: stop the child
+					// (with the usual drain), then let the new binary go on
+					stopping = true
+					once.Do(func() {
+						handoverConn = conn
+						childProcess.Process.Signal(syscall.SIGTERM)
+					})
 				}
 			}
 		}()
-		signal.Notify(sig_chan, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGINT)
+		signal.Notify(sig_chan, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGINT, syscall.SIGUSR2)
 		logger.Debug("PIDs", "parent", os.Getpid(), "child", childProcess.Process.Pid)
 
 		childProcess.Process.Wait()
+		// wait for the signal handler to finish before reading handoverConn
+		once.Do(func() {})
+		if handoverConn != nil {
+			logger.Info("The new skewer binary takes over")
+			handoverConn.Close()
+		}
 		os.Exit(0)
 
 	},
 }
 
+// upgradeTimeout is how long the root parent waits for the new skewer
+// binary to take the listening sockets on SIGUSR2.
+const upgradeTimeout = 30 * time.Second
+
 var testFlag bool
 var syslogFlag bool
 var loglevelFlag string
@@ -399,13 +521,31 @@ func Serve() error {
 		forwarder.WaitFinished()
 	}
 
+	// drainForwarder stops the forwarder, but gives Kafka at most timeout to
+	// acknowledge the messages that have already been sent
+	drainForwarder := func(timeout time.Duration) {
+		forwarderMutex.Lock()
+		defer forwarderMutex.Unlock()
+		cancelForwarder()
+		finished := make(chan struct{})
+		go func() {
+			forwarder.WaitFinished()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(timeout):
+			logger.Warn("Drain timeout expired: some Kafka acknowledgments are still pending", "timeout", timeout)
+		}
+	}
+
 	startForwarder(c.Kafka)
 
 	defer func() {
 		// wait that the forwarder has been closed to shutdown the store
-		stopForwarder()   // after stopForwarder() has returned, no more ACK/NACK will be sent to the store
-		gCancel()         // stop the Store goroutines (close the inputs channel)
-		st.WaitFinished() // wait that the badger databases are correctly closed
+		drainForwarder(c.Kafka.DrainTimeout) // after drainForwarder() has returned, no more ACK/NACK should be sent to the store
+		gCancel()                            // stop the Store goroutines (the stash queue is flushed, the summary is logged)
+		st.WaitFinished()                    // wait that the badger databases are correctly closed
 		if registry != nil {
 			registry.WaitFinished() // wait that the services have been unregistered from Consul
 		}
@@ -415,13 +555,17 @@ func Serve() error {
 
 	sig_chan := make(chan os.Signal, 10)
 	signal.Notify(sig_chan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	// SIGUSR2 asks the root parent to upgrade
+	signal.Ignore(syscall.SIGUSR2)
 
 	// retrieve linux audit logs
 	var relpServicePlugin *services.NetworkPlugin
 	var tcpServicePlugin *services.NetworkPlugin
 	var udpServicePlugin *services.NetworkPlugin
+	var httpServicePlugin *services.NetworkPlugin
 	var auditServicePlugin *services.NetworkPlugin
 	var journalServicePlugin *services.NetworkPlugin
+	var watcherServicePlugin *services.NetworkPlugin
 
 	startAudit := func(curconf *conf.GConfig) {
 		if auditlogs.Supported {
@@ -554,11 +698,60 @@ func Serve() error {
 		}
 	}
 
+	startHTTP := func(curconf *conf.GConfig) {
+		httpServicePlugin = services.NewNetworkPlugin("http", st, 13, 14, metricStore, logger)
+		if httpServicePlugin == nil {
+			logger.Error("Error starting HTTP plugin")
+		} else {
+			httpServicePlugin.SetConf(curconf.Syslog, curconf.Parsers)
+			httpServicePlugin.SetKafkaConf(&curconf.Kafka)
+			httpServicePlugin.SetAuditConf(curconf.Audit)
+			httpinfos, err := httpServicePlugin.Start(testFlag)
+			if err != nil {
+				logger.Error("Error starting HTTP plugin", "error", err)
+			} else if len(httpinfos) == 0 {
+				logger.Info("HTTP plugin not started")
+			} else {
+				logger.Debug("HTTP plugin started", "listeners", len(httpinfos))
+			}
+		}
+	}
+
+	startWatcher := func(curconf *conf.GConfig) {
+		if len(curconf.Watchers) == 0 {
+			return
+		}
+		offsets, err := st.FileOffsets()
+		if err != nil {
+			logger.Warn("Can't read the offsets of the tailed files", "error", err)
+			offsets = map[string]*model.FileOffset{}
+		}
+		if watcherServicePlugin != nil {
+			// the last lines of the previous watcher may not be in the Store yet
+			for filename, offset := range watcherServicePlugin.FileOffsets() {
+				offsets[filename] = offset
+			}
+		}
+		watcherServicePlugin = services.NewNetworkPlugin("watcher", st, 0, 15, metricStore, logger)
+		watcherServicePlugin.SetConf([]*conf.SyslogConfig{}, curconf.Parsers)
+		watcherServicePlugin.SetKafkaConf(&curconf.Kafka)
+		watcherServicePlugin.SetAuditConf(curconf.Audit)
+		watcherServicePlugin.SetWatcherConf(curconf.Watchers, offsets)
+		_, err = watcherServicePlugin.Start(testFlag)
+		if err != nil {
+			logger.Error("Error starting watcher plugin", "error", err)
+		} else {
+			logger.Debug("Watcher plugin has been started", "watchers", len(curconf.Watchers))
+		}
+	}
+
 	startJournal(c)
+	startWatcher(c)
 	startAudit(c)
 	startRELP(c)
 	startTCP(c)
 	startUDP(c)
+	startHTTP(c)
 
 	stopTCP := func() {
 		if tcpServicePlugin == nil {
@@ -582,6 +775,14 @@ func Serve() error {
 		udpServicePlugin.WaitPluginShutdown()
 	}
 
+	stopHTTP := func() {
+		if httpServicePlugin == nil {
+			return
+		}
+		httpServicePlugin.Shutdown()
+		httpServicePlugin.WaitPluginShutdown()
+	}
+
 	stopRELP := func() {
 		if relpServicePlugin == nil {
 			return
@@ -601,6 +802,14 @@ func Serve() error {
 		}
 	}
 
+	stopWatcher := func() {
+		if watcherServicePlugin == nil {
+			return
+		}
+		watcherServicePlugin.Shutdown()
+		watcherServicePlugin.WaitPluginShutdown()
+	}
+
 	stopAudit := func() {
 		if auditlogs.Supported && auditServicePlugin != nil {
 			auditServicePlugin.Shutdown()
@@ -631,6 +840,14 @@ func Serve() error {
 			}()
 		}
 
+		// reset the file watcher
+		wg.Add(1)
+		go func() {
+			stopWatcher()
+			startWatcher(newConf)
+			wg.Done()
+		}()
+
 		if auditlogs.Supported {
 			wg.Add(1)
 			go func() {
@@ -663,6 +880,14 @@ func Serve() error {
 			startUDP(newConf)
 			wg.Done()
 		}()
+
+		// reset the HTTP service
+		wg.Add(1)
+		go func() {
+			stopHTTP()
+			startHTTP(newConf)
+			wg.Done()
+		}()
 		wg.Wait()
 	}
 
@@ -670,8 +895,10 @@ func Serve() error {
 	for {
 		select {
 		case <-shutdownCtx.Done():
-			logger.Info("Shutting down")
+			logger.Info("Shutting down", "drain_timeout", c.Kafka.DrainTimeout)
 
+			// the RELP plugin stops accepting connections, answers the
+			// pending transactions and sends 'serverclose' to the clients
 			stopRELP()
 			logger.Debug("The RELP service has been stopped")
 
@@ -681,12 +908,18 @@ func Serve() error {
 			stopAudit()
 			logger.Debug("Stopped linux audit service")
 
+			stopWatcher()
+			logger.Debug("Stopped file watcher service")
+
 			stopTCP()
 			logger.Debug("The TCP service has been stopped")
 
 			stopUDP()
 			logger.Debug("The UDP service has been stopped")
 
+			stopHTTP()
+			logger.Debug("The HTTP service has been stopped")
+
 			return nil
 
 		case _, more := <-updated:
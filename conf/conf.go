@@ -9,6 +9,11 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"text/template"
@@ -21,6 +26,7 @@ import (
 	"github.com/inconshreveable/log15"
 	"github.com/spf13/viper"
 	"github.com/stephane-martin/skewer/consul"
+	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/utils"
 )
 
@@ -85,14 +91,38 @@ type MetricsConfig struct {
 	Port    int    `mapstructure:"port" toml:"port"`
 }
 
+// WatcherConfig describes the files that are tailed by a [[watcher]] section.
+// Filename may be a glob pattern. Whence tells where to start reading the
+// files that were never read before: 0 for the beginning, 2 for the end.
 type WatcherConfig struct {
-	Filename string `mapstructure:"filename" toml:"filename"`
-	Whence   int    `mapstructure:"whence" toml:"whence"`
+	Filename      string        `mapstructure:"filename" toml:"filename" json:"filename"`
+	Whence        int           `mapstructure:"whence" toml:"whence" json:"whence"`
+	Format        string        `mapstructure:"format" toml:"format" json:"format"`
+	DontParseSD   bool          `mapstructure:"dont_parse_structured_data" toml:"dont_parse_structured_data" json:"dont_parse_structured_data"`
+	TopicTmpl     string        `mapstructure:"topic_tmpl" toml:"topic_tmpl" json:"topic_tmpl"`
+	TopicFunc     string        `mapstructure:"topic_function" toml:"topic_function" json:"topic_function"`
+	PartitionTmpl string        `mapstructure:"partition_key_tmpl" toml:"partition_key_tmpl" json:"partition_key_tmpl"`
+	PartitionFunc string        `mapstructure:"partition_key_func" toml:"partition_key_func" json:"partition_key_func"`
+	FilterFunc    string        `mapstructure:"filter_func" toml:"filter_func" json:"filter_func"`
+	BodyParser    string        `mapstructure:"body_parser" toml:"body_parser" json:"body_parser"`
+	BodyPairSep   string        `mapstructure:"body_pair_separator" toml:"body_pair_separator" json:"body_pair_separator"`
+	BodyKVSep     string        `mapstructure:"body_kv_separator" toml:"body_kv_separator" json:"body_kv_separator"`
+	BodyMaxSize   int           `mapstructure:"body_max_size" toml:"body_max_size" json:"body_max_size"`
+	BodyMaxDepth  int           `mapstructure:"body_max_depth" toml:"body_max_depth" json:"body_max_depth"`
+	MLStart       string        `mapstructure:"multiline_start" toml:"multiline_start" json:"multiline_start"`
+	MLContinue    string        `mapstructure:"multiline_continue" toml:"multiline_continue" json:"multiline_continue"`
+	MLMaxLines    int           `mapstructure:"multiline_max_lines" toml:"multiline_max_lines" json:"multiline_max_lines"`
+	MLTimeout     time.Duration `mapstructure:"multiline_timeout" toml:"multiline_timeout" json:"multiline_timeout"`
+	ConfID        string        `mapstructure:"-" toml:"-" json:"conf_id"`
 }
 
 type ParserConfig struct {
-	Name string `mapstructure:"name" toml:"name" json:"name"`
-	Func string `mapstructure:"func" toml:"func" json:"func"`
+	Name       string            `mapstructure:"name" toml:"name" json:"name"`
+	Type       string            `mapstructure:"type" toml:"type" json:"type"`
+	Func       string            `mapstructure:"func" toml:"func" json:"func"`
+	Pattern    string            `mapstructure:"pattern" toml:"pattern" json:"pattern"`
+	Patterns   map[string]string `mapstructure:"patterns" toml:"patterns" json:"patterns"`
+	TimeFormat string            `mapstructure:"time_format" toml:"time_format" json:"time_format"`
 }
 
 type StoreConfig struct {
@@ -211,6 +241,8 @@ type KafkaConfig struct {
 	KeyFile                  string        `mapstructure:"key_file" toml:"key_file" json:"key_file"`
 	CertFile                 string        `mapstructure:"cert_file" toml:"cert_file" json:"cert_file"`
 	Insecure                 bool          `mapstructure:"insecure" toml:"insecure" json:"insecure"`
+	RelpProducers            int           `mapstructure:"relp_producers" toml:"relp_producers" json:"relp_producers"`
+	DrainTimeout             time.Duration `mapstructure:"drain_timeout" toml:"drain_timeout" json:"drain_timeout"`
 }
 
 type JournaldConfig struct {
@@ -243,30 +275,96 @@ type AuditConfig struct {
 }
 
 type SyslogConfig struct {
-	Port            int           `mapstructure:"port" toml:"port" json:"port"`
-	BindAddr        string        `mapstructure:"bind_addr" toml:"bind_addr" json:"bind_addr"`
-	UnixSocketPath  string        `mapstructure:"unix_socket_path" toml:"unix_socket_path" json:"unix_socket_path"`
-	Format          string        `mapstructure:"format" toml:"format" json:"format"`
-	TopicTmpl       string        `mapstructure:"topic_tmpl" toml:"topic_tmpl" json:"topic_tmpl"`
-	TopicFunc       string        `mapstructure:"topic_function" toml:"topic_function" json:"topic_function"`
-	PartitionTmpl   string        `mapstructure:"partition_key_tmpl" toml:"partition_key_tmpl" json:"partition_key_tmpl"`
-	PartitionFunc   string        `mapstructure:"partition_key_func" toml:"partition_key_func" json:"partition_key_func"`
-	FilterFunc      string        `mapstructure:"filter_func" toml:"filter_func" json:"filter_func"`
-	Protocol        string        `mapstructure:"protocol" toml:"protocol" json:"protocol"`
-	DontParseSD     bool          `mapstructure:"dont_parse_structured_data" toml:"dont_parse_structured_data" json:"dont_parse_structured_data"`
-	KeepAlive       bool          `mapstructure:"keepalive" toml:"keepalive" json:"keepalive"`
-	KeepAlivePeriod time.Duration `mapstructure:"keepalive_period" toml:"keepalive_period" json:"keepalive_period"`
-	Timeout         time.Duration `mapstructure:"timeout" toml:"timeout" json:"timeout"`
-	TLSEnabled      bool          `mapstructure:"tls_enabled" toml:"tls_enabled" json:"tls_enabled"`
-	CAFile          string        `mapstructure:"ca_file" toml:"ca_file" json:"ca_file"`
-	CAPath          string        `mapstructure:"ca_path" toml:"ca_path" json:"ca_path"`
-	KeyFile         string        `mapstructure:"key_file" toml:"key_file" json:"key_file"`
-	CertFile        string        `mapstructure:"cert_file" toml:"cert_file" json:"cert_file"`
-	ClientAuthType  string        `mapstructure:"client_auth_type" toml:"client_auth_type" json:"client_auth_type"`
-	ConfID          string        `mapstructure:"-" toml:"-" json:"conf_id"`
+	Port            int                 `mapstructure:"port" toml:"port" json:"port"`
+	BindAddr        string              `mapstructure:"bind_addr" toml:"bind_addr" json:"bind_addr"`
+	UnixSocketPath  string              `mapstructure:"unix_socket_path" toml:"unix_socket_path" json:"unix_socket_path"`
+	Format          string              `mapstructure:"format" toml:"format" json:"format"`
+	TopicTmpl       string              `mapstructure:"topic_tmpl" toml:"topic_tmpl" json:"topic_tmpl"`
+	TopicFunc       string              `mapstructure:"topic_function" toml:"topic_function" json:"topic_function"`
+	PartitionTmpl   string              `mapstructure:"partition_key_tmpl" toml:"partition_key_tmpl" json:"partition_key_tmpl"`
+	PartitionFunc   string              `mapstructure:"partition_key_func" toml:"partition_key_func" json:"partition_key_func"`
+	FilterFunc      string              `mapstructure:"filter_func" toml:"filter_func" json:"filter_func"`
+	Protocol        string              `mapstructure:"protocol" toml:"protocol" json:"protocol"`
+	DontParseSD     bool                `mapstructure:"dont_parse_structured_data" toml:"dont_parse_structured_data" json:"dont_parse_structured_data"`
+	KeepAlive       bool                `mapstructure:"keepalive" toml:"keepalive" json:"keepalive"`
+	KeepAlivePeriod time.Duration       `mapstructure:"keepalive_period" toml:"keepalive_period" json:"keepalive_period"`
+	Timeout         time.Duration       `mapstructure:"timeout" toml:"timeout" json:"timeout"`
+	TLSEnabled      bool                `mapstructure:"tls_enabled" toml:"tls_enabled" json:"tls_enabled"`
+	CAFile          string              `mapstructure:"ca_file" toml:"ca_file" json:"ca_file"`
+	CAPath          string              `mapstructure:"ca_path" toml:"ca_path" json:"ca_path"`
+	KeyFile         string              `mapstructure:"key_file" toml:"key_file" json:"key_file"`
+	CertFile        string              `mapstructure:"cert_file" toml:"cert_file" json:"cert_file"`
+	ClientAuthType  string              `mapstructure:"client_auth_type" toml:"client_auth_type" json:"client_auth_type"`
+	MinTLSVersion   string              `mapstructure:"min_tls_version" toml:"min_tls_version" json:"min_tls_version"`
+	CipherSuites    []string            `mapstructure:"cipher_suites" toml:"cipher_suites" json:"cipher_suites"`
+	Curves          []string            `mapstructure:"curves" toml:"curves" json:"curves"`
+	OCSPStapleFile  string              `mapstructure:"ocsp_staple_file" toml:"ocsp_staple_file" json:"ocsp_staple_file"`
+	WindowSize      int                 `mapstructure:"window_size" toml:"window_size" json:"window_size"`
+	Framing         string              `mapstructure:"framing" toml:"framing" json:"framing"`
+	MaxFrameSize    int                 `mapstructure:"max_frame_size" toml:"max_frame_size" json:"max_frame_size"`
+	UDPReaders      int                 `mapstructure:"udp_readers" toml:"udp_readers" json:"udp_readers"`
+	UDPWorkers      int                 `mapstructure:"udp_workers" toml:"udp_workers" json:"udp_workers"`
+	UDPRcvBuf       int                 `mapstructure:"udp_rcvbuf" toml:"udp_rcvbuf" json:"udp_rcvbuf"`
+	UnixProcInfo    bool                `mapstructure:"unix_proc_info" toml:"unix_proc_info" json:"unix_proc_info"`
+	SocketOwner     string              `mapstructure:"socket_owner" toml:"socket_owner" json:"socket_owner"`
+	SocketGroup     string              `mapstructure:"socket_group" toml:"socket_group" json:"socket_group"`
+	SocketMode      string              `mapstructure:"socket_mode" toml:"socket_mode" json:"socket_mode"`
+	ProcessRate     float64             `mapstructure:"process_rate_limit" toml:"process_rate_limit" json:"process_rate_limit"`
+	ProcessBurst    int                 `mapstructure:"process_rate_burst" toml:"process_rate_burst" json:"process_rate_burst"`
+	Allow           []string            `mapstructure:"allow" toml:"allow" json:"allow"`
+	Deny            []string            `mapstructure:"deny" toml:"deny" json:"deny"`
+	ClientRate      float64             `mapstructure:"client_rate_limit" toml:"client_rate_limit" json:"client_rate_limit"`
+	ClientBurst     int                 `mapstructure:"client_rate_burst" toml:"client_rate_burst" json:"client_rate_burst"`
+	ListenerRate    float64             `mapstructure:"listener_rate_limit" toml:"listener_rate_limit" json:"listener_rate_limit"`
+	ListenerBurst   int                 `mapstructure:"listener_rate_burst" toml:"listener_rate_burst" json:"listener_rate_burst"`
+	RateLimitAction string              `mapstructure:"rate_limit_action" toml:"rate_limit_action" json:"rate_limit_action"`
+	MaxConns        int                 `mapstructure:"max_connections" toml:"max_connections" json:"max_connections"`
+	MaxClientConns  int                 `mapstructure:"max_connections_per_client" toml:"max_connections_per_client" json:"max_connections_per_client"`
+	AcceptBackoff   time.Duration       `mapstructure:"accept_backoff" toml:"accept_backoff" json:"accept_backoff"`
+	MaxConnAge      time.Duration       `mapstructure:"max_connection_age" toml:"max_connection_age" json:"max_connection_age"`
+	HTTPTokens      []string            `mapstructure:"http_tokens" toml:"http_tokens" json:"http_tokens"`
+	HTTPMaxBodySize int64               `mapstructure:"http_max_body_size" toml:"http_max_body_size" json:"http_max_body_size"`
+	HTTPMaxInflight int                 `mapstructure:"http_max_inflight" toml:"http_max_inflight" json:"http_max_inflight"`
+	ProxyProtocol   bool                `mapstructure:"proxy_protocol" toml:"proxy_protocol" json:"proxy_protocol"`
+	ProxyFrom       []string            `mapstructure:"proxy_protocol_from" toml:"proxy_protocol_from" json:"proxy_protocol_from"`
+	TLSTopics       map[string][]string `mapstructure:"tls_topics" toml:"tls_topics" json:"tls_topics"`
+	SocketName      string              `mapstructure:"socket_name" toml:"socket_name" json:"socket_name"`
+	Timezone        string              `mapstructure:"timezone" toml:"timezone" json:"timezone"`
+	FutureTolerance time.Duration       `mapstructure:"future_tolerance" toml:"future_tolerance" json:"future_tolerance"`
+	BodyParser      string              `mapstructure:"body_parser" toml:"body_parser" json:"body_parser"`
+	BodyPairSep     string              `mapstructure:"body_pair_separator" toml:"body_pair_separator" json:"body_pair_separator"`
+	BodyKVSep       string              `mapstructure:"body_kv_separator" toml:"body_kv_separator" json:"body_kv_separator"`
+	BodyMaxSize     int                 `mapstructure:"body_max_size" toml:"body_max_size" json:"body_max_size"`
+	BodyMaxDepth    int                 `mapstructure:"body_max_depth" toml:"body_max_depth" json:"body_max_depth"`
+	MLStart         string              `mapstructure:"multiline_start" toml:"multiline_start" json:"multiline_start"`
+	MLContinue      string              `mapstructure:"multiline_continue" toml:"multiline_continue" json:"multiline_continue"`
+	MLMaxLines      int                 `mapstructure:"multiline_max_lines" toml:"multiline_max_lines" json:"multiline_max_lines"`
+	MLTimeout       time.Duration       `mapstructure:"multiline_timeout" toml:"multiline_timeout" json:"multiline_timeout"`
+	OnParseError    string              `mapstructure:"on_parse_error" toml:"on_parse_error" json:"on_parse_error"`
+	DeadletterTopic string              `mapstructure:"deadletter_topic" toml:"deadletter_topic" json:"deadletter_topic"`
+	ConfID          string              `mapstructure:"-" toml:"-" json:"conf_id"`
 	// todo: Partitioner ?
 }
 
+// TopicAllowed tells if a client identified by its TLS certificate may write
+// to topic. Without tls_topics, all the topics are allowed. Otherwise the CN
+// or one of the DNS names of the certificate must be listed, with a topic
+// pattern that matches.
+func (c *SyslogConfig) TopicAllowed(identities []string, topic string) bool {
+	if len(c.TLSTopics) == 0 {
+		return true
+	}
+	for _, identity := range identities {
+		// the keys have been lowercased by viper
+		for _, pattern := range c.TLSTopics[strings.ToLower(identity)] {
+			if ok, _ := path.Match(pattern, topic); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (c *SyslogConfig) GetClientAuthType() tls.ClientAuthType {
 	s := strings.TrimSpace(c.ClientAuthType)
 	if len(s) == 0 {
@@ -290,20 +388,236 @@ func (c *SyslogConfig) GetClientAuthType() tls.ClientAuthType {
 	}
 }
 
-func (c *SyslogConfig) GetListenAddr() (string, error) {
-	if len(c.UnixSocketPath) > 0 {
-		return "", nil
+// GetMinTLSVersion returns the oldest TLS version accepted by the listener.
+// It defaults to TLS 1.2.
+func (c *SyslogConfig) GetMinTLSVersion() (uint16, error) {
+	switch strings.TrimSpace(c.MinTLSVersion) {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("Unknown min_tls_version: %s", c.MinTLSVersion)
+	}
+}
+
+// GetCipherSuites returns the cipher suites enabled on the listener, or nil
+// for the Go defaults. The suites are given by their standard names, like
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. They do not apply to TLS 1.3.
+func (c *SyslogConfig) GetCipherSuites() ([]uint16, error) {
+	if len(c.CipherSuites) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
 	}
-	bindIP := net.ParseIP(c.BindAddr)
-	if bindIP == nil {
-		return "", fmt.Errorf("bind_addr is not an IP address: %s", c.BindAddr)
+	suites := []uint16{}
+	for _, name := range c.CipherSuites {
+		id, ok := known[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("Unknown cipher suite: %s", name)
+		}
+		suites = append(suites, id)
 	}
+	return suites, nil
+}
 
-	if bindIP.IsUnspecified() {
-		return fmt.Sprintf(":%d", c.Port), nil
-	} else {
-		return fmt.Sprintf("%s:%d", bindIP.String(), c.Port), nil
+// GetCurves returns the elliptic curves enabled on the listener, in order
+// of preference, or nil for the Go defaults.
+func (c *SyslogConfig) GetCurves() ([]tls.CurveID, error) {
+	if len(c.Curves) == 0 {
+		return nil, nil
 	}
+	curves := []tls.CurveID{}
+	for _, name := range c.Curves {
+		switch strings.Replace(strings.ToUpper(strings.TrimSpace(name)), "-", "", -1) {
+		case "X25519":
+			curves = append(curves, tls.X25519)
+		case "P256":
+			curves = append(curves, tls.CurveP256)
+		case "P384":
+			curves = append(curves, tls.CurveP384)
+		case "P521":
+			curves = append(curves, tls.CurveP521)
+		default:
+			return nil, fmt.Errorf("Unknown curve: %s", name)
+		}
+	}
+	return curves, nil
+}
+
+// ParseCIDRs parses a list of networks. A single IP address is accepted as
+// well.
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP address: %s", s)
+			}
+			if ip.To4() != nil {
+				s = s + "/32"
+			} else {
+				s = s + "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// GetSocketMode returns the permissions of the local syslog socket.
+func (c *SyslogConfig) GetSocketMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(c.SocketMode), 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("socket_mode is not a valid octal mode: %s", c.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// GetLocation returns the time zone of the RFC3164 timestamps: an IANA name
+// like "Europe/Paris", "Local" for the time zone of the server, or UTC when
+// timezone is empty.
+func (c *SyslogConfig) GetLocation() (*time.Location, error) {
+	tz := strings.TrimSpace(c.Timezone)
+	if len(tz) == 0 {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown timezone '%s': %s", c.Timezone, err)
+	}
+	return loc, nil
+}
+
+// GetBodyParser returns the parser of the MSG part of the messages, or nil
+// when body_parser is empty.
+func (c *SyslogConfig) GetBodyParser() (*model.BodyParser, error) {
+	return newBodyParser(c.BodyParser, c.BodyPairSep, c.BodyKVSep, c.BodyMaxSize, c.BodyMaxDepth)
+}
+
+// GetBodyParser returns the parser of the MSG part of the lines, or nil when
+// body_parser is empty.
+func (c *WatcherConfig) GetBodyParser() (*model.BodyParser, error) {
+	return newBodyParser(c.BodyParser, c.BodyPairSep, c.BodyKVSep, c.BodyMaxSize, c.BodyMaxDepth)
+}
+
+func newBodyParser(kind string, pairSep string, kvSep string, maxSize int, maxDepth int) (*model.BodyParser, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if len(kind) == 0 {
+		return nil, nil
+	}
+	return model.NewBodyParser(kind, pairSep, kvSep, maxSize, maxDepth)
+}
+
+// Deadletter tells if the message goes to the dead-letter topic: it could
+// not be parsed, and the on_parse_error policy of the section is deadletter.
+// The filter, topic and partition key functions are not applied to it.
+func (c *SyslogConfig) Deadletter(m *model.SyslogMessage) bool {
+	return c.OnParseError == "deadletter" && m.IsUnparsed()
+}
+
+// MultilineConfig are the settings of the multiline stage of a section.
+type MultilineConfig struct {
+	// Start matches the first line of a multiline message
+	Start *regexp.Regexp
+	// Continue matches the next lines
+	Continue *regexp.Regexp
+	MaxLines int
+	Timeout  time.Duration
+}
+
+// GetMultiline returns the multiline settings of the section, or nil when
+// neither multiline_start nor multiline_continue is set.
+func (c *SyslogConfig) GetMultiline() (*MultilineConfig, error) {
+	return newMultilineConfig(c.MLStart, c.MLContinue, c.MLMaxLines, c.MLTimeout)
+}
+
+// GetMultiline returns the multiline settings of the watcher, or nil when
+// neither multiline_start nor multiline_continue is set.
+func (c *WatcherConfig) GetMultiline() (*MultilineConfig, error) {
+	return newMultilineConfig(c.MLStart, c.MLContinue, c.MLMaxLines, c.MLTimeout)
+}
+
+func newMultilineConfig(start string, cont string, maxLines int, timeout time.Duration) (*MultilineConfig, error) {
+	if len(start) == 0 && len(cont) == 0 {
+		return nil, nil
+	}
+	ml := &MultilineConfig{MaxLines: maxLines, Timeout: timeout}
+	var err error
+	if len(start) > 0 {
+		ml.Start, err = regexp.Compile(start)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid multiline_start: %s", err)
+		}
+	}
+	if len(cont) > 0 {
+		ml.Continue, err = regexp.Compile(cont)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid multiline_continue: %s", err)
+		}
+	}
+	if ml.MaxLines <= 0 {
+		ml.MaxLines = 500
+	}
+	if ml.Timeout <= 0 {
+		ml.Timeout = time.Second
+	}
+	return ml, nil
+}
+
+// GetListenAddrs returns the addresses the listener should bind to.
+// bind_addr is a comma separated list of IP addresses (v4 or v6) and
+// hostnames. The hostnames are resolved, and each of their addresses gets
+// its own socket.
+func (c *SyslogConfig) GetListenAddrs() ([]string, error) {
+	if len(c.UnixSocketPath) > 0 {
+		return []string{}, nil
+	}
+	port := strconv.FormatInt(int64(c.Port), 10)
+	addrs := []string{}
+	seen := map[string]bool{}
+	for _, host := range strings.Split(c.BindAddr, ",") {
+		host = strings.Trim(strings.TrimSpace(host), "[]")
+		if len(host) == 0 {
+			continue
+		}
+		ips := []net.IP{}
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			resolved, err := net.LookupIP(host)
+			if err != nil {
+				return nil, fmt.Errorf("bind_addr can not be resolved: %s (%s)", host, err.Error())
+			}
+			ips = append(ips, resolved...)
+		}
+		for _, ip := range ips {
+			addr := net.JoinHostPort(ip.String(), port)
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("bind_addr is empty")
+	}
+	return addrs, nil
 }
 
 func (c *SyslogConfig) Export() []byte {
@@ -724,20 +1038,41 @@ func (c *GConfig) Export() string {
 
 func (c *GConfig) Complete() (err error) {
 	parsersNames := map[string]bool{}
-	for _, parserConf := range c.Parsers {
+	for i, parserConf := range c.Parsers {
 		name := strings.TrimSpace(parserConf.Name)
-		switch name {
-		case "rfc5424", "rfc3164", "json", "auto":
+		switch {
+		case model.IsBuiltinFormat(name):
 			return ConfigurationCheckError{ErrString: "Parser configuration must not use a reserved name"}
-		case "":
+		case name == "":
 			return ConfigurationCheckError{ErrString: "Empty parser name"}
 		default:
 			if _, ok := parsersNames[name]; ok {
 				return ConfigurationCheckError{ErrString: "The same parser name is used multiple times"}
 			}
-			f := strings.TrimSpace(parserConf.Func)
-			if len(f) == 0 {
-				return ConfigurationCheckError{ErrString: "Empty parser func"}
+			switch strings.ToLower(strings.TrimSpace(parserConf.Type)) {
+			case "", "js":
+				c.Parsers[i].Type = "js"
+				f := strings.TrimSpace(parserConf.Func)
+				if len(f) == 0 {
+					return ConfigurationCheckError{ErrString: "Empty parser func"}
+				}
+			case "regex":
+				c.Parsers[i].Type = "regex"
+				_, err = model.NewRegexParser(name, parserConf.Pattern, parserConf.TimeFormat)
+				if err != nil {
+					return ConfigurationCheckError{ErrString: fmt.Sprintf("Invalid pattern for parser '%s'", name), Err: err}
+				}
+			case "grok":
+				c.Parsers[i].Type = "grok"
+				_, err = model.NewGrokParser(name, parserConf.Pattern, parserConf.Patterns, parserConf.TimeFormat)
+				if err != nil {
+					return ConfigurationCheckError{ErrString: fmt.Sprintf("Invalid pattern for parser '%s'", name), Err: err}
+				}
+			default:
+				return ConfigurationCheckError{ErrString: fmt.Sprintf("Unknown parser type '%s'", parserConf.Type)}
+			}
+			if c.Parsers[i].Type != "js" && len(strings.TrimSpace(parserConf.Pattern)) == 0 {
+				return ConfigurationCheckError{ErrString: "Empty parser pattern"}
 			}
 			parsersNames[name] = true
 		}
@@ -747,6 +1082,12 @@ func (c *GConfig) Complete() (err error) {
 	if err != nil {
 		return ConfigurationCheckError{ErrString: "Kafka version can't be parsed", Err: err}
 	}
+	if c.Kafka.RelpProducers <= 0 {
+		c.Kafka.RelpProducers = 1
+	}
+	if c.Kafka.DrainTimeout < 0 {
+		c.Kafka.DrainTimeout = 0
+	}
 
 	if len(c.Syslog) == 0 {
 		syslogConf := SyslogConfig{
@@ -762,7 +1103,21 @@ func (c *GConfig) Complete() (err error) {
 
 	for i, syslogConf := range c.Syslog {
 		switch syslogConf.Protocol {
-		case "relp", "tcp", "udp":
+		case "relp", "tcp", "udp", "http":
+		case "local":
+			if syslogConf.UnixSocketPath == "" {
+				c.Syslog[i].UnixSocketPath = "/dev/log"
+			}
+			if syslogConf.SocketMode == "" {
+				c.Syslog[i].SocketMode = "0666"
+			}
+			_, err = c.Syslog[i].GetSocketMode()
+			if err != nil {
+				return ConfigurationCheckError{Err: err}
+			}
+			if syslogConf.ProcessRate < 0 {
+				c.Syslog[i].ProcessRate = 0
+			}
 		default:
 			return ConfigurationCheckError{ErrString: "Unknown protocol"}
 		}
@@ -776,6 +1131,8 @@ func (c *GConfig) Complete() (err error) {
 					c.Syslog[i].Port = 2514
 				case "tcp", "udp":
 					c.Syslog[i].Port = 1514
+				case "http":
+					c.Syslog[i].Port = 3514
 				default:
 					return ConfigurationCheckError{ErrString: "Unknown protocol"}
 				}
@@ -797,6 +1154,155 @@ func (c *GConfig) Complete() (err error) {
 		if syslogConf.Timeout == 0 {
 			c.Syslog[i].Timeout = time.Minute
 		}
+		if syslogConf.WindowSize <= 0 {
+			c.Syslog[i].WindowSize = 128
+		}
+		switch strings.ToLower(strings.TrimSpace(syslogConf.Framing)) {
+		case "":
+			c.Syslog[i].Framing = model.DefaultFraming(c.Syslog[i].Format)
+		case "auto", "octet-counting", "lf", "crlf", "nul":
+			c.Syslog[i].Framing = strings.ToLower(strings.TrimSpace(syslogConf.Framing))
+		default:
+			return ConfigurationCheckError{ErrString: fmt.Sprintf("Unknown framing '%s'", syslogConf.Framing)}
+		}
+		if syslogConf.MaxFrameSize <= 0 {
+			c.Syslog[i].MaxFrameSize = 65536
+		}
+		if syslogConf.UDPReaders <= 0 {
+			c.Syslog[i].UDPReaders = 1
+		}
+		if syslogConf.UDPWorkers <= 0 {
+			c.Syslog[i].UDPWorkers = runtime.NumCPU()
+		}
+		if syslogConf.UDPRcvBuf < 0 {
+			c.Syslog[i].UDPRcvBuf = 0
+		}
+		_, err = ParseCIDRs(syslogConf.Allow)
+		if err != nil {
+			return ConfigurationCheckError{ErrString: "Invalid allow list", Err: err}
+		}
+		_, err = ParseCIDRs(syslogConf.Deny)
+		if err != nil {
+			return ConfigurationCheckError{ErrString: "Invalid deny list", Err: err}
+		}
+		if syslogConf.ProxyProtocol {
+			if syslogConf.Protocol != "tcp" && syslogConf.Protocol != "relp" {
+				return ConfigurationCheckError{ErrString: "proxy_protocol is only supported by the tcp and relp protocols"}
+			}
+			if syslogConf.UnixSocketPath != "" {
+				return ConfigurationCheckError{ErrString: "proxy_protocol is not supported on unix sockets"}
+			}
+			if len(syslogConf.ProxyFrom) == 0 {
+				return ConfigurationCheckError{ErrString: "proxy_protocol needs the list of the trusted load balancers in proxy_protocol_from"}
+			}
+		}
+		if syslogConf.TLSEnabled {
+			_, err = c.Syslog[i].GetMinTLSVersion()
+			if err != nil {
+				return ConfigurationCheckError{Err: err}
+			}
+			_, err = c.Syslog[i].GetCipherSuites()
+			if err != nil {
+				return ConfigurationCheckError{Err: err}
+			}
+			_, err = c.Syslog[i].GetCurves()
+			if err != nil {
+				return ConfigurationCheckError{Err: err}
+			}
+		}
+		if len(syslogConf.SocketName) > 0 {
+			if syslogConf.UnixSocketPath != "" || syslogConf.Protocol == "local" {
+				return ConfigurationCheckError{ErrString: "socket_name is not supported on unix sockets"}
+			}
+			if strings.ContainsAny(syslogConf.SocketName, ": #") {
+				return ConfigurationCheckError{ErrString: fmt.Sprintf("Invalid socket_name '%s'", syslogConf.SocketName)}
+			}
+		}
+		if len(syslogConf.TLSTopics) > 0 {
+			if !syslogConf.TLSEnabled || syslogConf.Protocol == "udp" || syslogConf.Protocol == "local" {
+				return ConfigurationCheckError{ErrString: "tls_topics needs a tcp, relp or http listener with TLS enabled"}
+			}
+			topics := map[string][]string{}
+			for identity, patterns := range syslogConf.TLSTopics {
+				for _, pattern := range patterns {
+					_, err = path.Match(pattern, "")
+					if err != nil {
+						return ConfigurationCheckError{ErrString: fmt.Sprintf("Invalid topic pattern '%s' in tls_topics", pattern), Err: err}
+					}
+				}
+				topics[strings.ToLower(identity)] = patterns
+			}
+			c.Syslog[i].TLSTopics = topics
+		}
+		_, err = ParseCIDRs(syslogConf.ProxyFrom)
+		if err != nil {
+			return ConfigurationCheckError{ErrString: "Invalid proxy_protocol_from list", Err: err}
+		}
+		if syslogConf.ClientRate < 0 {
+			c.Syslog[i].ClientRate = 0
+		}
+		if syslogConf.ListenerRate < 0 {
+			c.Syslog[i].ListenerRate = 0
+		}
+		if syslogConf.HTTPMaxBodySize <= 0 {
+			c.Syslog[i].HTTPMaxBodySize = 10 * 1024 * 1024
+		}
+		if syslogConf.HTTPMaxInflight <= 0 {
+			c.Syslog[i].HTTPMaxInflight = 64
+		}
+		if syslogConf.MaxConns < 0 {
+			c.Syslog[i].MaxConns = 0
+		}
+		if syslogConf.MaxClientConns < 0 {
+			c.Syslog[i].MaxClientConns = 0
+		}
+		if syslogConf.AcceptBackoff < 0 {
+			c.Syslog[i].AcceptBackoff = 0
+		}
+		if syslogConf.MaxConnAge < 0 {
+			c.Syslog[i].MaxConnAge = 0
+		}
+		if syslogConf.FutureTolerance <= 0 {
+			c.Syslog[i].FutureTolerance = 24 * time.Hour
+		}
+		_, err = c.Syslog[i].GetLocation()
+		if err != nil {
+			return ConfigurationCheckError{Err: err}
+		}
+		_, err = c.Syslog[i].GetBodyParser()
+		if err != nil {
+			return ConfigurationCheckError{ErrString: "Invalid body_parser", Err: err}
+		}
+		_, err = c.Syslog[i].GetMultiline()
+		if err != nil {
+			return ConfigurationCheckError{Err: err}
+		}
+		switch strings.ToLower(strings.TrimSpace(syslogConf.OnParseError)) {
+		case "":
+			c.Syslog[i].OnParseError = "drop"
+		case "drop", "wrap":
+			c.Syslog[i].OnParseError = strings.ToLower(strings.TrimSpace(syslogConf.OnParseError))
+		case "deadletter":
+			c.Syslog[i].OnParseError = "deadletter"
+			if !model.TopicNameIsValid(syslogConf.DeadletterTopic) {
+				return ConfigurationCheckError{ErrString: fmt.Sprintf("Invalid deadletter_topic '%s'", syslogConf.DeadletterTopic)}
+			}
+		default:
+			return ConfigurationCheckError{ErrString: fmt.Sprintf("Unknown on_parse_error '%s'", syslogConf.OnParseError)}
+		}
+		switch strings.ToLower(strings.TrimSpace(syslogConf.RateLimitAction)) {
+		case "":
+			c.Syslog[i].RateLimitAction = "drop"
+		case "drop", "delay", "disconnect":
+			c.Syslog[i].RateLimitAction = strings.ToLower(strings.TrimSpace(syslogConf.RateLimitAction))
+		default:
+			return ConfigurationCheckError{ErrString: fmt.Sprintf("Unknown rate_limit_action '%s'", syslogConf.RateLimitAction)}
+		}
+		if c.Syslog[i].RateLimitAction == "delay" && (syslogConf.Protocol == "udp" || syslogConf.Protocol == "local") {
+			// the datagrams would pile up in the socket buffer while the
+			// reader sleeps
+			return ConfigurationCheckError{ErrString: "rate_limit_action = \"delay\" is not supported by the udp and local listeners"}
+		}
 
 		if len(c.Syslog[i].TopicTmpl) > 0 {
 			_, err = template.New("topic").Parse(c.Syslog[i].TopicTmpl)
@@ -811,22 +1317,62 @@ func (c *GConfig) Complete() (err error) {
 			}
 		}
 
-		_, err = c.Syslog[i].GetListenAddr()
+		_, err = c.Syslog[i].GetListenAddrs()
 		if err != nil {
 			return ConfigurationCheckError{Err: err}
 		}
 	}
 
 	for _, syslogConf := range c.Syslog {
-		switch syslogConf.Format {
-		case "rfc5424", "rfc3164", "json", "auto":
-		default:
+		if !model.IsBuiltinFormat(syslogConf.Format) {
 			if _, ok := parsersNames[syslogConf.Format]; !ok {
 				return ConfigurationCheckError{ErrString: "Unknown syslog format"}
 			}
 		}
 	}
 
+	for i, watcherConf := range c.Watchers {
+		if strings.TrimSpace(watcherConf.Filename) == "" {
+			return ConfigurationCheckError{ErrString: "Empty watcher filename"}
+		}
+		if _, err := filepath.Match(watcherConf.Filename, ""); err != nil {
+			return ConfigurationCheckError{ErrString: "Invalid watcher filename pattern", Err: err}
+		}
+		if watcherConf.Whence != 0 && watcherConf.Whence != 2 {
+			return ConfigurationCheckError{ErrString: "Watcher whence must be 0 (start) or 2 (end)"}
+		}
+		if watcherConf.Format == "" {
+			c.Watchers[i].Format = "auto"
+		}
+		if !model.IsBuiltinFormat(c.Watchers[i].Format) {
+			if _, ok := parsersNames[c.Watchers[i].Format]; !ok {
+				return ConfigurationCheckError{ErrString: "Unknown watcher format"}
+			}
+		}
+		_, err = c.Watchers[i].GetBodyParser()
+		if err != nil {
+			return ConfigurationCheckError{ErrString: "Invalid watcher body_parser", Err: err}
+		}
+		_, err = c.Watchers[i].GetMultiline()
+		if err != nil {
+			return ConfigurationCheckError{Err: err}
+		}
+		if watcherConf.TopicTmpl == "" {
+			c.Watchers[i].TopicTmpl = "files-{{.Appname}}"
+		}
+		if watcherConf.PartitionTmpl == "" {
+			c.Watchers[i].PartitionTmpl = "pk-{{.Hostname}}"
+		}
+		_, err = template.New("watchertopic").Parse(c.Watchers[i].TopicTmpl)
+		if err != nil {
+			return ConfigurationCheckError{ErrString: "Error compiling the topic template", Err: err}
+		}
+		_, err = template.New("watcherpartition").Parse(c.Watchers[i].PartitionTmpl)
+		if err != nil {
+			return ConfigurationCheckError{ErrString: "Error compiling the partition key template", Err: err}
+		}
+	}
+
 	if c.Journald.Enabled {
 		var err error
 
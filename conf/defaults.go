@@ -87,6 +87,8 @@ func SetKafkaDefaults(v *viper.Viper, prefixed bool) {
 	v.SetDefault(prefix+"flush_messages_max", 0)
 	v.SetDefault(prefix+"retry_send_max", 3)
 	v.SetDefault(prefix+"retry_send_backoff", "100ms")
+	v.SetDefault(prefix+"relp_producers", 1)
+	v.SetDefault(prefix+"drain_timeout", "10s")
 }
 
 func SetStoreDefaults(v *viper.Viper, prefixed bool) {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -48,23 +49,21 @@ func NewService(ip string, port int, check string, tags []string) (*Service, err
 		s.Tags = tags
 	}
 
-	localIP, err := LocalIP()
-	if err != nil {
-		return nil, errwrap.Wrapf("Error when trying to get a local IP: {{err}}", err)
-	}
-
 	var parsedIP net.IP
 
-	ip = strings.TrimSpace(ip)
-	if len(ip) == 0 {
-		parsedIP = localIP
-	} else {
+	ip = strings.Trim(strings.TrimSpace(ip), "[]")
+	if len(ip) > 0 {
 		parsedIP = net.ParseIP(ip)
-		if parsedIP == nil {
-			parsedIP = localIP
-		}
 	}
-	if parsedIP.IsUnspecified() { // 0.0.0.0
+	if parsedIP == nil || parsedIP.IsUnspecified() { // 0.0.0.0 or ::
+		// a listener on :: is registered with an IPv6 address
+		localIP, err := LocalIP(parsedIP != nil && parsedIP.To4() == nil)
+		if err != nil {
+			return nil, errwrap.Wrapf("Error when trying to get a local IP: {{err}}", err)
+		}
+		if localIP == nil {
+			return nil, fmt.Errorf("No local IP address to register")
+		}
 		parsedIP = localIP
 	}
 	s.parsedIP = parsedIP
@@ -98,7 +97,7 @@ func (r *Registry) RegisterTcpListener(infos *model.ListenerInfo) {
 	if infos.BindAddr == "" || infos.Port == 0 || infos.Protocol == "" {
 		return
 	}
-	svc, err := NewService(infos.BindAddr, infos.Port, fmt.Sprintf("%s:%d", infos.BindAddr, infos.Port), []string{infos.Protocol})
+	svc, err := NewService(infos.BindAddr, infos.Port, net.JoinHostPort(infos.BindAddr, strconv.Itoa(infos.Port)), []string{infos.Protocol})
 	if err == nil {
 		action := ServiceAction{Action: REGISTER, Service: svc}
 		r.RegisterChan <- action
@@ -112,7 +111,7 @@ func (r *Registry) UnregisterTcpListener(infos *model.ListenerInfo) {
 	if infos.BindAddr == "" || infos.Port == 0 || infos.Protocol == "" {
 		return
 	}
-	svc, err := NewService(infos.BindAddr, infos.Port, fmt.Sprintf("%s:%d", infos.BindAddr, infos.Port), []string{infos.Protocol})
+	svc, err := NewService(infos.BindAddr, infos.Port, net.JoinHostPort(infos.BindAddr, strconv.Itoa(infos.Port)), []string{infos.Protocol})
 	if err == nil {
 		action := ServiceAction{Action: UNREGISTER, Service: svc}
 		r.RegisterChan <- action
@@ -9,19 +9,27 @@ import (
 	"github.com/hashicorp/errwrap"
 )
 
-func LocalIP() (net.IP, error) {
+// LocalIP returns a global unicast address of the host. When ipv6 is true,
+// an IPv6 address is preferred, otherwise an IPv4 address. The other family
+// is used if the preferred one is not available.
+func LocalIP(ipv6 bool) (net.IP, error) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return nil, err
 	}
+	var fallback net.IP
 	for _, addr := range addrs {
 		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
-			if ipnet.IP.To4() != nil || ipnet.IP.To16() != nil {
+			isIPv6 := ipnet.IP.To4() == nil
+			if isIPv6 == ipv6 {
 				return ipnet.IP, nil
 			}
+			if fallback == nil {
+				fallback = ipnet.IP
+			}
 		}
 	}
-	return nil, nil
+	return fallback, nil
 }
 
 type ConnParams struct {
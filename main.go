@@ -37,7 +37,7 @@ func main() {
 	}
 
 	switch name := os.Args[0]; name {
-	case "skewer-tcp", "skewer-udp", "skewer-relp", "skewer-journal", "skewer-audit":
+	case "skewer-tcp", "skewer-udp", "skewer-relp", "skewer-journal", "skewer-audit", "skewer-http", "skewer-watcher":
 		var binderClient *sys.BinderClient
 		var err error
 		loggerCtx, cancelLogger := context.WithCancel(context.Background())
@@ -55,7 +55,7 @@ func main() {
 			cancelLogger()
 			os.Exit(-1)
 		}
-		signal.Ignore(syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+		signal.Ignore(syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2)
 		svc := services.NetworkPluginProvider{}
 		if len(os.Args) >= 2 {
 			if os.Args[1] == "--test" {
@@ -14,8 +14,14 @@ type Metrics struct {
 	IncomingMsgsCounter         *prometheus.CounterVec
 	ClientConnectionCounter     *prometheus.CounterVec
 	ParsingErrorCounter         *prometheus.CounterVec
+	FramingErrorsCounter        *prometheus.CounterVec
+	UdpKernelDropsCounter       *prometheus.CounterVec
+	RateLimitedCounter          *prometheus.CounterVec
+	AclDecisionsCounter         *prometheus.CounterVec
+	RejectedConnectionsCounter  *prometheus.CounterVec
 	RelpAnswersCounter          *prometheus.CounterVec
 	RelpProtocolErrorsCounter   *prometheus.CounterVec
+	RelpInflightGauge           *prometheus.GaugeVec
 	KafkaConnectionErrorCounter prometheus.Counter
 	KafkaAckNackCounter         *prometheus.CounterVec
 	MessageFilteringCounter     *prometheus.CounterVec
@@ -57,6 +63,46 @@ func SetupMetrics(c conf.MetricsConfig) *Metrics {
 		[]string{"parser_name", "client"},
 	)
 
+	m.FramingErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "framing_errors_total",
+			Help: "total number of oversized or malformed stream frames",
+		},
+		[]string{"kind"},
+	)
+
+	m.UdpKernelDropsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "udp_kernel_drops_total",
+			Help: "number of UDP datagrams dropped by the kernel",
+		},
+		[]string{"port"},
+	)
+
+	m.RateLimitedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ratelimited_messages_total",
+			Help: "number of messages dropped, delayed or disconnected by the rate limits",
+		},
+		[]string{"protocol", "action"},
+	)
+
+	m.AclDecisionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "acl_decisions_total",
+			Help: "number of clients or datagrams allowed or denied by the allow and deny lists",
+		},
+		[]string{"protocol", "decision"},
+	)
+
+	m.RejectedConnectionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rejected_connections_total",
+			Help: "number of client connections refused because of the connection limits",
+		},
+		[]string{"protocol", "reason"},
+	)
+
 	m.RelpAnswersCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "relp_answers_total",
@@ -73,6 +119,14 @@ func SetupMetrics(c conf.MetricsConfig) *Metrics {
 		[]string{"client"},
 	)
 
+	m.RelpInflightGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "relp_inflight_gauge",
+			Help: "number of unacknowledged RELP transactions",
+		},
+		[]string{"client"},
+	)
+
 	m.KafkaConnectionErrorCounter = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "kafka_connection_errors_total",
@@ -100,8 +154,14 @@ func SetupMetrics(c conf.MetricsConfig) *Metrics {
 	prometheus.MustRegister(m.IncomingMsgsCounter)
 	prometheus.MustRegister(m.ClientConnectionCounter)
 	prometheus.MustRegister(m.ParsingErrorCounter)
+	prometheus.MustRegister(m.FramingErrorsCounter)
+	prometheus.MustRegister(m.UdpKernelDropsCounter)
+	prometheus.MustRegister(m.RateLimitedCounter)
+	prometheus.MustRegister(m.AclDecisionsCounter)
+	prometheus.MustRegister(m.RejectedConnectionsCounter)
 	prometheus.MustRegister(m.RelpAnswersCounter)
 	prometheus.MustRegister(m.RelpProtocolErrorsCounter)
+	prometheus.MustRegister(m.RelpInflightGauge)
 	prometheus.MustRegister(m.KafkaConnectionErrorCounter)
 	prometheus.MustRegister(m.KafkaAckNackCounter)
 	prometheus.MustRegister(m.MessageFilteringCounter)
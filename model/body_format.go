@@ -0,0 +1,283 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultBodyMaxSize  = 65536
+	DefaultBodyMaxDepth = 10
+)
+
+// BodyParser extracts the key/values of the MSG part of the messages, after
+// the envelope has been parsed. The result is stored in Properties["body"].
+//
+// logfmt: key=value key="quoted value" flag (a bare key is true)
+// kv: key/value pairs with configurable separators, values may be quoted
+// json: the first JSON object found in MSG
+// cef, leef: the CEF or LEEF event found in MSG
+type BodyParser struct {
+	kind     string
+	pairSep  string
+	kvSep    string
+	maxSize  int
+	maxDepth int
+}
+
+// NewBodyParser returns a body parser. pairSep and kvSep are only used by the
+// kv parser, and default to " " and "=". Bodies larger than maxSize bytes are
+// not parsed, and JSON objects nested deeper than maxDepth are rejected.
+func NewBodyParser(kind string, pairSep string, kvSep string, maxSize int, maxDepth int) (*BodyParser, error) {
+	switch kind {
+	case "logfmt", "json", "cef", "leef":
+	case "kv":
+		if len(pairSep) == 0 {
+			pairSep = " "
+		}
+		if len(kvSep) == 0 {
+			kvSep = "="
+		}
+		if pairSep == kvSep {
+			return nil, fmt.Errorf("The kv pair separator and key/value separator must be different")
+		}
+	default:
+		return nil, fmt.Errorf("Unknown body parser '%s'", kind)
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultBodyMaxSize
+	}
+	if maxDepth <= 0 {
+		maxDepth = DefaultBodyMaxDepth
+	}
+	return &BodyParser{kind: kind, pairSep: pairSep, kvSep: kvSep, maxSize: maxSize, maxDepth: maxDepth}, nil
+}
+
+// Extract parses the Message of sm and stores the result in
+// Properties["body"]. When the body can not be parsed, sm is left unchanged.
+func (p *BodyParser) Extract(sm *SyslogMessage) error {
+	if len(sm.Message) > p.maxSize {
+		return &InvalidBodyError{Format: p.kind, Message: fmt.Sprintf("the body is larger than %d bytes", p.maxSize)}
+	}
+	var body map[string]interface{}
+	var err error
+	switch p.kind {
+	case "logfmt":
+		body, err = parseLogfmt(sm.Message)
+	case "kv":
+		body, err = p.parseKV(sm.Message)
+	case "json":
+		body, err = p.parseJSON(sm.Message)
+	case "cef":
+		idx := strings.Index(sm.Message, "CEF:")
+		if idx < 0 {
+			return &InvalidBodyError{Format: p.kind, Message: "no CEF event"}
+		}
+		body, err = ParseCEF(sm.Message[idx:])
+	case "leef":
+		idx := strings.Index(sm.Message, "LEEF:")
+		if idx < 0 {
+			return &InvalidBodyError{Format: p.kind, Message: "no LEEF event"}
+		}
+		body, err = ParseLEEF(sm.Message[idx:])
+	}
+	if err != nil {
+		return err
+	}
+	if sm.Properties == nil {
+		sm.Properties = map[string]interface{}{}
+	}
+	sm.Properties["body"] = body
+	return nil
+}
+
+// parseLogfmt parses the key=value pairs of a logfmt body. The values can be
+// double quoted, with backslash escapes. A key without a value is true.
+func parseLogfmt(m string) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	pairs := 0
+	i := 0
+	for i < len(m) {
+		if m[i] <= ' ' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(m) && m[i] > ' ' && m[i] != '=' && m[i] != '"' {
+			i++
+		}
+		key := m[start:i]
+		if len(key) == 0 {
+			// garbage: skip to the next space
+			for i < len(m) && m[i] > ' ' {
+				i++
+			}
+			continue
+		}
+		if i == len(m) || m[i] != '=' {
+			body[key] = true
+			continue
+		}
+		i++
+		pairs++
+		if i < len(m) && m[i] == '"' {
+			value, n, err := unquoteBody(m[i:])
+			if err != nil {
+				return nil, &InvalidBodyError{Format: "logfmt", Message: fmt.Sprintf("value of '%s': %s", key, err)}
+			}
+			body[key] = value
+			i += n
+			continue
+		}
+		start = i
+		for i < len(m) && m[i] > ' ' {
+			i++
+		}
+		body[key] = m[start:i]
+	}
+	if pairs == 0 {
+		return nil, &InvalidBodyError{Format: "logfmt", Message: "no key=value pair"}
+	}
+	return body, nil
+}
+
+// parseKV splits the body on the pair separator, then each pair on the first
+// key/value separator. The separators are ignored inside double quotes.
+func (p *BodyParser) parseKV(m string) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	for _, pair := range splitOutsideQuotes(m, p.pairSep) {
+		kv := strings.SplitN(pair, p.kvSep, 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || len(key) == 0 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		if len(value) >= 2 && value[0] == '"' {
+			if unquoted, n, err := unquoteBody(value); err == nil && n == len(value) {
+				value = unquoted
+			}
+		}
+		body[key] = value
+	}
+	if len(body) == 0 {
+		return nil, &InvalidBodyError{Format: "kv", Message: "no key/value pair"}
+	}
+	return body, nil
+}
+
+// parseJSON decodes the first JSON object of the body. The text before the
+// object and after its end is ignored.
+func (p *BodyParser) parseJSON(m string) (map[string]interface{}, error) {
+	idx := strings.IndexByte(m, '{')
+	if idx < 0 {
+		return nil, &InvalidBodyError{Format: "json", Message: "no JSON object"}
+	}
+	m = m[idx:]
+	if jsonDepth(m) > p.maxDepth {
+		return nil, &InvalidBodyError{Format: "json", Message: fmt.Sprintf("the object is nested deeper than %d levels", p.maxDepth)}
+	}
+	body := map[string]interface{}{}
+	err := json.NewDecoder(strings.NewReader(m)).Decode(&body)
+	if err != nil {
+		return nil, &InvalidBodyError{Format: "json", Message: err.Error()}
+	}
+	return body, nil
+}
+
+// jsonDepth returns the nesting depth of the JSON value at the start of m,
+// without decoding it.
+func jsonDepth(m string) int {
+	depth := 0
+	max := 0
+	inString := false
+	escaped := false
+	for i := 0; i < len(m); i++ {
+		c := m[i]
+		if inString {
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > max {
+				max = depth
+			}
+		case '}', ']':
+			depth--
+			if depth <= 0 {
+				return max
+			}
+		}
+	}
+	return max
+}
+
+// unquoteBody reads the double quoted string at the start of m. It returns the
+// unescaped value and the number of bytes read.
+func unquoteBody(m string) (string, int, error) {
+	var b bytes.Buffer
+	for i := 1; i < len(m); i++ {
+		switch m[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(m) {
+				return "", 0, fmt.Errorf("unterminated quoted string")
+			}
+			i++
+			switch m[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 < len(m) {
+					if r, err := strconv.ParseUint(m[i+1:i+5], 16, 16); err == nil {
+						b.WriteRune(rune(r))
+						i += 4
+						continue
+					}
+				}
+				b.WriteString(`\u`)
+			default:
+				b.WriteByte(m[i])
+			}
+		default:
+			b.WriteByte(m[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted string")
+}
+
+func splitOutsideQuotes(m string, sep string) []string {
+	parts := []string{}
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(m); i++ {
+		switch {
+		case inQuotes && m[i] == '\\':
+			i++
+		case m[i] == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && strings.HasPrefix(m[i:], sep):
+			parts = append(parts, m[start:i])
+			i += len(sep) - 1
+			start = i + 1
+		}
+	}
+	return append(parts, m[start:])
+}
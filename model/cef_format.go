@@ -0,0 +1,150 @@
+package model
+
+import (
+	"bytes"
+	"strings"
+)
+
+var cefHeaderFields = []string{
+	"version",
+	"device_vendor",
+	"device_product",
+	"device_version",
+	"signature_id",
+	"name",
+	"severity",
+}
+
+// ParseCEF parses an ArcSight CEF event:
+//
+// CEF:Version|Device Vendor|Device Product|Device Version|Signature ID|Name|Severity|Extension
+//
+// The header fields are returned by name (device_vendor, signature_id...),
+// and the extension key/values in "extension". In the header, '|' and '\'
+// are escaped. In the extension, '=', '\', newlines and carriage returns
+// are escaped.
+func ParseCEF(m string) (map[string]interface{}, error) {
+	m = strings.TrimSpace(m)
+	if !strings.HasPrefix(m, "CEF:") {
+		return nil, &InvalidCEFError{"the message does not start with 'CEF:'"}
+	}
+	m = m[4:]
+	fields := map[string]interface{}{}
+	for _, name := range cefHeaderFields {
+		value, rest, found := cefHeaderField(m)
+		if !found {
+			if name == "severity" {
+				// no extension, and no trailing '|'
+				fields[name] = value
+				m = ""
+				break
+			}
+			return nil, &InvalidCEFError{"the header has less than 7 fields"}
+		}
+		fields[name] = value
+		m = rest
+	}
+	fields["extension"] = parseCEFExtension(m)
+	return fields, nil
+}
+
+// cefHeaderField returns the unescaped header field at the start of m, and
+// the rest of m after the '|' separator.
+func cefHeaderField(m string) (string, string, bool) {
+	var value bytes.Buffer
+	for i := 0; i < len(m); i++ {
+		switch m[i] {
+		case '\\':
+			if i+1 < len(m) && (m[i+1] == '|' || m[i+1] == '\\') {
+				i++
+			}
+			value.WriteByte(m[i])
+		case '|':
+			return value.String(), m[i+1:], true
+		default:
+			value.WriteByte(m[i])
+		}
+	}
+	return value.String(), "", false
+}
+
+// parseCEFExtension parses the space separated key=value pairs of a CEF
+// extension. The values may contain spaces: a value ends at the space
+// before the next key.
+func parseCEFExtension(ext string) map[string]interface{} {
+	type keyPos struct {
+		start int
+		eq    int
+	}
+	keys := []keyPos{}
+	for i := 0; i < len(ext); i++ {
+		if ext[i] == '\\' {
+			i++
+			continue
+		}
+		if ext[i] != '=' {
+			continue
+		}
+		start := i
+		for start > 0 && ext[start-1] != ' ' {
+			start--
+		}
+		if start == i || !isCEFKey(ext[start:i]) {
+			continue
+		}
+		if len(keys) > 0 && start <= keys[len(keys)-1].eq {
+			// an unescaped '=' inside a value
+			continue
+		}
+		keys = append(keys, keyPos{start: start, eq: i})
+	}
+	extension := map[string]interface{}{}
+	for n, k := range keys {
+		end := len(ext)
+		if n+1 < len(keys) {
+			end = keys[n+1].start - 1
+		}
+		value := ext[k.eq+1 : end]
+		if n+1 == len(keys) {
+			value = strings.TrimRight(value, " \r\n")
+		}
+		extension[ext[k.start:k.eq]] = unescapeCEFValue(value)
+	}
+	return extension
+}
+
+func isCEFKey(key string) bool {
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '.' || c == '-' || c == '[' || c == ']') {
+			return false
+		}
+	}
+	return true
+}
+
+func unescapeCEFValue(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		switch value[i+1] {
+		case '=', '\\', '|':
+			b.WriteByte(value[i+1])
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(value[i+1])
+		}
+		i++
+	}
+	return b.String()
+}
@@ -49,21 +49,6 @@ func (e *TimeError) Error() string {
 func (e *TimeError) Json()    {}
 func (e *TimeError) Parsing() {}
 
-type InvalidStructuredDataError struct {
-	Message string
-}
-
-func (e *InvalidStructuredDataError) Error() string {
-	if len(e.Message) > 0 {
-		return fmt.Sprintf("Invalid structured data: %s", e.Message)
-	} else {
-		return "Invalid structure data"
-	}
-}
-
-func (e *InvalidStructuredDataError) Rfc5424() {}
-func (e *InvalidStructuredDataError) Parsing() {}
-
 type InvalidPriorityError struct{}
 
 func (e *InvalidPriorityError) Error() string {
@@ -74,16 +59,20 @@ func (e *InvalidPriorityError) Rfc5424() {}
 func (e *InvalidPriorityError) Json()    {}
 func (e *InvalidPriorityError) Parsing() {}
 
-type NotEnoughPartsError struct {
-	NbParts int
+// Rfc5424Error is returned when a message can not be parsed as RFC5424.
+// Pos is the byte offset in the message where the problem was found.
+type Rfc5424Error struct {
+	Pos     int
+	Field   string
+	Message string
 }
 
-func (e *NotEnoughPartsError) Error() string {
-	return fmt.Sprintf("The message does not have enough parts: %d, but minimum is 7", e.NbParts)
+func (e *Rfc5424Error) Error() string {
+	return fmt.Sprintf("Invalid RFC5424 message at position %d (%s): %s", e.Pos, e.Field, e.Message)
 }
 
-func (e *NotEnoughPartsError) Rfc5424() {}
-func (e *NotEnoughPartsError) Parsing() {}
+func (e *Rfc5424Error) Rfc5424() {}
+func (e *Rfc5424Error) Parsing() {}
 
 type JSParsingError struct {
 	Message    string
@@ -97,6 +86,23 @@ func (e *JSParsingError) Error() string {
 func (e *JSParsingError) Parsing()    {}
 func (e *JSParsingError) Javascript() {}
 
+// PatternParsingError is returned when a regex or grok parser does not
+// match a message, or when a capture can not be converted.
+type PatternParsingError struct {
+	ParserName string
+	Field      string
+	Err        error
+}
+
+func (e *PatternParsingError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("The message does not match the pattern of the parser '%s'", e.ParserName)
+	}
+	return fmt.Sprintf("The parser '%s' could not convert the field '%s': %s", e.ParserName, e.Field, e.Err.Error())
+}
+
+func (e *PatternParsingError) Parsing() {}
+
 type InvalidTopic struct {
 	Topic string
 }
@@ -104,3 +110,42 @@ type InvalidTopic struct {
 func (e *InvalidTopic) Error() string {
 	return fmt.Sprintf("The topic name is invalid: '%s'", e.Topic)
 }
+
+type InvalidGelfError struct {
+	Message string
+}
+
+func (e *InvalidGelfError) Error() string {
+	return fmt.Sprintf("Invalid GELF message: %s", e.Message)
+}
+
+func (e *InvalidGelfError) Parsing() {}
+
+type InvalidCEFError struct {
+	Message string
+}
+
+func (e *InvalidCEFError) Error() string {
+	return fmt.Sprintf("Invalid CEF message: %s", e.Message)
+}
+
+func (e *InvalidCEFError) Parsing() {}
+
+type InvalidLEEFError struct {
+	Message string
+}
+
+func (e *InvalidLEEFError) Error() string {
+	return fmt.Sprintf("Invalid LEEF message: %s", e.Message)
+}
+
+func (e *InvalidLEEFError) Parsing() {}
+
+type InvalidBodyError struct {
+	Format  string
+	Message string
+}
+
+func (e *InvalidBodyError) Error() string {
+	return fmt.Sprintf("Invalid %s body: %s", e.Format, e.Message)
+}
@@ -0,0 +1,48 @@
+// +build gofuzz
+
+package model
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// FuzzRfc5424 is the entry point for go-fuzz:
+//
+//	go-fuzz-build -func FuzzRfc5424 github.com/stephane-martin/skewer/model
+//	go-fuzz -bin model-fuzz.zip -workdir model/testdata/fuzz/rfc5424
+//
+// The messages accepted by the strict parser must be accepted by the
+// lenient parser, with the same header and structured data.
+func FuzzRfc5424(data []byte) int {
+	m := string(data)
+	lenient, lenientErr := ParseRfc5424Format(m, false)
+	strict, strictErr := ParseRfc5424StrictFormat(m, false)
+	if strictErr != nil {
+		if _, ok := strictErr.(*Rfc5424Error); !ok {
+			panic(fmt.Sprintf("unexpected error type: %T", strictErr))
+		}
+		if lenientErr == nil {
+			return 0
+		}
+		return -1
+	}
+	if lenientErr != nil {
+		panic(fmt.Sprintf("accepted by the strict parser only: %s", lenientErr))
+	}
+	if strict.Priority != lenient.Priority || strict.Version != lenient.Version {
+		panic("strict and lenient headers differ")
+	}
+	// with NILVALUE, TimeReported is the parsing time
+	nilTime := strict.TimeReported.Equal(strict.TimeGenerated) && lenient.TimeReported.Equal(lenient.TimeGenerated)
+	if !nilTime && !strict.TimeReported.Equal(lenient.TimeReported) {
+		panic("strict and lenient timestamps differ")
+	}
+	if strict.Hostname != lenient.Hostname || strict.Appname != lenient.Appname || strict.Procid != lenient.Procid || strict.Msgid != lenient.Msgid {
+		panic("strict and lenient headers differ")
+	}
+	if !reflect.DeepEqual(strict.Properties, lenient.Properties) {
+		panic("strict and lenient structured data differ")
+	}
+	return 1
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+)
+
+// ParseGelfFormat parses a GELF message (as sent by Graylog clients and by
+// the Docker gelf log driver).
+//
+// host -> Hostname
+// full_message, or else short_message -> Message
+// level -> Severity (default 1, alert)
+// facility -> Appname
+// timestamp -> TimeReported
+// additional fields ("_xxx"), line and file -> Properties["gelf"]
+func ParseGelfFormat(m string) (*SyslogMessage, error) {
+	fields := map[string]interface{}{}
+	decoder := json.NewDecoder(strings.NewReader(m))
+	decoder.UseNumber()
+	err := decoder.Decode(&fields)
+	if err != nil {
+		return nil, &UnmarshalingJsonError{err}
+	}
+
+	short, _ := fields["short_message"].(string)
+	full, _ := fields["full_message"].(string)
+	if len(short) == 0 && len(full) == 0 {
+		return nil, &InvalidGelfError{"short_message is missing"}
+	}
+
+	n := time.Now()
+	smsg := SyslogMessage{
+		Version:       1,
+		Facility:      Facility(1),
+		Severity:      Severity(1),
+		TimeReported:  n,
+		TimeGenerated: n,
+		Message:       strings.TrimSpace(short),
+		Properties:    map[string]interface{}{},
+	}
+	if len(strings.TrimSpace(full)) > 0 {
+		smsg.Message = strings.TrimSpace(full)
+	}
+	smsg.Hostname, _ = fields["host"].(string)
+	smsg.Appname, _ = fields["facility"].(string)
+
+	if level, ok := fields["level"].(json.Number); ok {
+		l, err := level.Int64()
+		if err != nil || l < 0 || l > 7 {
+			return nil, &InvalidGelfError{"level must be between 0 and 7"}
+		}
+		smsg.Severity = Severity(l)
+	}
+	smsg.Priority = Priority(int(smsg.Facility)*8 + int(smsg.Severity))
+
+	if timestamp, ok := fields["timestamp"].(json.Number); ok {
+		t, err := timestamp.Float64()
+		if err != nil {
+			return nil, &InvalidGelfError{"invalid timestamp"}
+		}
+		sec, frac := math.Modf(t)
+		smsg.TimeReported = time.Unix(int64(sec), int64(frac*1e9))
+	}
+
+	extra := map[string]interface{}{}
+	for k, v := range fields {
+		if number, ok := v.(json.Number); ok {
+			if i, err := number.Int64(); err == nil {
+				v = i
+			} else if f, err := number.Float64(); err == nil {
+				v = f
+			}
+		}
+		if strings.HasPrefix(k, "_") && k != "_id" {
+			extra[k[1:]] = v
+		} else if k == "line" || k == "file" {
+			extra[k] = v
+		}
+	}
+	if len(extra) > 0 {
+		smsg.Properties["gelf"] = extra
+	}
+	return &smsg, nil
+}
+
+// maxGelfSize bounds the size of a decompressed GELF message.
+const maxGelfSize = 8 * 1024 * 1024
+
+// DecompressGelf returns the uncompressed payload of a GELF datagram.
+// Uncompressed payloads are returned as is.
+func DecompressGelf(payload []byte) ([]byte, error) {
+	if len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readGelf(r)
+	}
+	if len(payload) >= 2 && payload[0] == 0x78 && (uint16(payload[0])<<8|uint16(payload[1]))%31 == 0 {
+		r, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readGelf(r)
+	}
+	return payload, nil
+}
+
+func readGelf(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxGelfSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxGelfSize {
+		return nil, &InvalidGelfError{"decompressed message is too big"}
+	}
+	return data, nil
+}
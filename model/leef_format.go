@@ -0,0 +1,77 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseLEEF parses an IBM QRadar LEEF event:
+//
+// LEEF:1.0|Vendor|Product|Version|EventID|key=value<TAB>key=value...
+// LEEF:2.0|Vendor|Product|Version|EventID|Delimiter|key=value<Delimiter>...
+//
+// The LEEF 2.0 delimiter is a single character, or its hexadecimal code
+// (x5E or 0x5E). It defaults to a tab. The header fields are returned by
+// name (vendor, event_id...), and the attributes in "attributes".
+func ParseLEEF(m string) (map[string]interface{}, error) {
+	m = strings.TrimSpace(m)
+	if !strings.HasPrefix(m, "LEEF:") {
+		return nil, &InvalidLEEFError{"the message does not start with 'LEEF:'"}
+	}
+	parts := strings.SplitN(m[5:], "|", 6)
+	if len(parts) < 5 {
+		return nil, &InvalidLEEFError{"the header has less than 5 fields"}
+	}
+	fields := map[string]interface{}{
+		"version":         parts[0],
+		"vendor":          parts[1],
+		"product":         parts[2],
+		"product_version": parts[3],
+		"event_id":        parts[4],
+	}
+	attrs := ""
+	if len(parts) == 6 {
+		attrs = parts[5]
+	}
+	delimiter := "\t"
+	if strings.HasPrefix(parts[0], "2") {
+		// the delimiter field is optional
+		split := strings.SplitN(attrs, "|", 2)
+		if len(split) == 2 {
+			if d, ok := leefDelimiter(split[0]); ok {
+				delimiter = d
+				attrs = split[1]
+			}
+		}
+	}
+	attributes := map[string]interface{}{}
+	for _, attr := range strings.Split(attrs, delimiter) {
+		kv := strings.SplitN(attr, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || len(key) == 0 {
+			continue
+		}
+		attributes[key] = strings.TrimRight(kv[1], "\r\n")
+	}
+	fields["attributes"] = attributes
+	return fields, nil
+}
+
+// leefDelimiter decodes the delimiter field of a LEEF 2.0 header. An empty
+// field means the default tab.
+func leefDelimiter(field string) (string, bool) {
+	switch {
+	case len(field) == 0:
+		return "\t", true
+	case len(field) == 1:
+		return field, true
+	case strings.HasPrefix(field, "0x") || strings.HasPrefix(field, "x"):
+		code, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(field, "0"), "x"), 16, 16)
+		if err != nil || code == 0 {
+			return "", false
+		}
+		return string(rune(code)), true
+	default:
+		return "", false
+	}
+}
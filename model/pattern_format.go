@@ -0,0 +1,278 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GrokPatterns is the library of the patterns that can be used in the grok
+// parsers as %{NAME} or %{NAME:field}.
+var GrokPatterns = map[string]string{
+	"USERNAME":          `[a-zA-Z0-9._-]+`,
+	"USER":              `%{USERNAME}`,
+	"INT":               `(?:[+-]?(?:[0-9]+))`,
+	"BASE10NUM":         `[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)`,
+	"NUMBER":            `(?:%{BASE10NUM})`,
+	"BASE16NUM":         `(?:0[xX])?[0-9A-Fa-f]+`,
+	"POSINT":            `\b(?:[1-9][0-9]*)\b`,
+	"NONNEGINT":         `\b(?:[0-9]+)\b`,
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"QUOTEDSTRING":      `(?:"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*')`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"MAC":               `(?:[A-Fa-f0-9]{2}[:-]){5}[A-Fa-f0-9]{2}`,
+	"IPV4":              `(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)`,
+	"IPV6":              `(?:[0-9A-Fa-f]{0,4}:){2,7}(?:%{IPV4}|[0-9A-Fa-f]{0,4})`,
+	"IP":                `(?:%{IPV6}|%{IPV4})`,
+	"HOSTNAME":          `\b(?:[0-9A-Za-z][0-9A-Za-z-]{0,62})(?:\.(?:[0-9A-Za-z][0-9A-Za-z-]{0,62}))*\.?`,
+	"IPORHOST":          `(?:%{IP}|%{HOSTNAME})`,
+	"HOSTPORT":          `%{IPORHOST}:%{POSINT}`,
+	"PATH":              `(?:/[^\s]*)+`,
+	"URIPROTO":          `[A-Za-z][A-Za-z0-9+.-]+`,
+	"URI":               `%{URIPROTO}://\S+`,
+	"MONTH":             `\b(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec)[a-z]*\b`,
+	"MONTHNUM":          `(?:0?[1-9]|1[0-2])`,
+	"MONTHDAY":          `(?:0[1-9]|[12][0-9]|3[01]|[1-9])`,
+	"DAY":               `\b(?:Mon|Tue|Wed|Thu|Fri|Sat|Sun)[a-z]*\b`,
+	"YEAR":              `(?:\d\d){1,2}`,
+	"HOUR":              `(?:2[0123]|[01]?[0-9])`,
+	"MINUTE":            `(?:[0-5][0-9])`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}:%{SECOND}`,
+	"ISO8601_TIMEZONE":  `(?:Z|[+-]%{HOUR}(?::?%{MINUTE}))`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"LOGLEVEL":          `(?:[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Ee]merg(?:ency)?|EMERG(?:ENCY)?)`,
+	"PROG":              `[\x21-\x5a\x5c\x5e-\x7e]+`,
+}
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::(\w+))?(?::(int|float))?\}`)
+
+var severityNames = map[string]Severity{
+	"emerg": 0, "emergency": 0, "panic": 0, "alert": 1, "crit": 2, "critical": 2, "fatal": 2,
+	"err": 3, "error": 3, "severe": 3, "warn": 4, "warning": 4, "notice": 5,
+	"info": 6, "informational": 6, "debug": 7, "trace": 7,
+}
+
+var facilityNames = map[string]Facility{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// PatternParser parses the messages with a regular expression. The named
+// groups that have the name of a message field (hostname, appname, procid,
+// msgid, message, timereported, priority, facility, severity) set the
+// field. The other named groups are stored in Properties["fields"].
+type PatternParser struct {
+	name            string
+	re              *regexp.Regexp
+	conversion      map[string]string
+	timeFormat      string
+	location        *time.Location
+	futureTolerance time.Duration
+}
+
+// NewRegexParser returns a parser for a Go regular expression with named
+// groups: (?P<name>...).
+func NewRegexParser(name string, pattern string, timeFormat string) (*PatternParser, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &PatternParser{name: name, re: re, conversion: map[string]string{}, timeFormat: timeFormat, location: time.UTC, futureTolerance: DefaultFutureTolerance}, nil
+}
+
+// NewGrokParser returns a parser for a grok pattern. %{NAME:field} captures
+// the NAME pattern as field, %{NAME:field:int} (or float) also converts it.
+// The patterns are looked up in custom, then in GrokPatterns.
+func NewGrokParser(name string, pattern string, custom map[string]string, timeFormat string) (*PatternParser, error) {
+	library := map[string]string{}
+	for k, v := range GrokPatterns {
+		library[k] = v
+	}
+	for k, v := range custom {
+		// viper lowercases the keys
+		library[strings.ToUpper(k)] = v
+	}
+	conversion := map[string]string{}
+	expanded, err := expandGrok(pattern, library, conversion, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, err
+	}
+	return &PatternParser{name: name, re: re, conversion: conversion, timeFormat: timeFormat, location: time.UTC, futureTolerance: DefaultFutureTolerance}, nil
+}
+
+// SetTimezone sets how the timestamps without a time zone are read: in loc,
+// and, when they have no year, in the year that puts them closest to now
+// without being more than futureTolerance in the future.
+func (p *PatternParser) SetTimezone(loc *time.Location, futureTolerance time.Duration) {
+	p.location = loc
+	p.futureTolerance = futureTolerance
+}
+
+func expandGrok(pattern string, library map[string]string, conversion map[string]string, depth int) (string, error) {
+	if depth > 20 {
+		return "", fmt.Errorf("Too many nested grok patterns")
+	}
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		parts := grokReference.FindStringSubmatch(ref)
+		def, ok := library[strings.ToUpper(parts[1])]
+		if !ok {
+			err = fmt.Errorf("Unknown grok pattern '%s'", parts[1])
+			return ""
+		}
+		var sub string
+		sub, err = expandGrok(def, library, conversion, depth+1)
+		if len(parts[2]) == 0 {
+			return "(?:" + sub + ")"
+		}
+		if len(parts[3]) > 0 {
+			conversion[parts[2]] = parts[3]
+		}
+		return "(?P<" + parts[2] + ">" + sub + ")"
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+func (p *PatternParser) Parse(m string, dont_parse_sd bool) (*SyslogMessage, error) {
+	m = strings.Trim(m, "\r\n")
+	match := p.re.FindStringSubmatchIndex(m)
+	if match == nil {
+		return nil, &PatternParsingError{ParserName: p.name}
+	}
+	n := time.Now()
+	smsg := SyslogMessage{
+		Priority:      Priority(13),
+		Facility:      Facility(1),
+		Severity:      Severity(5),
+		Version:       1,
+		TimeReported:  n,
+		TimeGenerated: n,
+		Message:       m,
+		Properties:    map[string]interface{}{},
+	}
+	fields := map[string]interface{}{}
+	for i, name := range p.re.SubexpNames() {
+		if len(name) == 0 || match[2*i] < 0 {
+			continue
+		}
+		value := m[match[2*i]:match[2*i+1]]
+		var err error
+		switch name {
+		case "hostname":
+			smsg.Hostname = value
+		case "appname":
+			smsg.Appname = value
+		case "procid":
+			smsg.Procid = value
+		case "msgid":
+			smsg.Msgid = value
+		case "message":
+			smsg.Message = value
+		case "timereported":
+			smsg.TimeReported, err = p.parseTime(value, n)
+		case "priority":
+			var pri int
+			pri, err = strconv.Atoi(value)
+			if err == nil {
+				smsg.Priority = Priority(pri)
+				smsg.Facility = Facility(pri / 8)
+				smsg.Severity = Severity(pri % 8)
+			}
+		case "facility":
+			f, ok := facilityNames[strings.ToLower(value)]
+			if !ok {
+				var num int
+				num, err = strconv.Atoi(value)
+				f = Facility(num)
+			}
+			smsg.Facility = f
+			smsg.Priority = Priority(int(smsg.Facility)*8 + int(smsg.Severity))
+		case "severity":
+			s, ok := severityNames[strings.ToLower(value)]
+			if !ok {
+				var num int
+				num, err = strconv.Atoi(value)
+				s = Severity(num)
+			}
+			smsg.Severity = s
+			smsg.Priority = Priority(int(smsg.Facility)*8 + int(smsg.Severity))
+		default:
+			fields[name], err = p.convert(name, value)
+		}
+		if err != nil {
+			return nil, &PatternParsingError{ParserName: p.name, Field: name, Err: err}
+		}
+	}
+	if len(fields) > 0 {
+		smsg.Properties["fields"] = fields
+	}
+	return &smsg, nil
+}
+
+func (p *PatternParser) convert(name string, value string) (interface{}, error) {
+	switch p.conversion[name] {
+	case "int":
+		return strconv.ParseInt(value, 10, 64)
+	case "float":
+		return strconv.ParseFloat(value, 64)
+	default:
+		return value, nil
+	}
+}
+
+// parseTime parses the timereported capture with the time_format of the
+// parser: a Go layout, "unix" or "unixms". Without a time_format, RFC3339,
+// BSD (Mmm dd hh:mm:ss) and HTTP (02/Jan/2006:15:04:05 -0700) timestamps
+// are understood. The timestamps without a time zone are read in the
+// location of the parser. When the layout has no year, it is inferred.
+func (p *PatternParser) parseTime(value string, now time.Time) (time.Time, error) {
+	switch p.timeFormat {
+	case "":
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t, nil
+		}
+		if t, rest, ok := bsdTimestamp(value, p.location, now, p.futureTolerance); ok && len(rest) == 0 {
+			return t, nil
+		}
+		return time.Parse("02/Jan/2006:15:04:05 -0700", value)
+	case "unix", "unixms":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return now, err
+		}
+		if p.timeFormat == "unixms" {
+			f = f / 1000
+		}
+		return time.Unix(0, int64(f*float64(time.Second))), nil
+	default:
+		t, err := time.ParseInLocation(p.timeFormat, value, p.location)
+		if err != nil {
+			return now, err
+		}
+		if t.Year() == 0 {
+			t = inferYear(t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location(), now, p.futureTolerance)
+		}
+		return t, nil
+	}
+}
@@ -17,7 +17,23 @@ import (
 
 // if <PRI> is not present, we assume it is just MSG
 
+// DefaultFutureTolerance is how far in the future a BSD timestamp may be
+// before it is considered to be from the previous year.
+const DefaultFutureTolerance = 24 * time.Hour
+
+var bsdMonths = map[string]time.Month{
+	"Jan": time.January, "Feb": time.February, "Mar": time.March, "Apr": time.April,
+	"May": time.May, "Jun": time.June, "Jul": time.July, "Aug": time.August,
+	"Sep": time.September, "Oct": time.October, "Nov": time.November, "Dec": time.December,
+}
+
+// ParseRfc3164Format parses a RFC3164 message. The BSD timestamps, that
+// have no time zone, are read in UTC.
 func ParseRfc3164Format(m string) (*SyslogMessage, error) {
+	return parseRfc3164Format(m, time.UTC, DefaultFutureTolerance)
+}
+
+func parseRfc3164Format(m string, loc *time.Location, tolerance time.Duration) (*SyslogMessage, error) {
 	smsg := SyslogMessage{}
 	def_smsg := SyslogMessage{}
 	def_smsg.Message = m
@@ -46,9 +62,30 @@ func ParseRfc3164Format(m string) (*SyslogMessage, error) {
 		return &smsg, nil
 	}
 	m = strings.TrimSpace(m[end_pri+1:])
-	s := strings.Split(m, " ")
-	if m[0] >= byte('0') && m[0] <= byte('9') {
+	if len(m) == 0 {
+		return &smsg, nil
+	}
+	var s []string
+	if t, rest, ok := bsdTimestamp(m, loc, n, tolerance); ok {
+		smsg.TimeGenerated = t
+		smsg.TimeReported = t
+		rest = strings.TrimSpace(rest)
+		if len(rest) == 0 {
+			return &smsg, nil
+		}
+		s = strings.Split(rest, " ")
+	} else if t, rest, ok := bsdTimestamp(skipSequenceNumber(m), loc, n, tolerance); ok {
+		// Cisco: "<PRI>seq: *Mmm dd hh:mm:ss.mmm TZ: MSG"
+		smsg.TimeGenerated = t
+		smsg.TimeReported = t
+		rest = strings.TrimSpace(rest)
+		if len(rest) == 0 {
+			return &smsg, nil
+		}
+		s = strings.Split(rest, " ")
+	} else if m[0] >= byte('0') && m[0] <= byte('9') {
 		// RFC3339
+		s = strings.Split(m, " ")
 		t1, e := time.Parse(time.RFC3339Nano, s[0])
 		if e != nil {
 			t2, e := time.Parse(time.RFC3339, s[0])
@@ -69,28 +106,10 @@ func ParseRfc3164Format(m string) (*SyslogMessage, error) {
 		}
 		s = s[1:]
 	} else {
-		// old unix timestamp
-		if len(s) < 3 {
-			smsg.Message = m
-			smsg.TimeGenerated = def_smsg.TimeGenerated
-			smsg.TimeReported = def_smsg.TimeReported
-			return &smsg, nil
-		}
-		timestamp_s := strings.Join(s[0:3], " ")
-		t, e := time.Parse(time.Stamp, timestamp_s)
-		if e != nil {
-			smsg.Message = m
-			smsg.TimeGenerated = def_smsg.TimeGenerated
-			smsg.TimeReported = def_smsg.TimeReported
-			return &smsg, nil
-		}
-		t = t.AddDate(time.Now().Year(), 0, 0)
-		smsg.TimeGenerated = t
-		smsg.TimeReported = t
-		if len(s) == 3 {
-			return &smsg, nil
-		}
-		s = s[3:]
+		smsg.Message = m
+		smsg.TimeGenerated = def_smsg.TimeGenerated
+		smsg.TimeReported = def_smsg.TimeReported
+		return &smsg, nil
 	}
 
 	if len(s) == 1 {
@@ -117,7 +136,7 @@ func ParseRfc3164Format(m string) (*SyslogMessage, error) {
 		}
 		if strings.ContainsAny(s[1], "[]:") {
 			smsg.Hostname = s[0]
-			smsg.Appname, smsg.Procid = parseTag(s[0])
+			smsg.Appname, smsg.Procid = parseTag(s[1])
 			return &smsg, nil
 		}
 		smsg.Appname = s[0]
@@ -164,3 +183,237 @@ func isHostname(s string) bool {
 	}
 	return true
 }
+
+// ParseRfc3164LocalFormat parses the messages written to the local syslog
+// socket by syslog(3) and friends:
+//
+// <PRI>Mmm dd hh:mm:ss TAG MSG
+//
+// Local senders never include the hostname. The header may be incomplete or
+// missing: as in RFC3164 section 4.3.3, a message without PRI gets the
+// user.notice priority, and a message without a timestamp is stamped with
+// the reception time. The timestamps are read in loc, as in
+// ParseRfc3164Format.
+func ParseRfc3164LocalFormat(m string, loc *time.Location, futureTolerance time.Duration) (*SyslogMessage, error) {
+	n := time.Now()
+	smsg := SyslogMessage{
+		Priority:      Priority(13),
+		Facility:      Facility(1),
+		Severity:      Severity(5),
+		TimeGenerated: n,
+		TimeReported:  n,
+		Properties:    map[string]interface{}{},
+	}
+	m = strings.TrimRight(m, "\r\n\x00")
+
+	if strings.HasPrefix(m, "<") {
+		end_pri := strings.Index(m, ">")
+		if end_pri > 1 && end_pri <= 4 {
+			pri_num, err := strconv.Atoi(m[1:end_pri])
+			if err == nil {
+				smsg.Priority = Priority(pri_num)
+				smsg.Facility = Facility(pri_num / 8)
+				smsg.Severity = Severity(pri_num % 8)
+				m = m[end_pri+1:]
+			}
+		}
+	}
+
+	if t, rest, ok := bsdTimestamp(m, loc, n, futureTolerance); ok {
+		smsg.TimeGenerated = t
+		smsg.TimeReported = t
+		m = rest
+	} else if len(m) > 0 && m[0] >= byte('0') && m[0] <= byte('9') {
+		s := strings.SplitN(m, " ", 2)
+		t, err := time.Parse(time.RFC3339Nano, s[0])
+		if err == nil {
+			smsg.TimeGenerated = t
+			smsg.TimeReported = t
+			if len(s) == 2 {
+				m = s[1]
+			} else {
+				m = ""
+			}
+		}
+	}
+	m = strings.TrimLeft(m, " ")
+
+	// the TAG ends with a colon: "TAG:" or "TAG[PID]:"
+	s := strings.SplitN(m, " ", 2)
+	if len(s[0]) > 1 && strings.HasSuffix(s[0], ":") {
+		smsg.Appname, smsg.Procid = parseTag(s[0])
+		if len(s) == 2 {
+			m = s[1]
+		} else {
+			m = ""
+		}
+	}
+	smsg.Message = m
+	return &smsg, nil
+}
+
+// skipSequenceNumber removes the sequence number that Cisco devices may
+// send before the timestamp: "123: ".
+func skipSequenceNumber(m string) string {
+	i := 0
+	for i < len(m) && isDigit(m[i]) {
+		i++
+	}
+	if i == 0 || !strings.HasPrefix(m[i:], ": ") {
+		return m
+	}
+	return m[i+2:]
+}
+
+// bsdTimestamp parses the BSD timestamp at the start of m, and returns the
+// rest of m. The format is "Mmm dd hh:mm:ss", with the usual variants:
+//
+//	Mmm  d hh:mm:ss           space padded day
+//	Mmm dd hh:mm:ss.mmm       milliseconds (or any second fraction)
+//	Mmm dd yyyy hh:mm:ss      year after the day (Cisco)
+//	*Mmm dd hh:mm:ss.mmm UTC: unsynchronized clock and time zone (Cisco)
+//
+// The timestamp is read in loc, unless it gives the UTC (or GMT) zone or
+// the abbreviation of loc. Without a year, the year that puts the
+// timestamp closest to now is chosen (see inferYear).
+func bsdTimestamp(m string, loc *time.Location, now time.Time, tolerance time.Duration) (time.Time, string, bool) {
+	var zero time.Time
+	// Cisco marks the timestamps with '*' or '.' when the clock is not
+	// synchronized
+	s := strings.TrimLeft(m, "*.")
+	if len(s) < 4 || s[3] != ' ' {
+		return zero, m, false
+	}
+	month, ok := bsdMonths[s[:3]]
+	if !ok {
+		return zero, m, false
+	}
+	s = strings.TrimLeft(s[3:], " ")
+	day, s, ok := leadingInt(s, 2)
+	if !ok || day < 1 || day > 31 || !strings.HasPrefix(s, " ") {
+		return zero, m, false
+	}
+	s = s[1:]
+	year := 0
+	if y, rest, ok := leadingInt(s, 4); ok && len(s)-len(rest) == 4 && strings.HasPrefix(rest, " ") {
+		year = y
+		s = rest[1:]
+	}
+	if len(s) < 8 || s[2] != ':' || s[5] != ':' {
+		return zero, m, false
+	}
+	hour, _, ok1 := leadingInt(s[0:2], 2)
+	minute, _, ok2 := leadingInt(s[3:5], 2)
+	sec, _, ok3 := leadingInt(s[6:8], 2)
+	if !ok1 || !ok2 || !ok3 || hour > 23 || minute > 59 || sec > 60 {
+		return zero, m, false
+	}
+	s = s[8:]
+	nsec := 0
+	if strings.HasPrefix(s, ".") {
+		frac, rest, ok := leadingInt(s[1:], 9)
+		if !ok {
+			return zero, m, false
+		}
+		for i := len(s) - 1 - len(rest); i < 9; i++ {
+			frac *= 10
+		}
+		nsec = frac
+		s = rest
+	}
+
+	if strings.HasPrefix(s, ":") {
+		// Cisco: "Mmm dd hh:mm:ss.mmm: MSG"
+		s = s[1:]
+	} else if strings.HasPrefix(s, " ") {
+		// Cisco: "Mmm dd hh:mm:ss.mmm TZ: MSG"
+		fields := strings.SplitN(s[1:], " ", 2)
+		if strings.HasSuffix(fields[0], ":") {
+			zone := strings.TrimSuffix(fields[0], ":")
+			zoneLoc := zoneLocation(zone, loc, now)
+			if zoneLoc != nil {
+				loc = zoneLoc
+				s = ""
+				if len(fields) == 2 {
+					s = " " + fields[1]
+				}
+			}
+		}
+	}
+	if len(s) > 0 && s[0] != ' ' {
+		return zero, m, false
+	}
+	if year > 0 {
+		return time.Date(year, month, day, hour, minute, sec, nsec, loc), s, true
+	}
+	return inferYear(month, day, hour, minute, sec, nsec, loc, now, tolerance), s, true
+}
+
+// zoneLocation returns the location for a time zone name given by a
+// device. Only UTC, GMT and the abbreviation of loc are understood, as the
+// other abbreviations are ambiguous.
+func zoneLocation(zone string, loc *time.Location, now time.Time) *time.Location {
+	switch zone {
+	case "UTC", "GMT":
+		return time.UTC
+	}
+	if name, _ := now.In(loc).Zone(); name == zone {
+		return loc
+	}
+	return nil
+}
+
+// inferYear returns the date in the year (previous, current or next) that
+// puts it closest to now. The dates more than tolerance in the future are
+// not considered, so that a message from late December received in early
+// January gets the previous year.
+func inferYear(month time.Month, day, hour, minute, sec, nsec int, loc *time.Location, now time.Time, tolerance time.Duration) time.Time {
+	current := now.In(loc).Year()
+	var best time.Time
+	var bestDistance time.Duration
+	found := false
+	for _, year := range []int{current - 1, current, current + 1} {
+		t := time.Date(year, month, day, hour, minute, sec, nsec, loc)
+		if t.Day() != day {
+			// February 29 in a non leap year
+			continue
+		}
+		distance := t.Sub(now)
+		if distance > tolerance {
+			continue
+		}
+		if distance < 0 {
+			distance = -distance
+		}
+		if !found || distance < bestDistance {
+			best, bestDistance, found = t, distance, true
+		}
+	}
+	if found {
+		return best
+	}
+	// February 29, and no leap year around: take the last leap year
+	for year := current - 2; year > current-8; year-- {
+		t := time.Date(year, month, day, hour, minute, sec, nsec, loc)
+		if t.Day() == day {
+			return t
+		}
+	}
+	return time.Date(current, month, day, hour, minute, sec, nsec, loc)
+}
+
+// leadingInt parses the (at most max) digits at the start of s.
+func leadingInt(s string, max int) (int, string, bool) {
+	i := 0
+	for i < len(s) && i < max && isDigit(s[i]) {
+		i++
+	}
+	if i == 0 {
+		return 0, s, false
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, s, false
+	}
+	return n, s[i:], true
+}
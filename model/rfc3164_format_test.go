@@ -0,0 +1,322 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBsdTimestamp(t *testing.T) {
+	paris := time.FixedZone("CEST", 2*3600)
+	tests := []struct {
+		name      string
+		m         string
+		loc       *time.Location
+		now       time.Time
+		tolerance time.Duration
+		ok        bool
+		expected  time.Time
+		rest      string
+	}{
+		{
+			name:      "same day",
+			m:         "Oct 11 22:14:15 mymachine su: failed",
+			now:       time.Date(2017, 10, 11, 23, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 10, 11, 22, 14, 15, 0, time.UTC),
+			rest:      " mymachine su: failed",
+		},
+		{
+			name:      "space padded day",
+			m:         "Feb  5 01:02:03 host",
+			now:       time.Date(2017, 2, 5, 2, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 2, 5, 1, 2, 3, 0, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "december message received in january",
+			m:         "Dec 31 23:59:59 host",
+			now:       time.Date(2018, 1, 1, 0, 0, 30, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 12, 31, 23, 59, 59, 0, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "january message received in december (sender clock ahead)",
+			m:         "Jan  1 00:05:00 host",
+			now:       time.Date(2017, 12, 31, 23, 50, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2018, 1, 1, 0, 5, 0, 0, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "within future_tolerance",
+			m:         "Jun 16 11:00:00 host",
+			now:       time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC),
+			tolerance: 24 * time.Hour,
+			ok:        true,
+			expected:  time.Date(2018, 6, 16, 11, 0, 0, 0, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "beyond future_tolerance",
+			m:         "Jun 16 11:00:00 host",
+			now:       time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC),
+			tolerance: time.Hour,
+			ok:        true,
+			expected:  time.Date(2017, 6, 16, 11, 0, 0, 0, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "february 29 in a non leap year",
+			m:         "Feb 29 12:00:00 host",
+			now:       time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2016, 2, 29, 12, 0, 0, 0, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "milliseconds",
+			m:         "Oct 11 22:14:15.003 host",
+			now:       time.Date(2017, 10, 11, 23, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 10, 11, 22, 14, 15, 3000000, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "microseconds",
+			m:         "Oct 11 22:14:15.123456 host",
+			now:       time.Date(2017, 10, 11, 23, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 10, 11, 22, 14, 15, 123456000, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "cisco unsynchronized clock",
+			m:         "*Mar  1 18:46:11.123: %SYS-5-CONFIG_I: Configured",
+			now:       time.Date(2017, 3, 1, 19, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 3, 1, 18, 46, 11, 123000000, time.UTC),
+			rest:      " %SYS-5-CONFIG_I: Configured",
+		},
+		{
+			name:      "cisco clock that lost its synchronization",
+			m:         ".Mar  1 18:46:11.123: %SYS-5-CONFIG_I: Configured",
+			now:       time.Date(2017, 3, 1, 19, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 3, 1, 18, 46, 11, 123000000, time.UTC),
+			rest:      " %SYS-5-CONFIG_I: Configured",
+		},
+		{
+			name:      "cisco UTC zone in a non UTC listener",
+			m:         "Mar  1 18:46:11.123 UTC: %SYS-5-CONFIG_I: Configured",
+			loc:       paris,
+			now:       time.Date(2017, 3, 1, 19, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 3, 1, 18, 46, 11, 123000000, time.UTC),
+			rest:      " %SYS-5-CONFIG_I: Configured",
+		},
+		{
+			name:      "cisco zone of the listener",
+			m:         "Mar  1 18:46:11 CEST: %SYS-5-CONFIG_I: Configured",
+			loc:       paris,
+			now:       time.Date(2017, 3, 1, 19, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 3, 1, 16, 46, 11, 0, time.UTC),
+			rest:      " %SYS-5-CONFIG_I: Configured",
+		},
+		{
+			name:      "unknown zone is part of the message",
+			m:         "Mar  1 18:46:11 PST: %SYS-5-CONFIG_I: Configured",
+			now:       time.Date(2017, 3, 1, 19, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 3, 1, 18, 46, 11, 0, time.UTC),
+			rest:      " PST: %SYS-5-CONFIG_I: Configured",
+		},
+		{
+			name:      "year after the day",
+			m:         "Oct 11 2016 22:14:15 host",
+			now:       time.Date(2017, 10, 11, 23, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2016, 10, 11, 22, 14, 15, 0, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "year and milliseconds",
+			m:         "*Oct 11 2016 22:14:15.500: host",
+			now:       time.Date(2017, 10, 11, 23, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2016, 10, 11, 22, 14, 15, 500000000, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "non UTC listener",
+			m:         "Jul 10 12:00:00 host",
+			loc:       paris,
+			now:       time.Date(2018, 7, 10, 11, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2018, 7, 10, 10, 0, 0, 0, time.UTC),
+			rest:      " host",
+		},
+		{
+			name:      "year rollover in the listener time zone",
+			m:         "Jan  1 00:30:00 host",
+			loc:       paris,
+			now:       time.Date(2017, 12, 31, 23, 0, 0, 0, time.UTC),
+			tolerance: DefaultFutureTolerance,
+			ok:        true,
+			expected:  time.Date(2017, 12, 31, 22, 30, 0, 0, time.UTC),
+			rest:      " host",
+		},
+		{
+			name: "unknown month",
+			m:    "Foo 11 22:14:15 host",
+			now:  time.Date(2017, 10, 11, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "invalid day",
+			m:    "Oct 32 22:14:15 host",
+			now:  time.Date(2017, 10, 11, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "invalid hour",
+			m:    "Oct 11 25:14:15 host",
+			now:  time.Date(2017, 10, 11, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "truncated",
+			m:    "Oct 11 22:14",
+			now:  time.Date(2017, 10, 11, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "garbage after the seconds",
+			m:    "Oct 11 22:14:15x host",
+			now:  time.Date(2017, 10, 11, 23, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		loc := tt.loc
+		if loc == nil {
+			loc = time.UTC
+		}
+		ts, rest, ok := bsdTimestamp(tt.m, loc, tt.now, tt.tolerance)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, expected %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			if rest != tt.m {
+				t.Errorf("%s: rest = %q, expected the message", tt.name, rest)
+			}
+			continue
+		}
+		if !ts.Equal(tt.expected) {
+			t.Errorf("%s: timestamp = %s, expected %s", tt.name, ts, tt.expected)
+		}
+		if rest != tt.rest {
+			t.Errorf("%s: rest = %q, expected %q", tt.name, rest, tt.rest)
+		}
+	}
+}
+
+func TestZoneLocation(t *testing.T) {
+	paris := time.FixedZone("CEST", 2*3600)
+	now := time.Date(2018, 7, 10, 11, 0, 0, 0, time.UTC)
+	tests := []struct {
+		zone     string
+		expected *time.Location
+	}{
+		{"UTC", time.UTC},
+		{"GMT", time.UTC},
+		{"CEST", paris},
+		{"PST", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		loc := zoneLocation(tt.zone, paris, now)
+		if loc != tt.expected {
+			t.Errorf("zoneLocation(%q) = %v, expected %v", tt.zone, loc, tt.expected)
+		}
+	}
+}
+
+func TestParseRfc3164Timezone(t *testing.T) {
+	paris := time.FixedZone("CEST", 2*3600)
+	tests := []struct {
+		name     string
+		m        string
+		loc      *time.Location
+		expected time.Time
+		hostname string
+		appname  string
+		procid   string
+	}{
+		{
+			name:     "UTC listener",
+			m:        "<34>Oct 11 2016 22:14:15 mymachine su[123]: 'su root' failed",
+			loc:      time.UTC,
+			expected: time.Date(2016, 10, 11, 22, 14, 15, 0, time.UTC),
+			hostname: "mymachine",
+			appname:  "su",
+			procid:   "123",
+		},
+		{
+			name:     "non UTC listener",
+			m:        "<34>Oct 11 2016 22:14:15 mymachine su[123]: 'su root' failed",
+			loc:      paris,
+			expected: time.Date(2016, 10, 11, 20, 14, 15, 0, time.UTC),
+			hostname: "mymachine",
+			appname:  "su",
+			procid:   "123",
+		},
+	}
+	for _, tt := range tests {
+		p := GetParser("rfc3164")
+		p.SetTimezone(tt.loc, DefaultFutureTolerance)
+		sm, err := p.Parse(tt.m, false)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if !sm.TimeReported.Equal(tt.expected) {
+			t.Errorf("%s: TimeReported = %s, expected %s", tt.name, sm.TimeReported, tt.expected)
+		}
+		if sm.Hostname != tt.hostname || sm.Appname != tt.appname || sm.Procid != tt.procid {
+			t.Errorf("%s: got %s/%s/%s, expected %s/%s/%s", tt.name, sm.Hostname, sm.Appname, sm.Procid, tt.hostname, tt.appname, tt.procid)
+		}
+	}
+}
+
+func TestParseRfc3164LocalTimezone(t *testing.T) {
+	paris := time.FixedZone("CEST", 2*3600)
+	now := time.Now().Truncate(time.Second)
+	for _, loc := range []*time.Location{time.UTC, paris} {
+		m := "<13>" + now.In(loc).Format(time.Stamp) + " app[42]: hello"
+		sm, err := ParseRfc3164LocalFormat(m, loc, DefaultFutureTolerance)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", loc, err)
+			continue
+		}
+		if !sm.TimeReported.Equal(now) {
+			t.Errorf("%s: TimeReported = %s, expected %s", loc, sm.TimeReported, now)
+		}
+		if sm.Appname != "app" || sm.Procid != "42" || sm.Message != "hello" {
+			t.Errorf("%s: got %s/%s/%q", loc, sm.Appname, sm.Procid, sm.Message)
+		}
+	}
+}
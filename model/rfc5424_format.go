@@ -1,6 +1,7 @@
 package model
 
 import (
+	"bytes"
 	"fmt"
 	"strconv"
 	"strings"
@@ -8,238 +9,458 @@ import (
 	"unicode/utf8"
 )
 
+// RFC5424 header field limits, see https://tools.ietf.org/html/rfc5424#section-6
+const (
+	maxHostnameLen = 255
+	maxAppnameLen  = 48
+	maxProcidLen   = 128
+	maxMsgidLen    = 32
+	maxSDNameLen   = 32
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// ParseRfc5424Format parses a RFC5424 message in lenient mode: the common
+// deviations from the RFC are tolerated (unparsable timestamps, header
+// fields that are too long or not ASCII, unescaped ']' in SD values...).
 func ParseRfc5424Format(m string, dont_parse_sd bool) (*SyslogMessage, error) {
-	// HEADER = PRI VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID
-	// PRI = "<" PRIVAL ">"
-	// SYSLOG-MSG = HEADER SP STRUCTURED-DATA [SP MSG]
+	p := rfc5424Parser{m: m}
+	return p.parse(dont_parse_sd)
+}
 
-	smsg := SyslogMessage{}
-	splits := strings.SplitN(m, " ", 7)
+// ParseRfc5424StrictFormat parses a RFC5424 message in strict mode: the
+// messages that do not follow the RFC are rejected.
+func ParseRfc5424StrictFormat(m string, dont_parse_sd bool) (*SyslogMessage, error) {
+	p := rfc5424Parser{m: m, strict: true}
+	return p.parse(dont_parse_sd)
+}
 
-	if len(splits) < 7 {
-		return nil, &NotEnoughPartsError{len(splits)}
-	}
+type rfc5424Parser struct {
+	m      string
+	pos    int
+	strict bool
+}
+
+func (p *rfc5424Parser) errorf(field string, format string, args ...interface{}) error {
+	return &Rfc5424Error{Pos: p.pos, Field: field, Message: fmt.Sprintf(format, args...)}
+}
 
+func (p *rfc5424Parser) parse(dont_parse_sd bool) (*SyslogMessage, error) {
+	// SYSLOG-MSG = HEADER SP STRUCTURED-DATA [SP MSG]
+	// HEADER = PRI VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID
+	smsg := SyslogMessage{}
 	var err error
-	smsg.Priority, smsg.Facility, smsg.Severity, smsg.Version, err = parsePriority(splits[0])
+
+	smsg.Priority, smsg.Facility, smsg.Severity, err = p.priority()
 	if err != nil {
 		return nil, err
 	}
-
-	n := time.Now()
-	if splits[1] == "-" {
-		smsg.TimeReported = time.Now()
-	} else {
-		t1, err := time.Parse(time.RFC3339Nano, splits[1])
-		if err != nil {
-			t2, err := time.Parse(time.RFC3339, splits[1])
-			if err != nil {
-				smsg.TimeReported = n
-			} else {
-				smsg.TimeReported = t2
-			}
-		} else {
-			smsg.TimeReported = t1
-		}
+	smsg.Version, err = p.version()
+	if err != nil {
+		return nil, err
 	}
-	smsg.TimeGenerated = n
-
-	if splits[2] != "-" {
-		smsg.Hostname = splits[2]
+	err = p.space("VERSION")
+	if err != nil {
+		return nil, err
 	}
-	if splits[3] != "-" {
-		smsg.Appname = splits[3]
+	smsg.TimeGenerated = time.Now()
+	smsg.TimeReported, err = p.timestamp(smsg.TimeGenerated)
+	if err != nil {
+		return nil, err
 	}
-	if splits[4] != "-" {
-		smsg.Procid = splits[4]
+	err = p.space("TIMESTAMP")
+	if err != nil {
+		return nil, err
 	}
-	if splits[5] != "-" {
-		smsg.Msgid = splits[5]
+	smsg.Hostname, err = p.headerField("HOSTNAME", maxHostnameLen)
+	if err != nil {
+		return nil, err
 	}
-	structured_and_msg := strings.TrimSpace(splits[6])
-	if strings.HasPrefix(structured_and_msg, "-") {
-		// structured data is empty
-		smsg.Message = strings.TrimSpace(structured_and_msg[1:])
-	} else if strings.HasPrefix(structured_and_msg, "[") {
-		s1, s2, err := splitStructuredData(structured_and_msg)
-		if err != nil {
-			return nil, err
-		}
-		smsg.Message = s2
-		smsg.Properties = map[string]interface{}{}
+	err = p.space("HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	smsg.Appname, err = p.headerField("APP-NAME", maxAppnameLen)
+	if err != nil {
+		return nil, err
+	}
+	err = p.space("APP-NAME")
+	if err != nil {
+		return nil, err
+	}
+	smsg.Procid, err = p.headerField("PROCID", maxProcidLen)
+	if err != nil {
+		return nil, err
+	}
+	err = p.space("PROCID")
+	if err != nil {
+		return nil, err
+	}
+	smsg.Msgid, err = p.headerField("MSGID", maxMsgidLen)
+	if err != nil {
+		return nil, err
+	}
+	err = p.space("MSGID")
+	if err != nil {
+		return nil, err
+	}
+
+	start := p.pos
+	elements, byID, err := p.structuredData()
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) > 0 {
 		if dont_parse_sd {
-			smsg.Structured = s1
+			smsg.Structured = p.m[start:p.pos]
 		} else {
-			smsg.Structured = ""
-			props, err := parseStructData(s1)
-			if err != nil {
-				return nil, err
-			}
-			if props != nil {
-				smsg.Properties["rfc5424-sd"] = props
+			smsg.Properties = map[string]interface{}{
+				"rfc5424-sd":         byID,
+				"rfc5424-sd-ordered": elements,
 			}
 		}
-	} else {
-		return nil, &InvalidStructuredDataError{"Structured data is not nil but does not start with '['"}
 	}
 
+	smsg.Message, err = p.msg()
+	if err != nil {
+		return nil, err
+	}
 	return &smsg, nil
 }
 
-func splitStructuredData(structured_and_msg string) (string, string, error) {
-	length := len(structured_and_msg)
-	for i := 0; i < length; i++ {
-		if structured_and_msg[i] == ']' {
-			if i == (length - 1) {
-				return structured_and_msg, "", nil
-			}
-			if structured_and_msg[i+1] == ' ' {
-				return structured_and_msg[:i+1], strings.TrimSpace(structured_and_msg[i+1:]), nil
-			}
-		}
+// priority parses PRI = "<" PRIVAL ">", PRIVAL = 1*3DIGIT (0 to 191).
+func (p *rfc5424Parser) priority() (Priority, Facility, Severity, error) {
+	if p.pos >= len(p.m) || p.m[p.pos] != '<' {
+		return 0, 0, 0, p.errorf("PRI", "expected '<'")
 	}
-	return "", "", &InvalidStructuredDataError{"Can not find the last ']' that marks the end of structured data"}
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.m) && isDigit(p.m[p.pos]) {
+		p.pos++
+	}
+	digits := p.m[start:p.pos]
+	if len(digits) == 0 || len(digits) > 3 {
+		return 0, 0, 0, p.errorf("PRI", "PRIVAL must have 1 to 3 digits")
+	}
+	if p.pos >= len(p.m) || p.m[p.pos] != '>' {
+		return 0, 0, 0, p.errorf("PRI", "expected '>'")
+	}
+	prival, _ := strconv.Atoi(digits)
+	if prival > 191 || (p.strict && len(digits) > 1 && digits[0] == '0') {
+		return 0, 0, 0, &Rfc5424Error{Pos: start, Field: "PRI", Message: fmt.Sprintf("invalid PRIVAL '%s'", digits)}
+	}
+	p.pos++
+	return Priority(prival), Facility(prival / 8), Severity(prival % 8), nil
 }
 
-func parsePriority(pv string) (Priority, Facility, Severity, Version, error) {
-	if pv[0] != byte('<') {
-		return 0, 0, 0, 0, &InvalidPriorityError{}
+// version parses VERSION = NONZERO-DIGIT 0*2DIGIT. Only version 1 is
+// accepted in strict mode.
+func (p *rfc5424Parser) version() (Version, error) {
+	start := p.pos
+	for p.pos < len(p.m) && isDigit(p.m[p.pos]) {
+		p.pos++
 	}
-	i := strings.Index(pv, ">")
-	if i < 2 {
-		return 0, 0, 0, 0, &InvalidPriorityError{}
+	digits := p.m[start:p.pos]
+	if len(digits) == 0 || len(digits) > 3 || digits[0] == '0' {
+		return 0, &Rfc5424Error{Pos: start, Field: "VERSION", Message: "invalid version"}
 	}
-	if len(pv) <= (i + 1) {
-		return 0, 0, 0, 0, &InvalidPriorityError{}
+	v, _ := strconv.Atoi(digits)
+	if p.strict && v != 1 {
+		return 0, &Rfc5424Error{Pos: start, Field: "VERSION", Message: fmt.Sprintf("unsupported version %d", v)}
 	}
+	return Version(v), nil
+}
 
-	p, err := strconv.Atoi(pv[1:i])
-	if err != nil {
-		return 0, 0, 0, 0, &InvalidPriorityError{}
+// space consumes the SP that follows a field. In lenient mode, repeated
+// spaces are accepted.
+func (p *rfc5424Parser) space(after string) error {
+	if p.pos >= len(p.m) || p.m[p.pos] != ' ' {
+		return p.errorf(after, "expected a space after %s", after)
+	}
+	p.pos++
+	if !p.strict {
+		for p.pos < len(p.m) && p.m[p.pos] == ' ' {
+			p.pos++
+		}
+	}
+	return nil
+}
+
+// token returns the characters up to the next space or the end of the
+// message.
+func (p *rfc5424Parser) token() string {
+	end := strings.IndexByte(p.m[p.pos:], ' ')
+	if end < 0 {
+		end = len(p.m) - p.pos
 	}
+	tok := p.m[p.pos : p.pos+end]
+	p.pos += end
+	return tok
+}
 
-	f := Facility(p / 8)
-	s := Severity(p % 8)
-	v, err := strconv.Atoi(pv[i+1:])
+// timestamp parses TIMESTAMP = NILVALUE / FULL-DATE "T" FULL-TIME. When the
+// timestamp is NILVALUE, or is invalid in lenient mode, now is returned.
+func (p *rfc5424Parser) timestamp(now time.Time) (time.Time, error) {
+	start := p.pos
+	tok := p.token()
+	if tok == "-" {
+		return now, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, tok)
+	if err == nil && p.strict {
+		err = checkStrictTimestamp(tok)
+	}
 	if err != nil {
-		return 0, 0, 0, 0, &InvalidPriorityError{}
+		if p.strict {
+			return now, &Rfc5424Error{Pos: start, Field: "TIMESTAMP", Message: err.Error()}
+		}
+		return now, nil
 	}
-
-	return Priority(p), f, s, Version(v), nil
+	return t, nil
 }
 
-func parseStructData(sd string) (m map[string]map[string]string, err error) {
-	// see https://tools.ietf.org/html/rfc5424#section-6.3
-	if !utf8.ValidString(sd) {
-		return nil, &InvalidStructuredDataError{}
+// checkStrictTimestamp checks the RFC5424 restrictions on top of RFC3339:
+// uppercase 'T' and 'Z', and at most 6 digits of second fraction.
+func checkStrictTimestamp(ts string) error {
+	if strings.ContainsAny(ts, "tz") {
+		return fmt.Errorf("'T' and 'Z' must be uppercase")
+	}
+	dot := strings.IndexByte(ts, '.')
+	if dot < 0 {
+		return nil
+	}
+	digits := 0
+	for i := dot + 1; i < len(ts) && isDigit(ts[i]); i++ {
+		digits++
 	}
-	m = map[string]map[string]string{}
-	l := len(sd)
-	position := 0
-	current_sdid := ""
-	current_name := ""
+	if digits > 6 {
+		return fmt.Errorf("TIME-SECFRAC has more than 6 digits")
+	}
+	return nil
+}
 
-	var openBracket func() error
-	var sdid func() error
-	var value func() error
-	var param func() error
+// headerField parses HOSTNAME, APP-NAME, PROCID or MSGID: NILVALUE or
+// 1*maxLen PRINTUSASCII. NILVALUE is returned as an empty string.
+func (p *rfc5424Parser) headerField(field string, maxLen int) (string, error) {
+	start := p.pos
+	tok := p.token()
+	if len(tok) == 0 {
+		return "", &Rfc5424Error{Pos: start, Field: field, Message: "empty field"}
+	}
+	if tok == "-" {
+		return "", nil
+	}
+	if p.strict {
+		if len(tok) > maxLen {
+			return "", &Rfc5424Error{Pos: start, Field: field, Message: fmt.Sprintf("longer than %d characters", maxLen)}
+		}
+		for i := 0; i < len(tok); i++ {
+			if !isPrintUSASCII(tok[i]) {
+				return "", &Rfc5424Error{Pos: start + i, Field: field, Message: "invalid character"}
+			}
+		}
+	}
+	return tok, nil
+}
 
-	value = func() error {
-		// a bit long and painful to take care of escaped characters
-		if position == l {
-			return &InvalidStructuredDataError{"Expected SD-VALUE, got nothing"}
+// structuredData parses STRUCTURED-DATA = NILVALUE / 1*SD-ELEMENT. The
+// elements are returned twice:
+//
+// - as map[SD-ID]map[name]value, the format of Properties["rfc5424-sd"].
+// When an SD-ID or a parameter name is repeated, the last value wins.
+//
+// - in the order of the message, as {"id": SD-ID, "params": {name: value}},
+// for Properties["rfc5424-sd-ordered"]. When a parameter name is repeated,
+// its value is a []string of all the values in order. Plain maps and slices
+// are used so that the messages look the same before and after the JSON
+// encoding in the Store.
+func (p *rfc5424Parser) structuredData() ([]interface{}, map[string]map[string]string, error) {
+	if p.pos >= len(p.m) {
+		return nil, nil, p.errorf("STRUCTURED-DATA", "expected structured data")
+	}
+	if p.m[p.pos] == '-' {
+		p.pos++
+		return nil, nil, nil
+	}
+	if p.m[p.pos] != '[' {
+		return nil, nil, p.errorf("STRUCTURED-DATA", "expected '-' or '['")
+	}
+	elements := []interface{}{}
+	byID := map[string]map[string]string{}
+	for p.pos < len(p.m) && p.m[p.pos] == '[' {
+		id, params, err := p.sdElement()
+		if err != nil {
+			return nil, nil, err
 		}
-		if sd[position] != byte('"') {
-			return &InvalidStructuredDataError{"SD-VALUE should start with a quote"}
+		last, seen := byID[id]
+		if p.strict && seen {
+			return nil, nil, p.errorf("SD-ID", "duplicate SD-ID '%s'", id)
 		}
-		position++
-		p := position
-		found := false
-		for p < l && !found {
-			if sd[p] == byte('\\') {
-				p++
-				if p >= l {
-					return &InvalidStructuredDataError{"Unexpected end after a \\"}
-				}
-				if sd[p] == byte('"') || sd[p] == byte('\\') || sd[p] == byte(']') {
-					p++
-				}
-			} else if sd[p] == byte('"') {
-				found = true
-			} else {
-				p++
+		if !seen {
+			last = map[string]string{}
+			byID[id] = last
+		}
+		for name, value := range params {
+			switch v := value.(type) {
+			case string:
+				last[name] = v
+			case []string:
+				last[name] = v[len(v)-1]
 			}
 		}
-		if found {
-			val := sd[position:p]
-			m[current_sdid][current_name] = val
-			position += len(val)
-			position++ // count for the closing quote
-			if position >= l {
-				return &InvalidStructuredDataError{"Abrupt end of SD-ELEMENT"}
+		elements = append(elements, map[string]interface{}{"id": id, "params": params})
+	}
+	return elements, byID, nil
+}
+
+// sdElement parses SD-ELEMENT = "[" SD-ID *(SP SD-PARAM) "]".
+func (p *rfc5424Parser) sdElement() (string, map[string]interface{}, error) {
+	p.pos++ // [
+	id, err := p.sdName("SD-ID")
+	if err != nil {
+		return "", nil, err
+	}
+	params := map[string]interface{}{}
+	for {
+		if p.pos >= len(p.m) {
+			return "", nil, p.errorf("SD-ELEMENT", "unexpected end of structured data")
+		}
+		switch p.m[p.pos] {
+		case ']':
+			p.pos++
+			return id, params, nil
+		case ' ':
+			p.pos++
+			if !p.strict && p.pos < len(p.m) && (p.m[p.pos] == ' ' || p.m[p.pos] == ']') {
+				continue
 			}
-			if sd[position] == byte(' ') {
-				position++
-				return param()
-			} else if sd[position] == byte(']') {
-				position++
-				return openBracket()
-			} else {
-				return &InvalidStructuredDataError{fmt.Sprintf("Expected SP or ']' but got '%s' instead", string(sd[position]))}
+			name, value, err := p.sdParam()
+			if err != nil {
+				return "", nil, err
 			}
-
-		} else {
-			return &InvalidStructuredDataError{"The end of SD-VALUE was not found"}
+			switch previous := params[name].(type) {
+			case nil:
+				params[name] = value
+			case string:
+				params[name] = []string{previous, value}
+			case []string:
+				params[name] = append(previous, value)
+			}
+		default:
+			return "", nil, p.errorf("SD-ELEMENT", "expected SP or ']' but got '%c'", p.m[p.pos])
 		}
 	}
+}
 
-	param = func() error {
-		name_end := strings.Index(sd[position:], "=")
-		if name_end < 1 {
-			return &InvalidStructuredDataError{"Invalid SD-NAME"}
+// sdName parses SD-NAME = 1*32PRINTUSASCII except '=', SP, ']' and '"'.
+// In lenient mode, the name only has to stop before these characters.
+func (p *rfc5424Parser) sdName(field string) (string, error) {
+	start := p.pos
+	for p.pos < len(p.m) {
+		c := p.m[p.pos]
+		if c == '=' || c == ' ' || c == ']' || c == '"' {
+			break
+		}
+		if p.strict && !isPrintUSASCII(c) {
+			return "", p.errorf(field, "invalid character")
 		}
-		current_name = sd[position : position+name_end]
-		position += name_end
-		position++ // count the =
-		return value()
+		p.pos++
+	}
+	name := p.m[start:p.pos]
+	if len(name) == 0 {
+		return "", p.errorf(field, "empty name")
 	}
+	if p.strict && len(name) > maxSDNameLen {
+		return "", &Rfc5424Error{Pos: start, Field: field, Message: fmt.Sprintf("longer than %d characters", maxSDNameLen)}
+	}
+	return name, nil
+}
 
-	sdid = func() error {
-		end := strings.IndexAny(sd[position:], " ]")
-		if end < 1 {
-			return &InvalidStructuredDataError{"Invalid SDID"}
+// sdParam parses SD-PARAM = PARAM-NAME "=" %d34 PARAM-VALUE %d34. The
+// escaped '"', '\' and ']' are unescaped. A backslash followed by another
+// character is kept as is.
+func (p *rfc5424Parser) sdParam() (string, string, error) {
+	name, err := p.sdName("PARAM-NAME")
+	if err != nil {
+		return "", "", err
+	}
+	if p.pos >= len(p.m) || p.m[p.pos] != '=' {
+		return "", "", p.errorf("SD-PARAM", "expected '='")
+	}
+	p.pos++
+	if p.pos >= len(p.m) || p.m[p.pos] != '"' {
+		return "", "", p.errorf("PARAM-VALUE", "expected '\"'")
+	}
+	p.pos++
+	start := p.pos
+	var value bytes.Buffer
+	for {
+		if p.pos >= len(p.m) {
+			return "", "", &Rfc5424Error{Pos: start, Field: "PARAM-VALUE", Message: "the closing quote was not found"}
 		}
-		current_sdid = sd[position : position+end]
-		position += end
-		m[current_sdid] = map[string]string{}
-		if sd[position] == byte(' ') {
-			// now read the params
-			position++
-			return param()
-		} else {
-			// end of the element
-			position++
-			return openBracket()
+		c := p.m[p.pos]
+		switch c {
+		case '\\':
+			if p.pos+1 < len(p.m) {
+				next := p.m[p.pos+1]
+				if next == '"' || next == '\\' || next == ']' {
+					value.WriteByte(next)
+					p.pos += 2
+					continue
+				}
+			}
+			value.WriteByte(c)
+			p.pos++
+		case '"':
+			p.pos++
+			if p.strict && !utf8.Valid(value.Bytes()) {
+				return "", "", &Rfc5424Error{Pos: start, Field: "PARAM-VALUE", Message: "invalid UTF-8"}
+			}
+			return name, value.String(), nil
+		case ']':
+			if p.strict {
+				return "", "", p.errorf("PARAM-VALUE", "']' must be escaped")
+			}
+			value.WriteByte(c)
+			p.pos++
+		default:
+			value.WriteByte(c)
+			p.pos++
 		}
-
 	}
+}
 
-	openBracket = func() error {
-		if position == l {
-			return nil
+// msg parses [SP MSG]. A leading UTF-8 BOM is removed, and the rest must
+// then be valid UTF-8 in strict mode. In lenient mode, the message is
+// trimmed, and the missing SP after the structured data is tolerated.
+func (p *rfc5424Parser) msg() (string, error) {
+	if p.pos >= len(p.m) {
+		return "", nil
+	}
+	if p.m[p.pos] != ' ' {
+		if p.strict {
+			return "", p.errorf("MSG", "expected a space after STRUCTURED-DATA")
 		}
-		if sd[position] == byte('[') {
-			position++
-			return sdid()
-		} else {
-			return &InvalidStructuredDataError{fmt.Sprintf("Expected '[' but got '%s' instead", string(sd[position]))}
+	} else {
+		p.pos++
+	}
+	msg := p.m[p.pos:]
+	if strings.HasPrefix(msg, string(utf8BOM)) {
+		msg = msg[len(utf8BOM):]
+		if p.strict && !utf8.ValidString(msg) {
+			return "", p.errorf("MSG", "invalid UTF-8 after the BOM")
 		}
 	}
-
-	err = openBracket()
-	if err != nil {
-		return nil, err
+	if !p.strict {
+		msg = strings.TrimSpace(msg)
 	}
+	return msg, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
 
-	return m, nil
+func isPrintUSASCII(c byte) bool {
+	return c >= 33 && c <= 126
 }
@@ -0,0 +1,409 @@
+package model
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const bom = "\xEF\xBB\xBF"
+
+func TestRfc5424StrictAndLenient(t *testing.T) {
+	tests := []struct {
+		name      string
+		m         string
+		lenientOK bool
+		strictOK  bool
+		hostname  string
+		appname   string
+		procid    string
+		msgid     string
+		message   string
+	}{
+		{
+			name:      "rfc example 1",
+			m:         "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - " + bom + "'su root' failed for lonvick on /dev/pts/8",
+			lenientOK: true,
+			strictOK:  true,
+			hostname:  "mymachine.example.com",
+			appname:   "su",
+			msgid:     "ID47",
+			message:   "'su root' failed for lonvick on /dev/pts/8",
+		},
+		{
+			name:      "rfc example 2",
+			m:         "<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.",
+			lenientOK: true,
+			strictOK:  true,
+			hostname:  "192.0.2.1",
+			appname:   "myproc",
+			procid:    "8710",
+			message:   "%% It's time to make the do-nuts.",
+		},
+		{
+			name:      "all NILVALUE",
+			m:         "<0>1 - - - - - -",
+			lenientOK: true,
+			strictOK:  true,
+		},
+		{
+			name:      "unsupported version",
+			m:         "<13>2 - host app - - - msg",
+			lenientOK: true,
+			strictOK:  false,
+			hostname:  "host",
+			appname:   "app",
+			message:   "msg",
+		},
+		{
+			name:      "lowercase timestamp",
+			m:         "<13>1 2017-01-01t00:00:00z host app - - - msg",
+			lenientOK: true,
+			strictOK:  false,
+			hostname:  "host",
+			appname:   "app",
+			message:   "msg",
+		},
+		{
+			name:      "invalid timestamp",
+			m:         "<13>1 yesterday host app - - - msg",
+			lenientOK: true,
+			strictOK:  false,
+			hostname:  "host",
+			appname:   "app",
+			message:   "msg",
+		},
+		{
+			name:      "too many second fraction digits",
+			m:         "<13>1 2017-01-01T00:00:00.1234567Z host app - - - msg",
+			lenientOK: true,
+			strictOK:  false,
+			hostname:  "host",
+			appname:   "app",
+			message:   "msg",
+		},
+		{
+			name:      "repeated spaces in the header",
+			m:         "<13>1 -  host app - - - msg",
+			lenientOK: true,
+			strictOK:  false,
+			hostname:  "host",
+			appname:   "app",
+			message:   "msg",
+		},
+		{
+			name:      "appname longer than 48 characters",
+			m:         "<13>1 - host " + strings.Repeat("a", 49) + " - - - msg",
+			lenientOK: true,
+			strictOK:  false,
+			hostname:  "host",
+			appname:   strings.Repeat("a", 49),
+			message:   "msg",
+		},
+		{
+			name:      "no space after the structured data",
+			m:         "<13>1 - host app - - [a b=\"c\"]msg",
+			lenientOK: true,
+			strictOK:  false,
+			hostname:  "host",
+			appname:   "app",
+			message:   "msg",
+		},
+		{
+			name:      "unescaped ']' in a value",
+			m:         "<13>1 - host app - - [a b=\"]\"] msg",
+			lenientOK: true,
+			strictOK:  false,
+			hostname:  "host",
+			appname:   "app",
+			message:   "msg",
+		},
+		{
+			name:      "duplicate SD-ID",
+			m:         "<13>1 - host app - - [a b=\"1\"][a b=\"2\"] msg",
+			lenientOK: true,
+			strictOK:  false,
+			hostname:  "host",
+			appname:   "app",
+			message:   "msg",
+		},
+		{
+			name:      "trailing spaces are trimmed in lenient mode only",
+			m:         "<13>1 - host app - - - msg  ",
+			lenientOK: true,
+			strictOK:  true,
+			hostname:  "host",
+			appname:   "app",
+			message:   "msg",
+		},
+		{
+			name: "PRIVAL out of range",
+			m:    "<192>1 - host app - - - msg",
+		},
+		{
+			name: "no PRI",
+			m:    "1 - host app - - - msg",
+		},
+		{
+			name: "truncated header",
+			m:    "<13>1 - host app",
+		},
+		{
+			name: "unterminated SD-ELEMENT",
+			m:    "<13>1 - host app - - [a b=\"c\"",
+		},
+		{
+			name: "structured data is neither '-' nor '['",
+			m:    "<13>1 - host app - - msg",
+		},
+	}
+	for _, tt := range tests {
+		lenient, err := ParseRfc5424Format(tt.m, false)
+		if tt.lenientOK != (err == nil) {
+			t.Errorf("%s: lenient error = %v, expected success = %v", tt.name, err, tt.lenientOK)
+		}
+		strict, strictErr := ParseRfc5424StrictFormat(tt.m, false)
+		if tt.strictOK != (strictErr == nil) {
+			t.Errorf("%s: strict error = %v, expected success = %v", tt.name, strictErr, tt.strictOK)
+		}
+		if strictErr != nil {
+			if _, ok := strictErr.(*Rfc5424Error); !ok {
+				t.Errorf("%s: strict error is a %T, expected a *Rfc5424Error", tt.name, strictErr)
+			}
+		}
+		if err != nil {
+			continue
+		}
+		if lenient.Hostname != tt.hostname || lenient.Appname != tt.appname || lenient.Procid != tt.procid || lenient.Msgid != tt.msgid {
+			t.Errorf("%s: header = %q %q %q %q", tt.name, lenient.Hostname, lenient.Appname, lenient.Procid, lenient.Msgid)
+		}
+		if lenient.Message != tt.message {
+			t.Errorf("%s: message = %q, expected %q", tt.name, lenient.Message, tt.message)
+		}
+		if strictErr == nil {
+			compareRfc5424(t, tt.name, strict, lenient)
+		}
+	}
+}
+
+func TestRfc5424ErrorPosition(t *testing.T) {
+	tests := []struct {
+		name   string
+		m      string
+		strict bool
+		pos    int
+		field  string
+	}{
+		{"empty message", "", false, 0, "PRI"},
+		{"no PRI", "13>1 - - - - - -", false, 0, "PRI"},
+		{"PRIVAL out of range", "<192>1 - - - - - -", false, 1, "PRI"},
+		{"PRIVAL with a leading zero", "<013>1 - - - - - -", true, 1, "PRI"},
+		{"invalid version", "<13>x - - - - - -", false, 4, "VERSION"},
+		{"unsupported version", "<13>2 - - - - - -", true, 4, "VERSION"},
+		{"no space after the version", "<13>1- - - - - -", false, 5, "VERSION"},
+		{"invalid timestamp", "<13>1 yesterday - - - - -", true, 6, "TIMESTAMP"},
+		{"double space after the timestamp", "<13>1 -  host - - - -", true, 8, "HOSTNAME"},
+		{"hostname too long", "<13>1 - " + strings.Repeat("h", 256) + " - - - -", true, 8, "HOSTNAME"},
+		{"non ASCII appname", "<13>1 - host ap\xc3\xa9 - - -", true, 15, "APP-NAME"},
+		{"msgid too long", "<13>1 - host app - " + strings.Repeat("m", 33) + " -", true, 19, "MSGID"},
+		{"structured data is missing", "<13>1 - host app - - ", false, 21, "STRUCTURED-DATA"},
+		{"structured data is invalid", "<13>1 - host app - - msg", false, 21, "STRUCTURED-DATA"},
+		{"SD-ID too long", "<13>1 - host app - - [" + strings.Repeat("i", 33) + "]", true, 22, "SD-ID"},
+		{"no '=' in SD-PARAM", "<13>1 - host app - - [id a] msg", false, 26, "SD-PARAM"},
+		{"unquoted value", "<13>1 - host app - - [id a=b] msg", false, 27, "PARAM-VALUE"},
+		{"unterminated value", "<13>1 - host app - - [id a=\"b] msg", false, 28, "PARAM-VALUE"},
+		{"unescaped ']'", "<13>1 - host app - - [id a=\"]\"] msg", true, 28, "PARAM-VALUE"},
+		{"duplicate SD-ID", "<13>1 - host app - - [id a=\"1\"][id a=\"2\"] msg", true, 41, "SD-ID"},
+		{"no space before MSG", "<13>1 - host app - - [id a=\"1\"]msg", true, 31, "MSG"},
+		{"invalid UTF-8 after the BOM", "<13>1 - host app - - - " + bom + "\xff", true, 23, "MSG"},
+	}
+	for _, tt := range tests {
+		var err error
+		if tt.strict {
+			_, err = ParseRfc5424StrictFormat(tt.m, false)
+		} else {
+			_, err = ParseRfc5424Format(tt.m, false)
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		e, ok := err.(*Rfc5424Error)
+		if !ok {
+			t.Errorf("%s: error is a %T, expected a *Rfc5424Error", tt.name, err)
+			continue
+		}
+		if e.Pos != tt.pos || e.Field != tt.field {
+			t.Errorf("%s: error at %d (%s), expected %d (%s): %s", tt.name, e.Pos, e.Field, tt.pos, tt.field, e.Message)
+		}
+	}
+}
+
+func TestRfc5424BOM(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        string
+		strictOK bool
+		message  string
+	}{
+		{"BOM is removed", "<13>1 - - - - - - " + bom + "hello", true, "hello"},
+		{"BOM only", "<13>1 - - - - - - " + bom, true, ""},
+		{"no BOM", "<13>1 - - - - - - hello", true, "hello"},
+		{"BOM in the middle is kept", "<13>1 - - - - - - hello" + bom, true, "hello" + bom},
+		{"invalid UTF-8 after the BOM", "<13>1 - - - - - - " + bom + "h\xffllo", false, "h\xffllo"},
+		{"invalid UTF-8 without BOM", "<13>1 - - - - - - h\xffllo", true, "h\xffllo"},
+	}
+	for _, tt := range tests {
+		lenient, err := ParseRfc5424Format(tt.m, false)
+		if err != nil {
+			t.Errorf("%s: lenient error: %s", tt.name, err)
+			continue
+		}
+		if lenient.Message != tt.message {
+			t.Errorf("%s: lenient message = %q, expected %q", tt.name, lenient.Message, tt.message)
+		}
+		strict, err := ParseRfc5424StrictFormat(tt.m, false)
+		if tt.strictOK != (err == nil) {
+			t.Errorf("%s: strict error = %v, expected success = %v", tt.name, err, tt.strictOK)
+			continue
+		}
+		if err == nil && strict.Message != tt.message {
+			t.Errorf("%s: strict message = %q, expected %q", tt.name, strict.Message, tt.message)
+		}
+	}
+}
+
+func TestRfc5424Unescape(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{`plain`, `plain`},
+		{`a\"b`, `a"b`},
+		{`a\\b`, `a\b`},
+		{`a\]b`, `a]b`},
+		{`a\eb`, `a\eb`},
+		{`\\\"`, `\"`},
+		{`\\\]`, `\]`},
+		{`end\\`, `end\`},
+		{``, ``},
+	}
+	for _, tt := range tests {
+		m := `<13>1 - - - - - [id p="` + tt.value + `"] msg`
+		for _, parse := range []func(string, bool) (*SyslogMessage, error){ParseRfc5424Format, ParseRfc5424StrictFormat} {
+			sm, err := parse(m, false)
+			if err != nil {
+				t.Errorf("%s: %s", tt.value, err)
+				continue
+			}
+			params := sdElements(t, sm)[0].(map[string]interface{})["params"].(map[string]interface{})
+			if params["p"] != tt.expected {
+				t.Errorf("%s: value = %q, expected %q", tt.value, params["p"], tt.expected)
+			}
+			if sm.Message != "msg" {
+				t.Errorf("%s: message = %q", tt.value, sm.Message)
+			}
+		}
+	}
+}
+
+func TestRfc5424StructuredDataOrder(t *testing.T) {
+	m := `<13>1 - - - - - [zeta@1 a="1"][alpha@1 b="2" b="3" b="4"][mid@1] msg`
+	expected := []interface{}{
+		map[string]interface{}{"id": "zeta@1", "params": map[string]interface{}{"a": "1"}},
+		map[string]interface{}{"id": "alpha@1", "params": map[string]interface{}{"b": []string{"2", "3", "4"}}},
+		map[string]interface{}{"id": "mid@1", "params": map[string]interface{}{}},
+	}
+	expectedByID := map[string]map[string]string{
+		"zeta@1":  {"a": "1"},
+		"alpha@1": {"b": "4"},
+		"mid@1":   {},
+	}
+	for _, parse := range []func(string, bool) (*SyslogMessage, error){ParseRfc5424Format, ParseRfc5424StrictFormat} {
+		sm, err := parse(m, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elements := sdElements(t, sm); !reflect.DeepEqual(elements, expected) {
+			t.Errorf("structured data = %#v, expected %#v", elements, expected)
+		}
+		// the unordered format of the previous versions
+		if byID := sm.Properties["rfc5424-sd"]; !reflect.DeepEqual(byID, expectedByID) {
+			t.Errorf("rfc5424-sd = %#v, expected %#v", byID, expectedByID)
+		}
+	}
+
+	sm, err := ParseRfc5424Format(m, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.Structured != `[zeta@1 a="1"][alpha@1 b="2" b="3" b="4"][mid@1]` {
+		t.Errorf("with dont_parse_sd, Structured = %q", sm.Structured)
+	}
+	if len(sm.Properties) > 0 {
+		t.Errorf("with dont_parse_sd, the structured data should not be parsed")
+	}
+}
+
+// TestRfc5424Corpus runs the go-fuzz corpus through both parsers: the
+// messages accepted by the strict parser must be accepted by the lenient
+// parser, with the same header and structured data.
+func TestRfc5424Corpus(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "fuzz", "rfc5424", "corpus", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("the corpus is empty")
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Base(file)
+		lenient, err := ParseRfc5424Format(string(data), false)
+		if err != nil {
+			t.Errorf("%s: lenient error: %s", name, err)
+			continue
+		}
+		strict, err := ParseRfc5424StrictFormat(string(data), false)
+		if err != nil {
+			if _, ok := err.(*Rfc5424Error); !ok {
+				t.Errorf("%s: strict error is a %T", name, err)
+			}
+			continue
+		}
+		compareRfc5424(t, name, strict, lenient)
+	}
+}
+
+func compareRfc5424(t *testing.T, name string, strict *SyslogMessage, lenient *SyslogMessage) {
+	if strict.Priority != lenient.Priority || strict.Version != lenient.Version {
+		t.Errorf("%s: strict and lenient PRI or VERSION differ", name)
+	}
+	// with NILVALUE, TimeReported is the parsing time
+	nilTime := strict.TimeReported.Equal(strict.TimeGenerated) && lenient.TimeReported.Equal(lenient.TimeGenerated)
+	if !nilTime && !strict.TimeReported.Equal(lenient.TimeReported) {
+		t.Errorf("%s: strict and lenient timestamps differ", name)
+	}
+	if strict.Hostname != lenient.Hostname || strict.Appname != lenient.Appname || strict.Procid != lenient.Procid || strict.Msgid != lenient.Msgid {
+		t.Errorf("%s: strict and lenient headers differ", name)
+	}
+	if !reflect.DeepEqual(strict.Properties, lenient.Properties) {
+		t.Errorf("%s: strict and lenient structured data differ", name)
+	}
+	if strings.TrimSpace(strict.Message) != lenient.Message {
+		t.Errorf("%s: strict and lenient messages differ: %q, %q", name, strict.Message, lenient.Message)
+	}
+}
+
+func sdElements(t *testing.T, sm *SyslogMessage) []interface{} {
+	elements, ok := sm.Properties["rfc5424-sd-ordered"].([]interface{})
+	if !ok || len(elements) == 0 {
+		t.Fatalf("no structured data in %#v", sm.Properties)
+	}
+	return elements
+}
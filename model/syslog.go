@@ -19,6 +19,20 @@ type Stasher interface {
 	Stash(m *TcpUdpParsedMessage)
 }
 
+// Congested is implemented by the stashers that can tell when they do not
+// keep up with the incoming messages.
+type Congested interface {
+	Congested() bool
+}
+
+// ConfirmStasher is implemented by the stashers that can tell when a
+// message has been durably written in the Store.
+type ConfirmStasher interface {
+	// StashConfirm stashes m, then calls confirm with true when m has been
+	// written in the Store, or with false when it could not be.
+	StashConfirm(m *TcpUdpParsedMessage, confirm func(stored bool))
+}
+
 type ListenerInfo struct {
 	Port           int    `json:"port"`
 	BindAddr       string `json:"bind_addr"`
@@ -55,24 +69,74 @@ type SyslogMessage struct {
 	Properties       map[string]interface{} `json:"properties,omitempty"`
 }
 
+// PeerCredentials identifies the process that sent a message on a unix
+// socket. They are given by the kernel, so they can not be spoofed.
+type PeerCredentials struct {
+	Pid    int32  `json:"pid"`
+	Uid    uint32 `json:"uid"`
+	Gid    uint32 `json:"gid"`
+	Comm   string `json:"comm,omitempty"`
+	Exe    string `json:"exe,omitempty"`
+	Cgroup string `json:"cgroup,omitempty"`
+}
+
+// TLSPeer identifies a client by the certificate it presented during the
+// TLS handshake. Only certificates that have been verified are kept.
+type TLSPeer struct {
+	CN          string   `json:"cn"`
+	DNSNames    []string `json:"dns_names,omitempty"`
+	IPAddresses []string `json:"ip_addresses,omitempty"`
+	Emails      []string `json:"emails,omitempty"`
+	Fingerprint string   `json:"fingerprint"`
+}
+
+// Identities returns the names the client can be authorized with: the
+// subject CN and the DNS SANs.
+func (p *TLSPeer) Identities() []string {
+	if p == nil {
+		return nil
+	}
+	ids := []string{}
+	if len(p.CN) > 0 {
+		ids = append(ids, p.CN)
+	}
+	return append(ids, p.DNSNames...)
+}
+
 type RawMessage struct {
 	Message        string
 	Client         string
 	LocalPort      int
 	UnixSocketPath string
+	Creds          *PeerCredentials
+	TLSPeer        *TLSPeer
 }
 
 type ParsedMessage struct {
-	Fields         *SyslogMessage `json:"fields"`
-	Client         string         `json:"client,omitempty"`
-	LocalPort      int            `json:"local_port,string"`
-	UnixSocketPath string         `json:"unix_socket_path,omitempty"`
+	Fields         *SyslogMessage   `json:"fields"`
+	Client         string           `json:"client,omitempty"`
+	LocalPort      int              `json:"local_port,string"`
+	UnixSocketPath string           `json:"unix_socket_path,omitempty"`
+	Creds          *PeerCredentials `json:"creds,omitempty"`
+	TLSPeer        *TLSPeer         `json:"tls_peer,omitempty"`
 }
 
 type TcpUdpParsedMessage struct {
 	Parsed *ParsedMessage `json:"parsed"`
 	Uid    string         `json:"uid"`
 	ConfId string         `json:"conf_id"`
+	Offset *FileOffset    `json:"offset,omitempty"`
+}
+
+// FileOffset is the position in a tailed file right after a message. The
+// Store records it together with the message, so that the watcher can
+// resume from there. Device and Inode identify the file even when it has been
+// renamed.
+type FileOffset struct {
+	Filename string `json:"filename"`
+	Device   uint64 `json:"device,omitempty"`
+	Inode    uint64 `json:"inode"`
+	Offset   int64  `json:"offset"`
 }
 
 type RelpRawMessage struct {
@@ -143,75 +207,254 @@ func (m *SyslogMessage) String() string {
 	)
 }
 
-type Parser struct {
-	format string
+// SetPeerCredentials exposes the peer credentials in Properties["creds"], so
+// that they are available to the Javascript functions and to the templates.
+// They replace whatever the sender may have put there.
+func (m *SyslogMessage) SetPeerCredentials(creds *PeerCredentials) {
+	if creds == nil {
+		return
+	}
+	if m.Properties == nil {
+		m.Properties = map[string]interface{}{}
+	}
+	props := map[string]interface{}{
+		"pid": creds.Pid,
+		"uid": creds.Uid,
+		"gid": creds.Gid,
+	}
+	if len(creds.Comm) > 0 {
+		props["comm"] = creds.Comm
+	}
+	if len(creds.Exe) > 0 {
+		props["exe"] = creds.Exe
+	}
+	if len(creds.Cgroup) > 0 {
+		props["cgroup"] = creds.Cgroup
+	}
+	m.Properties["creds"] = props
 }
 
-func (p *Parser) Parse(m string, dont_parse_sd bool) (*SyslogMessage, error) {
-	return Parse(m, p.format, dont_parse_sd)
+// SetTLSPeer exposes the client certificate in Properties["tls"], so that
+// it is available to the Javascript functions and to the templates. It
+// replaces whatever the sender may have put there.
+func (m *SyslogMessage) SetTLSPeer(peer *TLSPeer) {
+	if peer == nil {
+		return
+	}
+	if m.Properties == nil {
+		m.Properties = map[string]interface{}{}
+	}
+	m.Properties["tls"] = map[string]interface{}{
+		"cn":           peer.CN,
+		"dns_names":    peer.DNSNames,
+		"ip_addresses": peer.IPAddresses,
+		"emails":       peer.Emails,
+		"fingerprint":  peer.Fingerprint,
+	}
 }
 
-func GetParser(format string) *Parser {
-	if format == "rfc5424" || format == "rfc3164" || format == "json" || format == "auto" {
-		return &Parser{format: format}
+// Unparsed returns a message that carries the raw text of a message that
+// could not be parsed, with the parsing error in Properties["parse_error"].
+func Unparsed(m string, err error) *SyslogMessage {
+	n := time.Now()
+	return &SyslogMessage{
+		Priority:      Priority(13),
+		Facility:      Facility(1),
+		Severity:      Severity(5),
+		Version:       1,
+		TimeReported:  n,
+		TimeGenerated: n,
+		Message:       m,
+		Properties:    map[string]interface{}{"parse_error": err.Error()},
 	}
-	return nil
 }
 
-func Parse(m string, format string, dont_parse_sd bool) (sm *SyslogMessage, err error) {
+// IsUnparsed tells if the message was built by Unparsed.
+func (m *SyslogMessage) IsUnparsed() bool {
+	if m == nil || m.Properties == nil {
+		return false
+	}
+	_, ok := m.Properties["parse_error"]
+	return ok
+}
 
+type Parser struct {
+	format          string
+	location        *time.Location
+	futureTolerance time.Duration
+}
+
+func (p *Parser) Parse(m string, dont_parse_sd bool) (sm *SyslogMessage, err error) {
+	format := p.format
+	if format == "auto" {
+		format = DetectFormat(m)
+	}
 	switch format {
 	case "rfc5424":
 		sm, err = ParseRfc5424Format(m, dont_parse_sd)
+	case "rfc5424-strict":
+		sm, err = ParseRfc5424StrictFormat(m, dont_parse_sd)
 	case "rfc3164":
-		sm, err = ParseRfc3164Format(m)
+		sm, err = parseRfc3164Format(m, p.location, p.futureTolerance)
 	case "json":
 		sm, err = ParseJsonFormat(m)
-	case "auto":
-		if m[0] == byte('{') {
-			sm, err = ParseJsonFormat(m)
-		} else if m[0] != byte('<') {
-			sm, err = ParseRfc3164Format(m)
-		} else {
-			i := strings.Index(m, ">")
-			if i < 2 {
-				sm, err = ParseRfc3164Format(m)
-			} else if len(m) == (i + 1) {
-				sm, err = ParseRfc3164Format(m)
-			} else if m[i+1] == byte('1') {
-				sm, err = ParseRfc5424Format(m, dont_parse_sd)
-			} else {
-				sm, err = ParseRfc3164Format(m)
-			}
-		}
-
+	case "gelf":
+		sm, err = ParseGelfFormat(m)
+	case "cef":
+		sm, err = p.parseEvent(m, dont_parse_sd, "CEF:", "cef", "device_product", ParseCEF)
+	case "leef":
+		sm, err = p.parseEvent(m, dont_parse_sd, "LEEF:", "leef", "product", ParseLEEF)
 	default:
 		return nil, &UnknownFormatError{format}
 	}
 	if err != nil {
 		return nil, err
 	}
-	// special handling of JSON messages produced by go-audit
-	if sm.Appname == "go-audit" {
-		var auditMsg AuditMessageGroup
-		err = json.Unmarshal([]byte(sm.Message), &auditMsg)
+	return auditMessage(sm), nil
+}
+
+// parseEvent parses a CEF or LEEF event, sent alone or inside a syslog
+// message (RFC5424 or RFC3164). The event fields are stored in
+// Properties[name], and the event is kept as the Message. When the syslog
+// header does not give an application name, the product of the event is
+// used.
+func (p *Parser) parseEvent(m string, dont_parse_sd bool, marker string, name string, appKey string, parse func(string) (map[string]interface{}, error)) (*SyslogMessage, error) {
+	idx := strings.Index(m, marker)
+	if idx < 0 {
+		idx = 0
+	}
+	body := strings.TrimSpace(m[idx:])
+	event, err := parse(body)
+	if err != nil {
+		return nil, err
+	}
+	var sm *SyslogMessage
+	envelope := strings.TrimSpace(m[:idx])
+	if len(envelope) == 0 {
+		n := time.Now()
+		sm = &SyslogMessage{Version: 1, TimeReported: n, TimeGenerated: n}
+	} else {
+		envelopeParser := Parser{format: DetectFormat(envelope), location: p.location, futureTolerance: p.futureTolerance}
+		sm, err = envelopeParser.Parse(envelope, dont_parse_sd)
 		if err != nil {
-			return sm, nil
+			return nil, err
 		}
-		sm.AuditSubMessages = auditMsg.Msgs
-		if len(auditMsg.UidMap) > 0 {
-			if sm.Properties == nil {
-				sm.Properties = map[string]interface{}{}
-			}
-			props := map[string]map[string]string{}
-			props["uid_map"] = auditMsg.UidMap
-			sm.Properties["audit"] = props
+		if len(sm.Hostname) == 0 && len(sm.Appname) == 0 && !strings.Contains(sm.Message, " ") {
+			// "<PRI>Mmm dd hh:mm:ss HOSTNAME CEF:..."
+			sm.Hostname = sm.Message
 		}
-		sm.Message = ""
 	}
+	if len(sm.Appname) == 0 {
+		sm.Appname, _ = event[appKey].(string)
+	}
+	sm.Message = body
+	if sm.Properties == nil {
+		sm.Properties = map[string]interface{}{}
+	}
+	sm.Properties[name] = event
 	return sm, nil
 }
 
+// SetTimezone sets how the RFC3164 timestamps, that have no year and no
+// time zone, are read: in loc, and in the year that puts them closest to
+// now without being more than futureTolerance in the future.
+func (p *Parser) SetTimezone(loc *time.Location, futureTolerance time.Duration) {
+	p.location = loc
+	p.futureTolerance = futureTolerance
+}
+
+// builtinFormats are the formats of the built-in parsers. The custom
+// parsers can not use these names.
+var builtinFormats = map[string]bool{
+	"rfc5424":        true,
+	"rfc5424-strict": true,
+	"rfc3164":        true,
+	"json":           true,
+	"auto":           true,
+	"gelf":           true,
+	"cef":            true,
+	"leef":           true,
+}
+
+// IsBuiltinFormat tells if format is the format of a built-in parser.
+func IsBuiltinFormat(format string) bool {
+	return builtinFormats[format]
+}
+
+// DefaultFraming returns the framing of the stream listeners for format,
+// when none is configured. The built-in syslog formats accept both
+// octet-counting and LF framing, GELF messages end with a NUL byte, and the
+// custom parsers read lines.
+func DefaultFraming(format string) string {
+	switch {
+	case format == "gelf":
+		return "nul"
+	case IsBuiltinFormat(format):
+		return "auto"
+	default:
+		return "lf"
+	}
+}
+
+func GetParser(format string) *Parser {
+	if IsBuiltinFormat(format) {
+		return &Parser{format: format, location: time.UTC, futureTolerance: DefaultFutureTolerance}
+	}
+	return nil
+}
+
+func Parse(m string, format string, dont_parse_sd bool) (sm *SyslogMessage, err error) {
+	p := GetParser(format)
+	if p == nil {
+		return nil, &UnknownFormatError{format}
+	}
+	return p.Parse(m, dont_parse_sd)
+}
+
+// auditMessage decodes the JSON messages produced by go-audit.
+func auditMessage(sm *SyslogMessage) *SyslogMessage {
+	if sm.Appname != "go-audit" {
+		return sm
+	}
+	var auditMsg AuditMessageGroup
+	err := json.Unmarshal([]byte(sm.Message), &auditMsg)
+	if err != nil {
+		return sm
+	}
+	sm.AuditSubMessages = auditMsg.Msgs
+	if len(auditMsg.UidMap) > 0 {
+		if sm.Properties == nil {
+			sm.Properties = map[string]interface{}{}
+		}
+		props := map[string]map[string]string{}
+		props["uid_map"] = auditMsg.UidMap
+		sm.Properties["audit"] = props
+	}
+	sm.Message = ""
+	return sm
+}
+
+// DetectFormat guesses the format of a message: json, rfc5424 or rfc3164.
+func DetectFormat(m string) string {
+	if len(m) == 0 {
+		return "rfc3164"
+	}
+	if m[0] == byte('{') {
+		return "json"
+	}
+	if m[0] != byte('<') {
+		return "rfc3164"
+	}
+	i := strings.Index(m, ">")
+	if i < 2 || len(m) == (i+1) {
+		return "rfc3164"
+	}
+	if m[i+1] == byte('1') {
+		return "rfc5424"
+	}
+	return "rfc3164"
+}
+
 func TopicNameIsValid(name string) bool {
 	if len(name) == 0 {
 		return false
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"net"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/metrics"
+)
+
+// listenerLimits enforces the allow and deny lists and the rate limits of a
+// [[syslog]] section.
+type listenerLimits struct {
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	proxies  []*net.IPNet
+	clients  *keyedLimiter
+	listener *keyedLimiter
+	action   string
+	protocol string
+	metrics  *metrics.Metrics
+	logger   log15.Logger
+	// the denied clients are logged at most once a minute each
+	denials *keyedLimiter
+}
+
+func newListenerLimits(config *conf.SyslogConfig, protocol string, m *metrics.Metrics, logger log15.Logger) *listenerLimits {
+	// the lists have been checked in conf.Complete()
+	allow, _ := conf.ParseCIDRs(config.Allow)
+	deny, _ := conf.ParseCIDRs(config.Deny)
+	proxies, _ := conf.ParseCIDRs(config.ProxyFrom)
+	return &listenerLimits{
+		allow:    allow,
+		deny:     deny,
+		proxies:  proxies,
+		clients:  newKeyedLimiter(config.ClientRate, config.ClientBurst),
+		listener: newKeyedLimiter(config.ListenerRate, config.ListenerBurst),
+		action:   config.RateLimitAction,
+		protocol: protocol,
+		metrics:  m,
+		logger:   logger,
+		denials:  newKeyedLimiter(1.0/60, 1),
+	}
+}
+
+// limits returns the limits of a listener.
+func (s *GenericService) limits(config *conf.SyslogConfig) *listenerLimits {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	if s.listenersLimits == nil {
+		s.listenersLimits = map[*conf.SyslogConfig]*listenerLimits{}
+	}
+	l, ok := s.listenersLimits[config]
+	if !ok {
+		l = newListenerLimits(config, s.protocol, s.metrics, s.logger)
+		s.listenersLimits[config] = l
+	}
+	return l
+}
+
+// clientIP returns the IP address of a client. Clients on unix sockets are
+// considered as 127.0.0.1.
+func clientIP(remote net.Addr) net.IP {
+	switch addr := remote.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	case nil:
+		return net.IPv4(127, 0, 0, 1)
+	case *net.UnixAddr:
+		return net.IPv4(127, 0, 0, 1)
+	default:
+		host, _, err := net.SplitHostPort(remote.String())
+		if err != nil {
+			return net.IPv4(127, 0, 0, 1)
+		}
+		return net.ParseIP(host)
+	}
+}
+
+func matches(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// permitted checks the client IP against the deny list, then the allow
+// list. An empty allow list allows everyone.
+func (l *listenerLimits) permitted(ip net.IP) bool {
+	if len(l.allow) == 0 && len(l.deny) == 0 {
+		return true
+	}
+	decision := "allowed"
+	if ip == nil || matches(l.deny, ip) || (len(l.allow) > 0 && !matches(l.allow, ip)) {
+		decision = "denied"
+	}
+	if l.metrics != nil {
+		l.metrics.AclDecisionsCounter.WithLabelValues(l.protocol, decision).Inc()
+	}
+	if decision == "denied" && l.logger != nil && l.denials.allow(ip.String()) {
+		l.logger.Info("Client denied by the allow and deny lists", "protocol", l.protocol, "client", ip.String())
+	}
+	return decision == "allowed"
+}
+
+// trustedProxy tells if a load balancer may send PROXY protocol headers.
+// An empty proxy_protocol_from list trusts nobody.
+func (l *listenerLimits) trustedProxy(ip net.IP) bool {
+	return ip != nil && matches(l.proxies, ip)
+}
+
+// wait applies the rate limits to one message of client. With the delay
+// action, it sleeps until the message can be accepted. Otherwise it returns
+// false when the message exceeds the limits: the caller then drops the
+// message, or disconnects the client if disconnect is true.
+func (l *listenerLimits) wait(client string) (ok bool, disconnect bool) {
+	if l.clients == nil && l.listener == nil {
+		return true, false
+	}
+	if l.action == "delay" {
+		d := l.clients.delay(client)
+		if ld := l.listener.delay(""); ld > d {
+			d = ld
+		}
+		if d > 0 {
+			l.count("delay")
+			time.Sleep(d)
+		}
+		return true, false
+	}
+	if l.clients.allow(client) && l.listener.allow("") {
+		return true, false
+	}
+	l.count(l.action)
+	return false, l.action == "disconnect"
+}
+
+func (l *listenerLimits) count(action string) {
+	if l.metrics != nil {
+		l.metrics.RateLimitedCounter.WithLabelValues(l.protocol, action).Inc()
+	}
+}
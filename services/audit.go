@@ -117,6 +117,9 @@ func (s *AuditService) SetAuditConf(ac *conf.AuditConfig) {
 	s.aconf = ac
 }
 
+func (s *AuditService) SetWatcherConf(wc []conf.WatcherConfig, offsets map[string]*model.FileOffset) {
+}
+
 func (s *AuditService) Stop() {
 	if s.cancel != nil {
 		s.cancel()
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/sys"
+)
+
+// unixConnOf returns the unix socket under conn, if there is one.
+func unixConnOf(conn net.Conn) *net.UnixConn {
+	switch c := conn.(type) {
+	case *net.UnixConn:
+		return c
+	case *sys.FileConn:
+		// the connection was accepted by the binder
+		if uc, ok := c.Conn.(*net.UnixConn); ok {
+			return uc
+		}
+	}
+	return nil
+}
+
+// streamCredentials returns the credentials of the process connected to a
+// unix stream socket, or nil for the other kind of connections.
+func streamCredentials(conn net.Conn) *model.PeerCredentials {
+	uc := unixConnOf(conn)
+	if uc == nil {
+		return nil
+	}
+	pid, uid, gid, err := sys.GetCredentials(uc)
+	if err != nil {
+		return nil
+	}
+	return &model.PeerCredentials{Pid: pid, Uid: uid, Gid: gid}
+}
+
+type procInfo struct {
+	comm    string
+	exe     string
+	cgroup  string
+	fetched time.Time
+}
+
+// procInfoCache avoids reading /proc for each message of a process.
+type procInfoCache struct {
+	mu    *sync.Mutex
+	infos map[int32]*procInfo
+}
+
+func newProcInfoCache() *procInfoCache {
+	return &procInfoCache{mu: &sync.Mutex{}, infos: map[int32]*procInfo{}}
+}
+
+// fill adds comm, exe and cgroup to the credentials.
+func (c *procInfoCache) fill(creds *model.PeerCredentials) {
+	if creds == nil || creds.Pid <= 0 {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	info, ok := c.infos[creds.Pid]
+	c.mu.Unlock()
+	// the pid may have been reused by another process
+	if !ok || now.Sub(info.fetched) > 10*time.Second {
+		comm, exe, cgroup := sys.ProcessInfo(creds.Pid)
+		info = &procInfo{comm: comm, exe: exe, cgroup: cgroup, fetched: now}
+		c.mu.Lock()
+		if len(c.infos) >= 4096 {
+			c.infos = map[int32]*procInfo{}
+		}
+		c.infos[creds.Pid] = info
+		c.mu.Unlock()
+	}
+	creds.Comm = info.comm
+	creds.Exe = info.exe
+	creds.Cgroup = info.cgroup
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// FramingError is returned when an octet-counted frame can not be decoded.
+// The stream can not be resynchronized, so the connection has to be closed.
+type FramingError struct {
+	Message string
+}
+
+func (e *FramingError) Error() string {
+	return e.Message
+}
+
+// tcpFramer splits a syslog stream according to the framing option, see
+// RFC6587 and RFC5425.
+//
+// octet-counting: "LEN SP MSG"
+// lf, crlf, nul: the message ends with the corresponding delimiter
+// auto: octet-counting if the frame starts with a digit, otherwise the
+// message ends with LF or NUL
+//
+// Frames bigger than maxSize are discarded.
+type tcpFramer struct {
+	framing   string
+	maxSize   int
+	discard   int
+	skipping  bool
+	oversized func()
+	malformed func()
+}
+
+func newTcpFramer(framing string, maxSize int, oversized func(), malformed func()) *tcpFramer {
+	if maxSize <= 0 {
+		maxSize = 65536
+	}
+	if oversized == nil {
+		oversized = func() {}
+	}
+	if malformed == nil {
+		malformed = func() {}
+	}
+	return &tcpFramer{framing: framing, maxSize: maxSize, oversized: oversized, malformed: malformed}
+}
+
+// BufferSize is the size the bufio.Scanner buffer must be allowed to grow to.
+func (f *tcpFramer) BufferSize() int {
+	// room for the octet-counting header
+	return f.maxSize + 32
+}
+
+func (f *tcpFramer) Split(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	if f.discard > 0 {
+		// drop the rest of an oversized octet-counted frame
+		n := f.discard
+		if n > len(data) {
+			n = len(data)
+		}
+		f.discard -= n
+		return n, nil, nil
+	}
+	if f.skipping {
+		return f.splitDelimited(data, atEOF)
+	}
+	switch f.framing {
+	case "octet-counting":
+		return f.splitOctetCounting(data, atEOF)
+	case "auto":
+		trimmed := bytes.TrimLeft(data, " \r\n\x00")
+		if len(trimmed) == 0 {
+			return len(data), nil, nil
+		}
+		if trimmed[0] >= '0' && trimmed[0] <= '9' {
+			return f.splitOctetCounting(data, atEOF)
+		}
+		return f.splitDelimited(data, atEOF)
+	default:
+		return f.splitDelimited(data, atEOF)
+	}
+}
+
+// findEnd returns the position of the delimiter and its length.
+func (f *tcpFramer) findEnd(data []byte) (int, int) {
+	switch f.framing {
+	case "crlf":
+		return bytes.Index(data, []byte("\r\n")), 2
+	case "nul":
+		return bytes.IndexByte(data, 0), 1
+	case "auto":
+		return bytes.IndexAny(data, "\n\x00"), 1
+	default:
+		return bytes.IndexByte(data, '\n'), 1
+	}
+}
+
+func (f *tcpFramer) splitDelimited(data []byte, atEOF bool) (int, []byte, error) {
+	end, dlen := f.findEnd(data)
+	if f.skipping {
+		// drop the end of an oversized frame
+		if end < 0 {
+			return len(data), nil, nil
+		}
+		f.skipping = false
+		return end + dlen, nil, nil
+	}
+	if end < 0 {
+		if len(data) > f.maxSize {
+			f.oversized()
+			f.skipping = true
+			return len(data), nil, nil
+		}
+		if atEOF {
+			// the last message may not be terminated
+			return len(data), trimFrame(data), nil
+		}
+		return 0, nil, nil
+	}
+	if end > f.maxSize {
+		f.oversized()
+		return end + dlen, nil, nil
+	}
+	return end + dlen, trimFrame(data[:end]), nil
+}
+
+func (f *tcpFramer) splitOctetCounting(data []byte, atEOF bool) (int, []byte, error) {
+	trimmed := bytes.TrimLeft(data, " \r\n\x00")
+	skipped := len(data) - len(trimmed)
+	if len(trimmed) == 0 {
+		return len(data), nil, nil
+	}
+	sp := bytes.IndexByte(trimmed, ' ')
+	if sp < 0 {
+		if len(trimmed) > 10 || atEOF {
+			f.malformed()
+			return 0, nil, &FramingError{"Invalid octet-counting header"}
+		}
+		return 0, nil, nil
+	}
+	datalen, err := strconv.Atoi(string(trimmed[:sp]))
+	if err != nil || datalen < 0 {
+		f.malformed()
+		return 0, nil, &FramingError{fmt.Sprintf("Invalid octet-counting length '%s'", string(trimmed[:sp]))}
+	}
+	header := skipped + sp + 1
+	if datalen > f.maxSize {
+		f.oversized()
+		f.discard = datalen
+		return header, nil, nil
+	}
+	if len(data) < header+datalen {
+		if atEOF {
+			// truncated frame
+			f.malformed()
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+	return header + datalen, trimFrame(data[header : header+datalen]), nil
+}
+
+// trimFrame removes the surrounding whitespace of a frame. Empty frames are
+// returned as nil, so that they are skipped by the scanner.
+func trimFrame(frame []byte) []byte {
+	frame = bytes.Trim(frame, " \r\n\x00")
+	if len(frame) == 0 {
+		return nil
+	}
+	return frame
+}
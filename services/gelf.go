@@ -0,0 +1,99 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+const (
+	gelfMaxChunks      = 128
+	gelfChunkHeaderLen = 12
+	gelfMaxPending     = 4096
+	gelfChunksTimeout  = 5 * time.Second
+)
+
+type gelfChunks struct {
+	client   string
+	first    time.Time
+	received int
+	parts    [][]byte
+}
+
+// gelfAssembler reassembles the chunked GELF datagrams. The chunks of a
+// message must arrive within 5 seconds, as in the Graylog server.
+type gelfAssembler struct {
+	mu        sync.Mutex
+	pending   map[string]*gelfChunks
+	lastPurge time.Time
+	// expired is called for each incomplete message that is dropped
+	expired func(client string)
+}
+
+func newGelfAssembler(expired func(client string)) *gelfAssembler {
+	if expired == nil {
+		expired = func(string) {}
+	}
+	return &gelfAssembler{pending: map[string]*gelfChunks{}, lastPurge: time.Now(), expired: expired}
+}
+
+// add returns the payload of a message when it is complete. Datagrams that
+// are not chunked are returned right away. The returned payload may still
+// be compressed.
+func (a *gelfAssembler) add(client string, datagram []byte) ([]byte, error) {
+	if !bytes.HasPrefix(datagram, gelfChunkMagic) {
+		return datagram, nil
+	}
+	if len(datagram) < gelfChunkHeaderLen {
+		return nil, fmt.Errorf("GELF chunk is too short")
+	}
+	seq := int(datagram[10])
+	count := int(datagram[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return nil, fmt.Errorf("Invalid GELF chunk sequence %d/%d", seq, count)
+	}
+	key := client + "/" + string(datagram[2:10])
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.lastPurge) > time.Second {
+		a.purge(now)
+	}
+	chunks, ok := a.pending[key]
+	if !ok {
+		if len(a.pending) >= gelfMaxPending {
+			return nil, fmt.Errorf("Too many incomplete GELF messages")
+		}
+		chunks = &gelfChunks{client: client, first: now, parts: make([][]byte, count)}
+		a.pending[key] = chunks
+	}
+	if len(chunks.parts) != count {
+		delete(a.pending, key)
+		return nil, fmt.Errorf("Inconsistent GELF chunks count")
+	}
+	if chunks.parts[seq] == nil {
+		// the datagram buffer is reused by the reader
+		chunks.parts[seq] = append([]byte{}, datagram[gelfChunkHeaderLen:]...)
+		chunks.received++
+	}
+	if chunks.received < count {
+		return nil, nil
+	}
+	delete(a.pending, key)
+	return bytes.Join(chunks.parts, nil), nil
+}
+
+// purge drops the messages whose chunks did not arrive in time.
+func (a *gelfAssembler) purge(now time.Time) {
+	a.lastPurge = now
+	for key, chunks := range a.pending {
+		if now.Sub(chunks.first) > gelfChunksTimeout {
+			delete(a.pending, key)
+			a.expired(chunks.client)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func gelfChunk(id []byte, seq byte, count byte, payload string) []byte {
+	chunk := append([]byte{}, gelfChunkMagic...)
+	chunk = append(chunk, id...)
+	chunk = append(chunk, seq, count)
+	return append(chunk, payload...)
+}
+
+func TestGelfAssembler(t *testing.T) {
+	a := newGelfAssembler(nil)
+	id := []byte("abcdefgh")
+	payload, err := a.add("192.0.2.1", gelfChunk(id, 1, 2, `"b"}`))
+	if err != nil || payload != nil {
+		t.Fatalf("first chunk: %q, %v", payload, err)
+	}
+	// the same message id from another client is another message
+	payload, err = a.add("192.0.2.2", gelfChunk(id, 0, 2, `{"a":`))
+	if err != nil || payload != nil {
+		t.Fatalf("other client: %q, %v", payload, err)
+	}
+	payload, err = a.add("192.0.2.1", gelfChunk(id, 0, 2, `{"a":`))
+	if err != nil || !bytes.Equal(payload, []byte(`{"a":"b"}`)) {
+		t.Errorf("payload = %q, %v", payload, err)
+	}
+	payload, err = a.add("192.0.2.1", []byte(`{"short_message":"x"}`))
+	if err != nil || !bytes.Equal(payload, []byte(`{"short_message":"x"}`)) {
+		t.Errorf("not chunked: %q, %v", payload, err)
+	}
+	_, err = a.add("192.0.2.1", gelfChunk(id, 2, 2, "x"))
+	if err == nil {
+		t.Errorf("sequence out of range: expected an error")
+	}
+}
+
+// TestGelfExpired checks that the client of an incomplete message is
+// reported, even when the message id contains '/'.
+func TestGelfExpired(t *testing.T) {
+	expired := []string{}
+	a := newGelfAssembler(func(client string) { expired = append(expired, client) })
+	_, err := a.add("192.0.2.1", gelfChunk([]byte("a/b/c/d/"), 0, 2, "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.mu.Lock()
+	a.purge(time.Now().Add(2 * gelfChunksTimeout))
+	a.mu.Unlock()
+	if len(expired) != 1 || expired[0] != "192.0.2.1" {
+		t.Errorf("expired = %q", expired)
+	}
+}
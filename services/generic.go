@@ -3,10 +3,14 @@ package services
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
+	"math/rand"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/inconshreveable/log15"
 	"github.com/oklog/ulid"
@@ -15,7 +19,6 @@ import (
 	"github.com/stephane-martin/skewer/metrics"
 	"github.com/stephane-martin/skewer/model"
 	"github.com/stephane-martin/skewer/sys"
-	"github.com/stephane-martin/skewer/utils"
 )
 
 type NetworkService interface {
@@ -25,6 +28,7 @@ type NetworkService interface {
 	SetConf(sc []*conf.SyslogConfig, pc []conf.ParserConfig)
 	SetKafkaConf(kc *conf.KafkaConfig)
 	SetAuditConf(ac *conf.AuditConfig)
+	SetWatcherConf(wc []conf.WatcherConfig, offsets map[string]*model.FileOffset)
 }
 
 func NewNetworkService(t string, stasher model.Stasher, gen chan ulid.ULID,
@@ -36,6 +40,8 @@ func NewNetworkService(t string, stasher model.Stasher, gen chan ulid.ULID,
 		return NewUdpService(stasher, gen, b, m, l), nil
 	case "skewer-relp":
 		return NewRelpService(b, m, l), nil
+	case "skewer-http":
+		return NewHttpService(stasher, gen, b, m, l), nil
 	case "skewer-journal":
 		ctx, cancel := context.WithCancel(context.Background())
 		s, err := NewJournalService(ctx, stasher, gen, m, l)
@@ -48,6 +54,8 @@ func NewNetworkService(t string, stasher model.Stasher, gen chan ulid.ULID,
 		}
 	case "skewer-audit":
 		return NewAuditService(stasher, gen, m, l), nil
+	case "skewer-watcher":
+		return NewWatcherService(stasher, gen, m, l), nil
 	default:
 		return nil, nil
 	}
@@ -70,10 +78,13 @@ type GenericService struct {
 	ParserConfigs   []conf.ParserConfig
 	logger          log15.Logger
 	binder          *sys.BinderClient
+	metrics         *metrics.Metrics
 	unixSocketPaths []string
 	wg              *sync.WaitGroup
 	protocol        string
-	connections     map[Connection]bool
+	connections     map[Connection]*trackedConn
+	listenersLimits map[*conf.SyslogConfig]*listenerLimits
+	listenersTLS    map[*conf.SyslogConfig]*listenerTLS
 	connMutex       *sync.Mutex
 	statusMutex     *sync.Mutex
 }
@@ -82,7 +93,7 @@ func (s *GenericService) init() {
 	s.wg = &sync.WaitGroup{}
 	s.unixSocketPaths = []string{}
 	s.connMutex = &sync.Mutex{}
-	s.connections = map[Connection]bool{}
+	s.connections = map[Connection]*trackedConn{}
 	s.statusMutex = &sync.Mutex{}
 }
 
@@ -94,6 +105,8 @@ func (s *GenericService) SetConf(sc []*conf.SyslogConfig, pc []conf.ParserConfig
 type TCPListenerConf struct {
 	Listener net.Listener
 	Conf     *conf.SyslogConfig
+	// Addr is the host:port the listener is bound to
+	Addr string
 }
 
 type UnixListenerConf struct {
@@ -107,6 +120,9 @@ type StreamingService struct {
 	unixListeners []*UnixListenerConf
 	acceptsWg     *sync.WaitGroup
 	handler       StreamHandler
+	// drainTimeout is how long the client connections are given to end by
+	// themselves after the listeners have been closed
+	drainTimeout time.Duration
 }
 
 func (s *StreamingService) init() {
@@ -117,32 +133,136 @@ func (s *StreamingService) init() {
 }
 
 type ParsersEnv struct {
-	jsenv javascript.ParsersEnvironment
+	jsenv     javascript.ParsersEnvironment
+	patterns  map[string]*model.PatternParser
+	locations map[string]*time.Location
+	bodies    map[*conf.SyslogConfig]*model.BodyParser
+	hostname  string
 }
 
 func NewParsersEnv(parsersConf []conf.ParserConfig, logger log15.Logger) *ParsersEnv {
 	p := javascript.NewParsersEnvironment(logger)
+	patterns := map[string]*model.PatternParser{}
 	for _, parserConf := range parsersConf {
-		err := p.AddParser(parserConf.Name, parserConf.Func)
+		var err error
+		switch parserConf.Type {
+		case "regex":
+			patterns[parserConf.Name], err = model.NewRegexParser(parserConf.Name, parserConf.Pattern, parserConf.TimeFormat)
+		case "grok":
+			patterns[parserConf.Name], err = model.NewGrokParser(parserConf.Name, parserConf.Pattern, parserConf.Patterns, parserConf.TimeFormat)
+		default:
+			err = p.AddParser(parserConf.Name, parserConf.Func)
+		}
 		if err != nil {
+			delete(patterns, parserConf.Name)
 			logger.Warn("Error initializing parser", "name", parserConf.Name, "error", err)
 		}
 	}
-	return &ParsersEnv{p}
+	return &ParsersEnv{
+		jsenv:     p,
+		patterns:  patterns,
+		locations: map[string]*time.Location{},
+		bodies:    map[*conf.SyslogConfig]*model.BodyParser{},
+	}
 }
 
 func (e *ParsersEnv) GetParser(parserName string) Parser {
-	switch parserName {
-	case "rfc5424", "rfc3164", "json", "auto":
+	if model.IsBuiltinFormat(parserName) {
 		return model.GetParser(parserName)
+	}
+	if p, ok := e.patterns[parserName]; ok {
+		// a copy, as the sections may have different time zones
+		parser := *p
+		return &parser
+	}
+	return e.jsenv.GetParser(parserName)
+}
+
+// GetSyslogParser returns the parser of a syslog section. The built-in and
+// the pattern parsers read the timestamps without a time zone in the time
+// zone of the section. The
+// messages received on the local syslog socket get the local hostname. When
+// the section has a body_parser, it runs after the parser.
+func (e *ParsersEnv) GetSyslogParser(config *conf.SyslogConfig) Parser {
+	parser := e.GetParser(config.Format)
+	if parser == nil {
+		return nil
+	}
+	loc, ok := e.locations[config.Timezone]
+	if !ok {
+		// the timezone has been checked in conf.Complete()
+		loc, _ = config.GetLocation()
+		e.locations[config.Timezone] = loc
+	}
+	switch p := parser.(type) {
+	case *model.Parser:
+		p.SetTimezone(loc, config.FutureTolerance)
+	case *model.PatternParser:
+		p.SetTimezone(loc, config.FutureTolerance)
+	}
+	if config.Protocol == "local" {
+		if len(e.hostname) == 0 {
+			e.hostname, _ = os.Hostname()
+		}
+		if len(strings.TrimSpace(config.Timezone)) == 0 {
+			// syslog(3) writes the local time
+			loc = time.Local
+		}
+		parser = &localParser{
+			format:          config.Format,
+			parser:          parser,
+			hostname:        e.hostname,
+			location:        loc,
+			futureTolerance: config.FutureTolerance,
+		}
+	}
+	body, ok := e.bodies[config]
+	if !ok {
+		// the body parser has been checked in conf.Complete()
+		body, _ = config.GetBodyParser()
+		e.bodies[config] = body
+	}
+	return withBodyParser(parser, body)
+}
+
+// onParseError applies the on_parse_error policy of a section to a message
+// that could not be parsed. It returns nil when the message is dropped.
+func onParseError(config *conf.SyslogConfig, m string, err error) *model.SyslogMessage {
+	switch config.OnParseError {
+	case "wrap", "deadletter":
+		return model.Unparsed(m, err)
 	default:
-		return e.jsenv.GetParser(parserName)
+		return nil
 	}
 }
 
+// bodyParser extracts the key/values of the MSG part after the parser of
+// the section has parsed the envelope. When the body can not be extracted,
+// the message is kept as is.
+type bodyParser struct {
+	parser Parser
+	body   *model.BodyParser
+}
+
+func withBodyParser(parser Parser, body *model.BodyParser) Parser {
+	if parser == nil || body == nil {
+		return parser
+	}
+	return &bodyParser{parser: parser, body: body}
+}
+
+func (p *bodyParser) Parse(m string, dont_parse_sd bool) (*model.SyslogMessage, error) {
+	sm, err := p.parser.Parse(m, dont_parse_sd)
+	if err != nil || sm == nil {
+		return sm, err
+	}
+	p.body.Extract(sm)
+	return sm, nil
+}
+
 func (s *StreamingService) initTCPListeners() []*model.ListenerInfo {
 	nb := 0
-	s.connections = map[Connection]bool{}
+	s.connections = map[Connection]*trackedConn{}
 	s.tcpListeners = []*TCPListenerConf{}
 	s.unixListeners = []*UnixListenerConf{}
 	//fmt.Println(s.SyslogConfigs)
@@ -176,27 +296,14 @@ func (s *StreamingService) initTCPListeners() []*model.ListenerInfo {
 				s.unixSocketPaths = append(s.unixSocketPaths, syslogConf.UnixSocketPath)
 			}
 		} else {
-			listenAddr, _ := syslogConf.GetListenAddr()
-			l, err := net.Listen("tcp", listenAddr)
-			if err != nil {
-				if s.binder == nil || syslogConf.Port > 1024 {
-					s.logger.Warn("Error listening on stream (TCP or RELP)", "listen_addr", listenAddr, "error", err)
-					l = nil
-				} else {
-					s.logger.Info("Error listening on stream (TCP or RELP). Retrying as root.", "listen_addr", listenAddr, "error", err)
-					l, err = s.binder.Listen("tcp", listenAddr)
-					if err != nil {
-						s.logger.Warn("Parent could not listen either", "listen_addr", listenAddr, "error", err)
-						l = nil
-					}
-				}
-			}
-			if l != nil {
-				s.logger.Debug("Listener", "protocol", s.protocol, "bind_addr", syslogConf.BindAddr, "port", syslogConf.Port, "format", syslogConf.Format)
+			listeners, addrs := s.listenStream(syslogConf)
+			for i, l := range listeners {
+				s.logger.Debug("Listener", "protocol", s.protocol, "listen_addr", addrs[i], "format", syslogConf.Format)
 				nb++
 				lc := TCPListenerConf{
 					Listener: l,
 					Conf:     syslogConf,
+					Addr:     addrs[i],
 				}
 				s.tcpListeners = append(s.tcpListeners, &lc)
 			}
@@ -211,9 +318,11 @@ func (s *StreamingService) initTCPListeners() []*model.ListenerInfo {
 		})
 	}
 	for _, tcpc := range s.tcpListeners {
+		host, port, _ := net.SplitHostPort(tcpc.Addr)
+		portNum, _ := strconv.Atoi(port)
 		infos = append(infos, &model.ListenerInfo{
-			BindAddr: tcpc.Conf.BindAddr,
-			Port:     tcpc.Conf.Port,
+			BindAddr: host,
+			Port:     portNum,
 			Protocol: tcpc.Conf.Protocol,
 		})
 	}
@@ -221,6 +330,50 @@ func (s *StreamingService) initTCPListeners() []*model.ListenerInfo {
 	return infos
 }
 
+// listenStream opens the TCP listeners of a [[syslog]] section, and returns
+// them with their addresses. With a binder, the sockets belong to the root
+// parent, that keeps them open across upgrades. With socket_name, the
+// sockets that systemd gave with that name replace bind_addr and port.
+func (s *GenericService) listenStream(config *conf.SyslogConfig) ([]net.Listener, []string) {
+	listeners := []net.Listener{}
+	addrs := []string{}
+	if len(config.SocketName) > 0 {
+		if s.binder == nil {
+			s.logger.Warn("socket_name needs the root parent", "socket_name", config.SocketName)
+			return listeners, addrs
+		}
+		for i := 0; ; i++ {
+			l, err := s.binder.ListenShared("tcp", fmt.Sprintf("%s#%d", config.SocketName, i), config.SocketName)
+			if err != nil {
+				if i == 0 {
+					s.logger.Warn("Error getting the activated socket", "socket_name", config.SocketName, "error", err)
+				}
+				return listeners, addrs
+			}
+			listeners = append(listeners, l)
+			addrs = append(addrs, l.Addr().String())
+		}
+	}
+	// the addresses have been checked in conf.Complete()
+	listenAddrs, _ := config.GetListenAddrs()
+	for _, listenAddr := range listenAddrs {
+		var l net.Listener
+		var err error
+		if s.binder == nil {
+			l, err = net.Listen("tcp", listenAddr)
+		} else {
+			l, err = s.binder.ListenShared("tcp", listenAddr, "")
+		}
+		if err != nil {
+			s.logger.Warn("Error listening on stream", "protocol", s.protocol, "listen_addr", listenAddr, "error", err)
+			continue
+		}
+		listeners = append(listeners, l)
+		addrs = append(addrs, listenAddr)
+	}
+	return listeners, addrs
+}
+
 func (s *StreamingService) resetTCPListeners() {
 	for _, l := range s.tcpListeners {
 		l.Listener.Close()
@@ -230,10 +383,130 @@ func (s *StreamingService) resetTCPListeners() {
 	}
 }
 
-func (s *GenericService) AddConnection(conn Connection) {
+// trackedConn records which listener and which client a connection belongs
+// to. config is nil for the sockets of the datagram services.
+type trackedConn struct {
+	config *conf.SyslogConfig
+	client string
+	since  time.Time
+	// pending connections wait for their PROXY protocol header: the client
+	// is not known yet
+	pending bool
+}
+
+// countConnections returns the number of connections of a listener, and of
+// one of its clients. connMutex must be held.
+func (s *GenericService) countConnections(config *conf.SyslogConfig, client string) (listener int, perClient int) {
+	for _, t := range s.connections {
+		if t.config == config {
+			listener++
+			if t.client == client && !t.pending {
+				perClient++
+			}
+		}
+	}
+	return
+}
+
+// AddConnection registers a connection. It returns false if the connection
+// would exceed max_connections or max_connections_per_client: the
+// connection is then not registered, and the caller must close it.
+func (s *GenericService) AddConnection(conn Connection, config *conf.SyslogConfig, client string) bool {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	if config != nil && (config.MaxConns > 0 || config.MaxClientConns > 0) {
+		listener, perClient := s.countConnections(config, client)
+		reason := ""
+		if config.MaxConns > 0 && listener >= config.MaxConns {
+			reason = "max_connections"
+		} else if config.MaxClientConns > 0 && perClient >= config.MaxClientConns {
+			reason = "max_connections_per_client"
+		}
+		if len(reason) > 0 {
+			s.logger.Info("Connection refused", "reason", reason, "client", client, "protocol", s.protocol)
+			if s.metrics != nil {
+				s.metrics.RejectedConnectionsCounter.WithLabelValues(s.protocol, reason).Inc()
+			}
+			return false
+		}
+	}
+	s.connections[conn] = &trackedConn{config: config, client: client, since: time.Now()}
+	return true
+}
+
+// addPendingConnection registers a connection that waits for its PROXY
+// protocol header. It counts against max_connections, so that slow load
+// balancers can not pile up goroutines. It returns false if the listener
+// is full: the caller must close the connection.
+func (s *GenericService) addPendingConnection(conn net.Conn, config *conf.SyslogConfig) bool {
 	s.connMutex.Lock()
 	defer s.connMutex.Unlock()
-	s.connections[conn] = true
+	if config.MaxConns > 0 {
+		listener, _ := s.countConnections(config, "")
+		if listener >= config.MaxConns {
+			s.logger.Info("Connection refused", "reason", "max_connections", "proxy", conn.RemoteAddr().String(), "protocol", s.protocol)
+			if s.metrics != nil {
+				s.metrics.RejectedConnectionsCounter.WithLabelValues(s.protocol, "max_connections").Inc()
+			}
+			return false
+		}
+	}
+	s.connections[conn] = &trackedConn{config: config, since: time.Now(), pending: true}
+	return true
+}
+
+// forgetPendingConnection unregisters a pending connection without closing
+// it: the caller handles it once the PROXY protocol header has been read.
+// It returns false if the connection was closed meanwhile by
+// CloseConnections.
+func (s *GenericService) forgetPendingConnection(conn Connection) bool {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	_, ok := s.connections[conn]
+	delete(s.connections, conn)
+	return ok
+}
+
+// listenerFull tells if a listener has reached max_connections.
+func (s *GenericService) listenerFull(config *conf.SyslogConfig) bool {
+	if config.MaxConns <= 0 {
+		return false
+	}
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	listener, _ := s.countConnections(config, "")
+	return listener >= config.MaxConns
+}
+
+// waitForSlot delays the next Accept while the listener is full, for at
+// most accept_backoff. Meanwhile the new clients wait in the kernel accept
+// queue.
+func (s *StreamingService) waitForSlot(config *conf.SyslogConfig) {
+	if config.AcceptBackoff <= 0 {
+		return
+	}
+	deadline := time.Now().Add(config.AcceptBackoff)
+	delay := 5 * time.Millisecond
+	for s.listenerFull(config) && time.Now().Before(deadline) {
+		time.Sleep(delay)
+		if delay < time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// connectionAge returns how long a connection may last before the client is
+// asked to reconnect, or 0. A 10% jitter spreads the reconnections.
+func connectionAge(config *conf.SyslogConfig) time.Duration {
+	age := config.MaxConnAge
+	if age <= 0 {
+		return 0
+	}
+	jitter := int64(age / 10)
+	if jitter > 0 {
+		age += time.Duration(rand.Int63n(2*jitter) - jitter)
+	}
+	return age
 }
 
 func (s *GenericService) RemoveConnection(conn Connection) {
@@ -256,20 +529,64 @@ func (s *GenericService) CloseConnections() {
 			os.Remove(path)
 		}
 	}
-	s.connections = map[Connection]bool{}
+	s.connections = map[Connection]*trackedConn{}
 	s.unixSocketPaths = []string{}
 }
 
+// waitConnections waits until all the client connections have been removed,
+// or until the timeout expires.
+func (s *GenericService) waitConnections(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		s.connMutex.Lock()
+		remaining := len(s.connections)
+		s.connMutex.Unlock()
+		if remaining == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			s.logger.Warn("Drain timeout expired: closing the remaining connections", "connections", remaining)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 func (s *StreamingService) handleConnection(conn net.Conn, config *conf.SyslogConfig) {
 	s.handler.HandleConnection(conn, config)
 }
 
+// retry tells if Accept should be retried after a temporary error (too many
+// open files...), and sleeps before.
+func retry(err error, tempDelay *time.Duration) bool {
+	ne, ok := err.(net.Error)
+	if !ok || !ne.Temporary() {
+		return false
+	}
+	if *tempDelay == 0 {
+		*tempDelay = 5 * time.Millisecond
+	} else {
+		*tempDelay *= 2
+	}
+	if *tempDelay > time.Second {
+		*tempDelay = time.Second
+	}
+	time.Sleep(*tempDelay)
+	return true
+}
+
 func (s *StreamingService) AcceptUnix(lc *UnixListenerConf) {
 	defer s.wg.Done()
 	defer s.acceptsWg.Done()
+	var tempDelay time.Duration
 	for {
+		s.waitForSlot(lc.Conf)
 		conn, accept_err := lc.Listener.Accept()
 		if accept_err != nil {
+			if retry(accept_err, &tempDelay) {
+				s.logger.Warn("AcceptUnix() temporary error", "error", accept_err, "retry_in", tempDelay)
+				continue
+			}
 			switch accept_err.(type) {
 			case *net.OpError:
 				s.logger.Info("AcceptUnix() OpError", "error", accept_err)
@@ -278,6 +595,12 @@ func (s *StreamingService) AcceptUnix(lc *UnixListenerConf) {
 			}
 			return
 		} else if conn != nil {
+			tempDelay = 0
+			if !s.limits(lc.Conf).permitted(clientIP(conn.RemoteAddr())) {
+				s.logger.Info("Unix client denied", "path", lc.Conf.UnixSocketPath)
+				conn.Close()
+				continue
+			}
 			s.wg.Add(1)
 			go s.handleConnection(conn, lc.Conf)
 		}
@@ -288,9 +611,15 @@ func (s *StreamingService) AcceptUnix(lc *UnixListenerConf) {
 func (s *StreamingService) AcceptTCP(lc *TCPListenerConf) {
 	defer s.wg.Done()
 	defer s.acceptsWg.Done()
+	var tempDelay time.Duration
 	for {
+		s.waitForSlot(lc.Conf)
 		c, accept_err := lc.Listener.Accept()
 		if accept_err != nil {
+			if retry(accept_err, &tempDelay) {
+				s.logger.Warn("AcceptTCP() temporary error", "error", accept_err, "retry_in", tempDelay)
+				continue
+			}
 			switch accept_err.(type) {
 			case *net.OpError:
 				s.logger.Info("AcceptTCP() OpError", "error", accept_err)
@@ -299,51 +628,110 @@ func (s *StreamingService) AcceptTCP(lc *TCPListenerConf) {
 			}
 			return
 		} else if c != nil {
+			tempDelay = 0
+			// behind a load balancer, the lists apply to the original client,
+			// once the PROXY protocol header has been read
+			if !lc.Conf.ProxyProtocol && !s.limits(lc.Conf).permitted(clientIP(c.RemoteAddr())) {
+				s.logger.Info("TCP client denied", "client", c.RemoteAddr().String(), "addr", lc.Addr)
+				c.Close()
+				continue
+			}
 			if conn, ok := c.(*net.TCPConn); ok {
 				if lc.Conf.KeepAlive {
 					err := conn.SetKeepAlive(true)
 					if err == nil {
 						err := conn.SetKeepAlivePeriod(lc.Conf.KeepAlivePeriod)
 						if err != nil {
-							s.logger.Warn("Error setting keepalive period", "addr", lc.Conf.BindAddr, "period", lc.Conf.KeepAlivePeriod)
+							s.logger.Warn("Error setting keepalive period", "addr", lc.Addr, "period", lc.Conf.KeepAlivePeriod)
 						}
 					} else {
-						s.logger.Warn("Error setting keepalive", "addr", lc.Conf.BindAddr)
+						s.logger.Warn("Error setting keepalive", "addr", lc.Addr)
 					}
 
 				} else {
 					err := conn.SetKeepAlive(false)
 					if err != nil {
-						s.logger.Warn("Error disabling keepalive", "addr", lc.Conf.BindAddr)
+						s.logger.Warn("Error disabling keepalive", "addr", lc.Addr)
 					}
 				}
 				err := conn.SetNoDelay(true)
 				if err != nil {
-					s.logger.Warn("Error setting TCP NODELAY", "addr", lc.Conf.BindAddr)
+					s.logger.Warn("Error setting TCP NODELAY", "addr", lc.Addr)
 				}
 				err = conn.SetLinger(-1)
 				if err != nil {
-					s.logger.Warn("Error setting TCP LINGER", "addr", lc.Conf.BindAddr)
+					s.logger.Warn("Error setting TCP LINGER", "addr", lc.Addr)
 				}
 			}
-			if lc.Conf.TLSEnabled {
-				tlsConf, err := utils.NewTLSConfig("", lc.Conf.CAFile, lc.Conf.CAPath, lc.Conf.CertFile, lc.Conf.KeyFile, false)
-				if err != nil {
-					s.logger.Warn("Error creating TLS configuration", "error", err)
-				} else {
-					tlsConf.ClientAuth = lc.Conf.GetClientAuthType()
-					s.wg.Add(1)
-					go s.handleConnection(tls.Server(c, tlsConf), lc.Conf)
+			if lc.Conf.ProxyProtocol {
+				// the header is read in its own goroutine, so that a slow
+				// client does not block the Accept loop
+				if !s.addPendingConnection(c, lc.Conf) {
+					c.Close()
+					continue
 				}
-
-			} else {
 				s.wg.Add(1)
-				go s.handleConnection(c, lc.Conf)
+				go s.handleProxyConnection(c, lc.Conf)
+			} else {
+				s.startConnection(c, lc.Conf)
 			}
 		}
 	}
 }
 
+// handleProxyConnection reads the PROXY protocol header of a new connection,
+// then handles the connection with the original client address.
+func (s *StreamingService) handleProxyConnection(c net.Conn, config *conf.SyslogConfig) {
+	defer s.wg.Done()
+	limits := s.limits(config)
+	proxyIP := clientIP(c.RemoteAddr())
+	if !limits.trustedProxy(proxyIP) {
+		s.logger.Warn("PROXY protocol header from an untrusted address", "client", c.RemoteAddr().String(), "addr", config.BindAddr)
+		s.RemoveConnection(c)
+		return
+	}
+	// the allow and deny lists apply to the load balancer too
+	if !limits.permitted(proxyIP) {
+		s.logger.Info("TCP proxy denied", "proxy", c.RemoteAddr().String(), "addr", config.BindAddr)
+		s.RemoveConnection(c)
+		return
+	}
+	pconn, err := readProxyHeader(c, proxyHeaderTimeout)
+	if !s.forgetPendingConnection(c) {
+		// the service is stopping
+		c.Close()
+		return
+	}
+	if err != nil {
+		s.logger.Warn("Error reading the PROXY protocol header", "client", c.RemoteAddr().String(), "addr", config.BindAddr, "error", err)
+		c.Close()
+		return
+	}
+	// the allow and deny lists also apply to the original client
+	if !limits.permitted(clientIP(pconn.RemoteAddr())) {
+		s.logger.Info("TCP client denied", "client", pconn.RemoteAddr().String(), "proxy", c.RemoteAddr().String(), "addr", config.BindAddr)
+		c.Close()
+		return
+	}
+	s.startConnection(pconn, config)
+}
+
+// startConnection starts the TLS handshake if needed, and handles the
+// connection in a new goroutine.
+func (s *StreamingService) startConnection(c net.Conn, config *conf.SyslogConfig) {
+	if config.TLSEnabled {
+		tlsConf, err := s.tlsConfig(config)
+		if err != nil {
+			s.logger.Warn("Error creating TLS configuration", "error", err)
+			c.Close()
+			return
+		}
+		c = tls.Server(c, tlsConf)
+	}
+	s.wg.Add(1)
+	go s.handleConnection(c, config)
+}
+
 func (s *StreamingService) Listen() {
 	s.wg.Add(1)
 	go func() {
@@ -360,7 +748,37 @@ func (s *StreamingService) Listen() {
 		}
 		// wait until the listeners stop and return
 		s.acceptsWg.Wait()
+		if s.drainTimeout > 0 {
+			s.waitConnections(s.drainTimeout)
+		}
 		// close the client connections
 		s.CloseConnections()
 	}()
 }
+
+// remoteHost returns the host part of the address of a client, without the
+// port. IPv6 addresses are returned without brackets.
+func remoteHost(remote net.Addr) string {
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return remote.String()
+	}
+	return host
+}
+
+// addrPort returns the port of a local address. ok is false when the
+// address has no port (unix sockets).
+func addrPort(addr net.Addr) (port int, ok bool) {
+	if addr == nil {
+		return 0, false
+	}
+	_, p, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return 0, false
+	}
+	port, err = strconv.Atoi(p)
+	if err != nil {
+		return 0, false
+	}
+	return port, true
+}
@@ -0,0 +1,423 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/oklog/ulid"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/metrics"
+	"github.com/stephane-martin/skewer/model"
+	"github.com/stephane-martin/skewer/sys"
+)
+
+type HttpServerStatus int
+
+const (
+	HttpStopped HttpServerStatus = iota
+	HttpStarted
+)
+
+// httpServiceImpl receives syslog messages in HTTP POST requests.
+type httpServiceImpl struct {
+	GenericService
+	status     HttpServerStatus
+	statusChan chan HttpServerStatus
+	stasher    model.Stasher
+	generator  chan ulid.ULID
+	servers    []*http.Server
+}
+
+func NewHttpService(stasher model.Stasher, gen chan ulid.ULID, b *sys.BinderClient, m *metrics.Metrics, l log15.Logger) NetworkService {
+	s := httpServiceImpl{status: HttpStopped, stasher: stasher, generator: gen}
+	s.metrics = m
+	s.logger = l.New("class", "HttpServer")
+	s.binder = b
+	s.init()
+	s.protocol = "http"
+	return &s
+}
+
+func (s *httpServiceImpl) SetKafkaConf(kc *conf.KafkaConfig) {}
+
+func (s *httpServiceImpl) SetAuditConf(ac *conf.AuditConfig) {}
+
+func (s *httpServiceImpl) SetWatcherConf(wc []conf.WatcherConfig, offsets map[string]*model.FileOffset) {
+}
+
+func (s *httpServiceImpl) Start(test bool) ([]*model.ListenerInfo, error) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	if s.status != HttpStopped {
+		return nil, ServerNotStopped
+	}
+	s.statusChan = make(chan HttpServerStatus, 1)
+	s.connections = map[Connection]*trackedConn{}
+	s.servers = []*http.Server{}
+
+	infos := []*model.ListenerInfo{}
+	for _, syslogConf := range s.SyslogConfigs {
+		if syslogConf.Protocol != "http" {
+			continue
+		}
+		listeners, addrs := s.listenStream(syslogConf)
+		for i, l := range listeners {
+			listenAddr := addrs[i]
+			l, err := s.secure(syslogConf, l)
+			if err != nil {
+				s.logger.Warn("Error listening on HTTP", "listen_addr", listenAddr, "error", err)
+				continue
+			}
+			server := &http.Server{
+				Handler:      newHttpHandler(s, syslogConf),
+				ReadTimeout:  syslogConf.Timeout,
+				WriteTimeout: syslogConf.Timeout,
+			}
+			s.servers = append(s.servers, server)
+			s.logger.Debug("Listener", "protocol", s.protocol, "listen_addr", listenAddr, "format", syslogConf.Format)
+			host, port, _ := net.SplitHostPort(listenAddr)
+			portNum, _ := strconv.Atoi(port)
+			infos = append(infos, &model.ListenerInfo{
+				BindAddr: host,
+				Port:     portNum,
+				Protocol: syslogConf.Protocol,
+			})
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				err := server.Serve(l)
+				if err != nil && err != http.ErrServerClosed {
+					s.logger.Warn("HTTP server error", "error", err)
+				}
+			}()
+		}
+	}
+	if len(infos) > 0 {
+		s.status = HttpStarted
+		s.logger.Info("Listening on HTTP", "nb_services", len(infos))
+	} else {
+		s.logger.Debug("HTTP Server not started: no listener")
+		close(s.statusChan)
+	}
+	return infos, nil
+}
+
+// secure wraps the listener in TLS if needed.
+func (s *httpServiceImpl) secure(config *conf.SyslogConfig, l net.Listener) (net.Listener, error) {
+	if config.TLSEnabled {
+		tlsConf, err := s.tlsConfig(config)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l = tls.NewListener(l, tlsConf)
+	}
+	return l, nil
+}
+
+func (s *httpServiceImpl) Stop() {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	if s.status != HttpStarted {
+		return
+	}
+	// let the current requests finish
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	for _, server := range s.servers {
+		err := server.Shutdown(ctx)
+		if err != nil {
+			s.logger.Warn("HTTP requests were interrupted by the shutdown", "error", err)
+			server.Close()
+		}
+	}
+	cancel()
+	s.wg.Wait()
+	s.servers = nil
+
+	s.status = HttpStopped
+	s.statusChan <- HttpStopped
+	close(s.statusChan)
+	s.logger.Debug("HTTP server has stopped")
+}
+
+func (s *httpServiceImpl) WaitClosed() {
+	var more bool
+	for {
+		_, more = <-s.statusChan
+		if !more {
+			return
+		}
+	}
+}
+
+type httpHandler struct {
+	Server   *httpServiceImpl
+	Config   *conf.SyslogConfig
+	inflight chan struct{}
+	// the parsers environments are not safe for concurrent use
+	envs *sync.Pool
+}
+
+func newHttpHandler(s *httpServiceImpl, config *conf.SyslogConfig) *httpHandler {
+	return &httpHandler{
+		Server:   s,
+		Config:   config,
+		inflight: make(chan struct{}, config.HTTPMaxInflight),
+		envs: &sync.Pool{
+			New: func() interface{} { return NewParsersEnv(s.ParserConfigs, s.logger) },
+		},
+	}
+}
+
+// httpResult is the body of the HTTP answers.
+type httpResult struct {
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+}
+
+func httpAnswer(w http.ResponseWriter, status int, result httpResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// authorized checks the bearer token of the request.
+func (h *httpHandler) authorized(r *http.Request) bool {
+	if len(h.Config.HTTPTokens) == 0 {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimSpace(auth[len("Bearer "):]))
+	for _, t := range h.Config.HTTPTokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.Server
+	config := h.Config
+	remote, _, _ := net.SplitHostPort(r.RemoteAddr)
+	logger := s.logger.New("protocol", s.protocol, "client", remote, "format", config.Format)
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		httpAnswer(w, http.StatusMethodNotAllowed, httpResult{Error: "only POST is supported"})
+		return
+	}
+	limits := s.limits(config)
+	if !limits.permitted(net.ParseIP(remote)) {
+		httpAnswer(w, http.StatusForbidden, httpResult{Error: "client not allowed"})
+		return
+	}
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		httpAnswer(w, http.StatusUnauthorized, httpResult{Error: "invalid token"})
+		return
+	}
+	if ok, _ := limits.wait(remote); !ok {
+		w.Header().Set("Retry-After", "1")
+		httpAnswer(w, http.StatusTooManyRequests, httpResult{Error: "rate limit exceeded"})
+		return
+	}
+
+	// when the Store does not keep up, tell the client to come back later
+	// instead of piling up the messages in memory
+	if c, ok := s.stasher.(model.Congested); ok && c.Congested() {
+		w.Header().Set("Retry-After", "1")
+		httpAnswer(w, http.StatusServiceUnavailable, httpResult{Error: "the Store does not keep up"})
+		return
+	}
+	select {
+	case h.inflight <- struct{}{}:
+		defer func() { <-h.inflight }()
+	default:
+		w.Header().Set("Retry-After", "1")
+		httpAnswer(w, http.StatusServiceUnavailable, httpResult{Error: "too many requests in flight"})
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, config.HTTPMaxBodySize)
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			httpAnswer(w, http.StatusBadRequest, httpResult{Error: "invalid gzip body"})
+			return
+		}
+		defer gz.Close()
+		// the uncompressed size is limited too
+		body = io.LimitReader(gz, config.HTTPMaxBodySize+1)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		httpAnswer(w, http.StatusBadRequest, httpResult{Error: fmt.Sprintf("error reading the body: %s", err)})
+		return
+	}
+	if int64(len(data)) > config.HTTPMaxBodySize {
+		httpAnswer(w, http.StatusRequestEntityTooLarge, httpResult{Error: "body too large"})
+		return
+	}
+
+	lines, err := httpLines(data, r.Header.Get("Content-Type"))
+	if err != nil {
+		httpAnswer(w, http.StatusBadRequest, httpResult{Error: err.Error()})
+		return
+	}
+
+	if s.metrics != nil {
+		s.metrics.ClientConnectionCounter.WithLabelValues(s.protocol, remote, strconv.Itoa(config.Port), "").Inc()
+	}
+	result := httpResult{}
+	e := h.envs.Get().(*ParsersEnv)
+	defer h.envs.Put(e)
+	parser := e.GetSyslogParser(config)
+	if parser == nil {
+		logger.Error("Unknown parser")
+		httpAnswer(w, http.StatusInternalServerError, httpResult{Error: "unknown parser"})
+		return
+	}
+	var peer *model.TLSPeer
+	if r.TLS != nil {
+		peer = tlsPeer(*r.TLS)
+	}
+	// the client is answered when the messages are written in the Store
+	confirmer, confirm := s.stasher.(model.ConfirmStasher)
+	stored := make(chan bool, len(lines))
+	for _, line := range lines {
+		if s.metrics != nil {
+			s.metrics.IncomingMsgsCounter.WithLabelValues(s.protocol, remote, strconv.Itoa(config.Port), "").Inc()
+		}
+		p, err := parser.Parse(line, config.DontParseSD)
+		if err != nil {
+			result.Rejected++
+			if s.metrics != nil {
+				s.metrics.ParsingErrorCounter.WithLabelValues(config.Format, remote).Inc()
+			}
+			logger.Info("Parsing error", "message", line, "error", err)
+			continue
+		}
+		p.SetTLSPeer(peer)
+		uid := <-s.generator
+		m := &model.TcpUdpParsedMessage{
+			Parsed: &model.ParsedMessage{
+				Fields:    p,
+				Client:    remote,
+				LocalPort: config.Port,
+				TLSPeer:   peer,
+			},
+			Uid:    uid.String(),
+			ConfId: config.ConfID,
+		}
+		if confirm {
+			confirmer.StashConfirm(m, func(ok bool) { stored <- ok })
+		} else {
+			s.stasher.Stash(m)
+		}
+		result.Accepted++
+	}
+	if result.Accepted == 0 && result.Rejected > 0 {
+		// retrying would not help
+		httpAnswer(w, http.StatusBadRequest, result)
+		return
+	}
+	storeTimeout := httpStoreTimeout
+	if config.Timeout > 0 && config.Timeout/2 < storeTimeout {
+		// the answer must be written before the write timeout
+		storeTimeout = config.Timeout / 2
+	}
+	if confirm && !waitStored(stored, result.Accepted, storeTimeout) {
+		// some messages may have been stored: a retry can duplicate them
+		logger.Warn("The HTTP messages could not be written in the Store")
+		w.Header().Set("Retry-After", "1")
+		httpAnswer(w, http.StatusServiceUnavailable, httpResult{Error: "the messages could not be stored"})
+		return
+	}
+	httpAnswer(w, http.StatusOK, result)
+}
+
+// httpStoreTimeout is how long a request waits for its messages to be
+// written in the Store.
+const httpStoreTimeout = 10 * time.Second
+
+// waitStored waits for the answers of the Store about n messages. It
+// returns false when a message could not be stored, or when the Store did
+// not answer in time.
+func waitStored(stored chan bool, n int, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for i := 0; i < n; i++ {
+		select {
+		case ok := <-stored:
+			if !ok {
+				return false
+			}
+		case <-timer.C:
+			return false
+		}
+	}
+	return true
+}
+
+// httpLines splits a request body into messages. The body may be a JSON
+// array (of strings or of JSON messages), NDJSON, or raw syslog lines.
+func httpLines(data []byte, contentType string) ([]string, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+	if trimmed[0] == '[' {
+		elements := []json.RawMessage{}
+		err := json.Unmarshal(trimmed, &elements)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %s", err)
+		}
+		lines := make([]string, 0, len(elements))
+		for _, elt := range elements {
+			var line string
+			if json.Unmarshal(elt, &line) != nil {
+				// a JSON message
+				line = string(elt)
+			}
+			if len(strings.TrimSpace(line)) > 0 {
+				lines = append(lines, line)
+			}
+		}
+		return lines, nil
+	}
+	if strings.HasPrefix(contentType, "application/json") && trimmed[0] == '{' && json.Valid(trimmed) {
+		// a single JSON message, possibly on several lines
+		return []string{string(trimmed)}, nil
+	}
+	// NDJSON and raw lines: one message per line
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	scanner.Buffer(make([]byte, 0, 4096), len(trimmed)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
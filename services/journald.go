@@ -163,3 +163,6 @@ func (s *JournalService) SetConf(sc []*conf.SyslogConfig, pc []conf.ParserConfig
 func (s *JournalService) SetKafkaConf(kc *conf.KafkaConfig) {}
 
 func (s *JournalService) SetAuditConf(ac *conf.AuditConfig) {}
+
+func (s *JournalService) SetWatcherConf(wc []conf.WatcherConfig, offsets map[string]*model.FileOffset) {
+}
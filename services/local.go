@@ -0,0 +1,37 @@
+package services
+
+import (
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+)
+
+// localParser parses the messages received on the local syslog socket.
+// RFC3164 messages are parsed without expecting a hostname, and the local
+// hostname is filled in when the sender did not provide one.
+type localParser struct {
+	format          string
+	parser          Parser
+	hostname        string
+	location        *time.Location
+	futureTolerance time.Duration
+}
+
+func (p *localParser) Parse(m string, dont_parse_sd bool) (sm *model.SyslogMessage, err error) {
+	format := p.format
+	if format == "auto" {
+		format = model.DetectFormat(m)
+	}
+	if format == "rfc3164" {
+		sm, err = model.ParseRfc3164LocalFormat(m, p.location, p.futureTolerance)
+	} else {
+		sm, err = p.parser.Parse(m, dont_parse_sd)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(sm.Hostname) == 0 {
+		sm.Hostname = p.hostname
+	}
+	return sm, nil
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/model"
+)
+
+type multilineGroup struct {
+	first   *model.TcpUdpParsedMessage
+	lines   []string
+	offset  *model.FileOffset
+	updated time.Time
+}
+
+// multilineStasher groups the consecutive messages of the same client, host,
+// app and process that form a multiline message (a stack trace for example),
+// and stashes them as one message. The lines are joined with "\n".
+//
+// A message continues the current group when it matches multiline_continue,
+// or when it does not match multiline_start. A group is stashed when the
+// next message does not continue it, when it has multiline_max_lines lines,
+// or after multiline_timeout without a new line.
+type multilineStasher struct {
+	mu      sync.Mutex
+	stasher model.Stasher
+	config  *conf.MultilineConfig
+	pending map[string]*multilineGroup
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newMultilineStasher(stasher model.Stasher, config *conf.MultilineConfig) *multilineStasher {
+	s := &multilineStasher{
+		stasher: stasher,
+		config:  config,
+		pending: map[string]*multilineGroup{},
+		stop:    make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.expire()
+	return s
+}
+
+// withMultiline returns stasher when the section has no multiline settings.
+// Otherwise the returned close function must be called when no more
+// messages will be stashed.
+func withMultiline(stasher model.Stasher, config *conf.MultilineConfig) (model.Stasher, func()) {
+	if config == nil {
+		return stasher, func() {}
+	}
+	s := newMultilineStasher(stasher, config)
+	return s, s.close
+}
+
+func (s *multilineStasher) continues(line string) bool {
+	if s.config.Continue != nil && s.config.Continue.MatchString(line) {
+		return true
+	}
+	return s.config.Start != nil && !s.config.Start.MatchString(line)
+}
+
+func multilineKey(m *model.TcpUdpParsedMessage) string {
+	f := m.Parsed.Fields
+	return strings.Join([]string{m.ConfId, m.Parsed.Client, f.Hostname, f.Appname, f.Procid}, "\x00")
+}
+
+func (s *multilineStasher) Stash(m *model.TcpUdpParsedMessage) {
+	key := multilineKey(m)
+	line := m.Parsed.Fields.Message
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.pending[key]
+	if ok && s.continues(line) {
+		g.lines = append(g.lines, line)
+		g.offset = m.Offset
+		g.updated = time.Now()
+	} else {
+		if ok {
+			s.flush(key, g)
+		}
+		g = &multilineGroup{first: m, lines: []string{line}, offset: m.Offset, updated: time.Now()}
+		s.pending[key] = g
+	}
+	if len(g.lines) >= s.config.MaxLines {
+		s.flush(key, g)
+	}
+}
+
+// flush stashes the group as one message. The offset of a tailed file is the
+// offset after the last line.
+func (s *multilineStasher) flush(key string, g *multilineGroup) {
+	delete(s.pending, key)
+	m := g.first
+	if len(g.lines) > 1 {
+		m.Parsed.Fields.Message = strings.Join(g.lines, "\n")
+		m.Offset = g.offset
+	}
+	s.stasher.Stash(m)
+}
+
+// expire stashes the groups that did not get a new line in time.
+func (s *multilineStasher) expire() {
+	defer s.wg.Done()
+	period := s.config.Timeout / 2
+	if period < 10*time.Millisecond {
+		period = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, g := range s.pending {
+				if now.Sub(g.updated) >= s.config.Timeout {
+					s.flush(key, g)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// close stashes the pending groups.
+func (s *multilineStasher) close() {
+	close(s.stop)
+	s.wg.Wait()
+	s.mu.Lock()
+	for key, g := range s.pending {
+		s.flush(key, g)
+	}
+	s.mu.Unlock()
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -35,6 +36,9 @@ type NetworkPlugin struct {
 	parserConfs  []conf.ParserConfig
 	kafkaConf    *conf.KafkaConfig
 	auditConf    *conf.AuditConfig
+	watcherConfs []conf.WatcherConfig
+	offsets      map[string]*model.FileOffset
+	lastOffsets  map[string]*model.FileOffset
 	binderHandle int
 	loggerHandle int
 	metrics      *metrics.Metrics
@@ -46,6 +50,10 @@ type NetworkPlugin struct {
 	ExitError    int32
 }
 
+// maxPendingConfirms is the number of answers to StashConfirm that can
+// wait to be written to the plugin.
+const maxPendingConfirms = 10000
+
 func (s *NetworkPlugin) Stop() {
 	s.mu.Lock()
 	select {
@@ -87,9 +95,30 @@ func (s *NetworkPlugin) SetAuditConf(ac *conf.AuditConfig) {
 	s.auditConf = ac
 }
 
+func (s *NetworkPlugin) SetWatcherConf(wc []conf.WatcherConfig, offsets map[string]*model.FileOffset) {
+	s.watcherConfs = wc
+	s.offsets = offsets
+}
+
+// FileOffsets returns the positions of the last lines that the watcher
+// plugin has sent. They are more recent than the positions in the Store,
+// that records the messages asynchronously.
+func (s *NetworkPlugin) FileOffsets() map[string]*model.FileOffset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offsets := map[string]*model.FileOffset{}
+	for filename, offset := range s.lastOffsets {
+		offsets[filename] = offset
+	}
+	return offsets
+}
+
 func (s *NetworkPlugin) Start(test bool) ([]*model.ListenerInfo, error) {
 	infos := []*model.ListenerInfo{}
 	s.shutdown = make(chan struct{})
+	s.mu.Lock()
+	s.lastOffsets = map[string]*model.FileOffset{}
+	s.mu.Unlock()
 
 	exe, err := sys.Executable()
 	if err != nil {
@@ -142,6 +171,8 @@ func (s *NetworkPlugin) Start(test bool) ([]*model.ListenerInfo, error) {
 	}
 
 	startedChan := make(chan error)
+	confirms := make(chan string, maxPendingConfirms)
+	go s.writeConfirms(confirms, s.shutdown)
 
 	var once sync.Once
 
@@ -177,9 +208,15 @@ func (s *NetworkPlugin) Start(test bool) ([]*model.ListenerInfo, error) {
 		scanner.Split(PluginSplit)
 		for scanner.Scan() {
 			b := scanner.Bytes()
-			if bytes.HasPrefix(b, []byte("syslog ")) {
+			if bytes.HasPrefix(b, []byte("syslog ")) || bytes.HasPrefix(b, []byte("syslogconfirm ")) {
+				// with syslogconfirm, the plugin waits for the Store
+				confirm := bytes.HasPrefix(b, []byte("syslogconfirm "))
+				payload := b[7:]
+				if confirm {
+					payload = b[14:]
+				}
 				m := &model.TcpUdpParsedMessage{}
-				err := json.Unmarshal(b[7:], m)
+				err := json.Unmarshal(payload, m)
 				if !initialized {
 					msg := "Plugin sent a syslog message before being initialized"
 					s.logger.Error(msg)
@@ -187,7 +224,16 @@ func (s *NetworkPlugin) Start(test bool) ([]*model.ListenerInfo, error) {
 					kill = true
 					return
 				} else if err == nil {
-					s.stasher.Stash(m)
+					if confirm {
+						s.stashConfirm(m, confirms)
+					} else {
+						s.stasher.Stash(m)
+					}
+					if m.Offset != nil {
+						s.mu.Lock()
+						s.lastOffsets[m.Offset.Filename] = m.Offset
+						s.mu.Unlock()
+					}
 				} else {
 					s.logger.Warn("Plugin sent a badly encoded JSON log line", "error", err)
 					kill = true
@@ -219,6 +265,9 @@ func (s *NetworkPlugin) Start(test bool) ([]*model.ListenerInfo, error) {
 			} else if bytes.HasPrefix(b, []byte("auditconferror ")) {
 				err := fmt.Errorf(string(b[15:]))
 				once.Do(func() { startedChan <- err; close(startedChan) })
+			} else if bytes.HasPrefix(b, []byte("watcherconferror ")) {
+				err := errors.New(string(b[17:]))
+				once.Do(func() { startedChan <- err; close(startedChan) })
 			} else if bytes.HasPrefix(b, []byte("nolistenererror")) {
 				err := fmt.Errorf("No listener")
 				once.Do(func() { startedChan <- err; close(startedChan) })
@@ -243,6 +292,7 @@ func (s *NetworkPlugin) Start(test bool) ([]*model.ListenerInfo, error) {
 	pcb, _ := json.Marshal(s.parserConfs)
 	kcb, _ := json.Marshal(s.kafkaConf)
 	acb, _ := json.Marshal(s.auditConf)
+	wcb, _ := json.Marshal(watcherConf{Watchers: s.watcherConfs, Offsets: s.offsets})
 
 	s.mu.Lock()
 	s.stdin.Write([]byte("syslogconf "))
@@ -261,6 +311,10 @@ func (s *NetworkPlugin) Start(test bool) ([]*model.ListenerInfo, error) {
 	s.stdin.Write(acb)
 	s.stdin.Write([]byte("\n"))
 
+	s.stdin.Write([]byte("watcherconf "))
+	s.stdin.Write(wcb)
+	s.stdin.Write([]byte("\n"))
+
 	s.stdin.Write([]byte("start\n"))
 	s.mu.Unlock()
 	rerr := <-startedChan
@@ -270,11 +324,98 @@ func (s *NetworkPlugin) Start(test bool) ([]*model.ListenerInfo, error) {
 		s.logger.Debug("Ask the erred plugin to stop", "type", s.t)
 		s.Shutdown()
 		s.WaitPluginShutdown()
+	} else {
+		go s.watchCongestion(s.shutdown)
 	}
 
 	return infos, rerr
 }
 
+// watchCongestion tells the plugin when the Store does not keep up, so that
+// the services that answer their clients (HTTP) can push back.
+func (s *NetworkPlugin) watchCongestion(shutdown chan struct{}) {
+	c, ok := s.stasher.(model.Congested)
+	if !ok {
+		return
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	congested := false
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+			if c.Congested() == congested {
+				continue
+			}
+			congested = !congested
+			s.mu.Lock()
+			select {
+			case <-shutdown:
+			default:
+				if congested {
+					s.logger.Warn("The Store does not keep up", "type", s.t)
+					s.stdin.Write([]byte("congested\n"))
+				} else {
+					s.logger.Info("The Store keeps up again", "type", s.t)
+					s.stdin.Write([]byte("flowing\n"))
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// stashConfirm stashes a message for which the plugin waits for an answer:
+// "stashed <uid>" when the Store has written it, "notstashed <uid>"
+// otherwise. The answers are queued, so that the Store is never blocked by
+// the plugin. When the queue is full, the answer is dropped, and the plugin
+// times out.
+func (s *NetworkPlugin) stashConfirm(m *model.TcpUdpParsedMessage, confirms chan string) {
+	answer := func(stored bool) {
+		a := "stashed " + m.Uid + "\n"
+		if !stored {
+			a = "notstashed " + m.Uid + "\n"
+		}
+		select {
+		case confirms <- a:
+		default:
+			s.logger.Warn("Too many Store answers waiting for the plugin", "type", s.t)
+		}
+	}
+	if c, ok := s.stasher.(model.ConfirmStasher); ok {
+		c.StashConfirm(m, answer)
+	} else {
+		s.stasher.Stash(m)
+		answer(true)
+	}
+}
+
+// writeConfirms writes the answers to StashConfirm to the plugin.
+func (s *NetworkPlugin) writeConfirms(confirms chan string, shutdown chan struct{}) {
+	for {
+		select {
+		case <-shutdown:
+			return
+		case answer := <-confirms:
+			s.mu.Lock()
+			select {
+			case <-shutdown:
+			default:
+				s.stdin.Write([]byte(answer))
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// watcherConf is sent to the plugins with the positions of the tailed files
+type watcherConf struct {
+	Watchers []conf.WatcherConfig         `json:"watchers"`
+	Offsets  map[string]*model.FileOffset `json:"offsets"`
+}
+
 // NetworkPluginProvider implements the TCP service in a separated process
 type NetworkPluginProvider struct {
 	svc         NetworkService
@@ -283,6 +424,15 @@ type NetworkPluginProvider struct {
 	parserConfs []conf.ParserConfig
 	kafkaConf   *conf.KafkaConfig
 	auditConf   *conf.AuditConfig
+	watcherConf watcherConf
+	congested   int32
+	pending     map[string]func(bool)
+	pendingMu   sync.Mutex
+}
+
+// Congested reports that the parent said that the Store does not keep up.
+func (p *NetworkPluginProvider) Congested() bool {
+	return atomic.LoadInt32(&p.congested) == 1
 }
 
 func (p *NetworkPluginProvider) Stash(m *model.TcpUdpParsedMessage) {
@@ -296,17 +446,67 @@ func (p *NetworkPluginProvider) Stash(m *model.TcpUdpParsedMessage) {
 	}
 }
 
+// StashConfirm sends m to the parent, and calls confirm when the parent
+// answers that the Store has written m, or could not.
+func (p *NetworkPluginProvider) StashConfirm(m *model.TcpUdpParsedMessage, confirm func(stored bool)) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		// should not happen
+		p.logger.Warn("In plugin, a syslog message could not be serialized to JSON ?!")
+		confirm(false)
+		return
+	}
+	p.pendingMu.Lock()
+	if p.pending == nil {
+		p.pending = map[string]func(bool){}
+	}
+	p.pending[m.Uid] = confirm
+	p.pendingMu.Unlock()
+	s := fmt.Sprintf("syslogconfirm %s", string(b))
+	fmt.Fprintf(os.Stdout, "%010d %s\n", len(s), s)
+}
+
+// confirmed is called when the parent answers to StashConfirm.
+func (p *NetworkPluginProvider) confirmed(uid string, stored bool) {
+	p.pendingMu.Lock()
+	confirm, ok := p.pending[uid]
+	delete(p.pending, uid)
+	p.pendingMu.Unlock()
+	if ok {
+		confirm(stored)
+	}
+}
+
 func (p *NetworkPluginProvider) Launch(typ string, test bool, binderClient *sys.BinderClient, logger log15.Logger) error {
 	generator := utils.Generator(context.Background(), logger)
 	p.logger = logger
 
-	var scanner *bufio.Scanner
 	var command string
 	var args string
 	var cancel context.CancelFunc
-	scanner = bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		parts := strings.SplitN(strings.Trim(scanner.Text(), "\r\n "), " ", 2)
+
+	// the answers of the Store are handled apart from the commands: "stop"
+	// waits for the HTTP requests, that wait for the answers
+	var scanErr error
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.Trim(scanner.Text(), "\r\n ")
+			if strings.HasPrefix(line, "stashed ") {
+				p.confirmed(line[8:], true)
+			} else if strings.HasPrefix(line, "notstashed ") {
+				p.confirmed(line[11:], false)
+			} else {
+				lines <- line
+			}
+		}
+		scanErr = scanner.Err()
+		close(lines)
+	}()
+
+	for line := range lines {
+		parts := strings.SplitN(line, " ", 2)
 		command = parts[0]
 		switch command {
 		case "start":
@@ -323,13 +523,14 @@ func (p *NetworkPluginProvider) Launch(typ string, test bool, binderClient *sys.
 					p.svc.SetConf(p.syslogConfs, p.parserConfs)
 					p.svc.SetKafkaConf(p.kafkaConf)
 					p.svc.SetAuditConf(p.auditConf)
+					p.svc.SetWatcherConf(p.watcherConf.Watchers, p.watcherConf.Offsets)
 					infos, err := p.svc.Start(test)
 					if err != nil {
 						errs := fmt.Sprintf("starterror %s", err.Error())
 						fmt.Fprintf(os.Stdout, "%010d %s\n", len(errs), errs)
 						p.svc = nil
-					} else if len(infos) == 0 && typ != "skewer-relp" && typ != "skewer-journal" && typ != "skewer-audit" {
-						// (RELP, Journal and audit never report infos)
+					} else if len(infos) == 0 && typ != "skewer-relp" && typ != "skewer-journal" && typ != "skewer-audit" && typ != "skewer-watcher" {
+						// (RELP, Journal, audit and watcher never report infos)
 						p.svc.Stop()
 						p.svc = nil
 						errs := "nolistenererror"
@@ -356,6 +557,10 @@ func (p *NetworkPluginProvider) Launch(typ string, test bool, binderClient *sys.
 				time.Sleep(400 * time.Millisecond) // give a chance for cleaning to be executed before plugin process ends
 			}
 			return nil
+		case "congested":
+			atomic.StoreInt32(&p.congested, 1)
+		case "flowing":
+			atomic.StoreInt32(&p.congested, 0)
 		case "syslogconf":
 			args = parts[1]
 			sc := []*conf.SyslogConfig{}
@@ -400,12 +605,22 @@ func (p *NetworkPluginProvider) Launch(typ string, test bool, binderClient *sys.
 				errs := fmt.Sprintf("auditconferror %s", err.Error())
 				fmt.Fprintf(os.Stdout, "%010d %s\n", len(errs), errs)
 			}
+		case "watcherconf":
+			args = parts[1]
+			wc := watcherConf{}
+			err := json.Unmarshal([]byte(args), &wc)
+			if err == nil {
+				p.watcherConf = wc
+			} else {
+				errs := fmt.Sprintf("watcherconferror %s", err.Error())
+				fmt.Fprintf(os.Stdout, "%010d %s\n", len(errs), errs)
+			}
 		default:
 			return fmt.Errorf("Unknown command")
 		}
 
 	}
-	e := scanner.Err()
+	e := scanErr
 	if e != nil {
 		logger.Error("In plugin, scanning stdin met error", "error", e)
 		return e
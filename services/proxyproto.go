@@ -0,0 +1,160 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyHeaderTimeout = 5 * time.Second
+	// a PROXY v1 header is at most 107 bytes long
+	proxyV1MaxLength = 107
+)
+
+// proxyConn is a connection received from a load balancer that speaks the
+// PROXY protocol. RemoteAddr and LocalAddr return the addresses of the
+// original connection.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader reads the PROXY protocol header (v1 or v2) that starts
+// conn. It must be called before the TLS handshake and before framing.
+// Connections from the load balancer itself (v1 UNKNOWN, v2 LOCAL) keep
+// their addresses.
+func readProxyHeader(conn net.Conn, timeout time.Duration) (*proxyConn, error) {
+	err := conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+	pconn := &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}
+	start, err := pconn.reader.Peek(5)
+	if err != nil {
+		return nil, err
+	}
+	if string(start) == "PROXY" {
+		err = pconn.readV1()
+	} else if start[0] == proxyV2Signature[0] {
+		err = pconn.readV2()
+	} else {
+		err = fmt.Errorf("No PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pconn, conn.SetReadDeadline(time.Time{})
+}
+
+func (c *proxyConn) readV1() error {
+	var line []byte
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return fmt.Errorf("PROXY v1 header is too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return fmt.Errorf("PROXY v1 header does not end with CRLF")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("Invalid PROXY v1 header")
+	}
+	srcIP := net.ParseIP(fields[2])
+	dstIP := net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return fmt.Errorf("Invalid PROXY v1 addresses")
+	}
+	c.remote = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	c.local = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return nil
+}
+
+func (c *proxyConn) readV2() error {
+	header := make([]byte, 16)
+	_, err := io.ReadFull(c.reader, header)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:12], proxyV2Signature) {
+		return fmt.Errorf("Invalid PROXY v2 signature")
+	}
+	if header[12]>>4 != 2 {
+		return fmt.Errorf("Unsupported PROXY protocol version")
+	}
+	command := header[12] & 0x0f
+	family := header[13] >> 4
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return err
+	}
+	switch command {
+	case 0:
+		// LOCAL: health checks from the load balancer
+		return nil
+	case 1:
+	default:
+		return fmt.Errorf("Unsupported PROXY v2 command")
+	}
+	// the TLVs after the addresses are ignored
+	switch family {
+	case 1:
+		if len(payload) < 12 {
+			return fmt.Errorf("PROXY v2 header is too short")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		c.local = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case 2:
+		if len(payload) < 36 {
+			return fmt.Errorf("PROXY v2 header is too short")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		c.local = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	default:
+		// AF_UNSPEC or AF_UNIX: the original addresses are not usable
+	}
+	return nil
+}
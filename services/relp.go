@@ -119,13 +119,15 @@ func (s *RelpService) SetKafkaConf(kc *conf.KafkaConfig) {
 
 func (s *RelpService) SetAuditConf(ac *conf.AuditConfig) {}
 
+func (s *RelpService) SetWatcherConf(wc []conf.WatcherConfig, offsets map[string]*model.FileOffset) {}
+
 type RelpServiceImpl struct {
 	StreamingService
 	kafkaConf   conf.KafkaConfig
 	status      RelpServerStatus
 	StatusChan  chan RelpServerStatus
 	kafkaClient sarama.Client
-	metrics     *metrics.Metrics
+	producers   *RelpProducerPool
 	test        bool
 }
 
@@ -134,7 +136,8 @@ func (s *RelpServiceImpl) init() {
 }
 
 func NewRelpServiceImpl(b *sys.BinderClient, metrics *metrics.Metrics, logger log15.Logger) *RelpServiceImpl {
-	s := RelpServiceImpl{status: Stopped, metrics: metrics}
+	s := RelpServiceImpl{status: Stopped}
+	s.metrics = metrics
 	s.logger = logger.New("class", "RelpServer")
 	s.binder = b
 	s.init()
@@ -166,6 +169,7 @@ func (s *RelpServiceImpl) Start(test bool) ([]*model.ListenerInfo, error) {
 		s.logger.Info("Listening on RELP", "nb_services", len(infos))
 	}
 	s.test = test
+	s.drainTimeout = s.kafkaConf.DrainTimeout
 	if !s.test {
 		var err error
 		s.kafkaClient, err = s.kafkaConf.GetClient()
@@ -174,6 +178,22 @@ func (s *RelpServiceImpl) Start(test bool) ([]*model.ListenerInfo, error) {
 			s.resetTCPListeners()
 			return nil, err
 		}
+		s.producers, err = NewRelpProducerPool(s.kafkaClient, s.kafkaConf.RelpProducers, s.logger)
+		if err != nil {
+			s.kafkaClient.Close()
+			s.kafkaClient = nil
+			s.resetTCPListeners()
+			return nil, err
+		}
+		// stop the service when Kafka returns a fatal error
+		go func(pool *RelpProducerPool) {
+			select {
+			case <-pool.Fatal():
+				s.logger.Warn("Fatal Kafka error: stopping the RELP service")
+				s.StopAndWait()
+			case <-pool.Closed():
+			}
+		}(s.producers)
 	}
 
 	s.status = Started
@@ -227,10 +247,38 @@ func (s *RelpServiceImpl) doStop(final bool, wait bool, mu *sync.Mutex) {
 		return
 	}
 
+	// stop accepting new connections
 	s.resetTCPListeners()
-	// wait that all goroutines have ended
-	s.wg.Wait()
+	if s.drainTimeout > 0 {
+		// stop reading from the clients: the pending transactions are
+		// answered, then the clients receive 'serverclose'
+		s.drainSessions()
+	} else {
+		// ask the clients to close their sessions
+		s.serverCloseSessions()
+	}
+	// wait that all goroutines have ended. When the drain timeout expires,
+	// Listen closes the connections: then stop waiting for the pending Kafka
+	// responses too.
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(s.drainTimeout):
+		if s.producers != nil {
+			s.logger.Warn("Drain timeout expired: the pending Kafka responses are abandoned")
+			s.producers.Abandon()
+		}
+		<-done
+	}
 
+	if s.producers != nil {
+		s.producers.Close()
+		s.producers = nil
+	}
 	if s.kafkaClient != nil {
 		s.kafkaClient.Close()
 		s.kafkaClient = nil
@@ -253,21 +301,130 @@ type RelpHandler struct {
 	Server *RelpServiceImpl
 }
 
-func (h RelpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig) {
+// relpConn serializes the writes on a RELP connection, so that the server can
+// send a 'serverclose' hint while the answers are being written.
+type relpConn struct {
+	net.Conn
+	writeMu  *sync.Mutex
+	closing  bool
+	stateMu  *sync.Mutex
+	draining bool
+}
+
+func newRelpConn(conn net.Conn) *relpConn {
+	return &relpConn{Conn: conn, writeMu: &sync.Mutex{}, stateMu: &sync.Mutex{}}
+}
+
+// extendDeadline pushes the read deadline, unless the connection is being
+// drained.
+func (c *relpConn) extendDeadline(timeout time.Duration) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if !c.draining && timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+}
+
+// drain interrupts the reads on the connection.
+func (c *relpConn) drain() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.draining = true
+	c.Conn.SetReadDeadline(time.Now())
+}
+
+// Close sends 'serverclose' to a drained client before closing the
+// connection, so that the client sends its unanswered messages again
+// elsewhere.
+func (c *relpConn) Close() error {
+	if c.isDraining() {
+		c.serverClose()
+	}
+	return c.Conn.Close()
+}
+
+func (c *relpConn) isDraining() bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.draining
+}
+
+func (c *relpConn) answer(answer string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.Conn.Write([]byte(answer))
+	return err
+}
+
+// serverClose tells the client that the server is going to close the session.
+func (c *relpConn) serverClose() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closing {
+		return nil
+	}
+	c.closing = true
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, err := c.Conn.Write([]byte("0 serverclose 0\n"))
+	return err
+}
+
+// serverCloseSessions sends 'serverclose' to all the RELP clients.
+func (s *RelpServiceImpl) serverCloseSessions() {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	for conn := range s.connections {
+		if rconn, ok := conn.(*relpConn); ok {
+			err := rconn.serverClose()
+			if err != nil {
+				s.logger.Debug("Error sending serverclose", "error", err)
+			}
+		}
+	}
+}
+
+// drainSessions stops reading from the RELP clients. Each connection ends
+// after its pending transactions have been answered.
+func (s *RelpServiceImpl) drainSessions() {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	for conn := range s.connections {
+		if rconn, ok := conn.(*relpConn); ok {
+			rconn.drain()
+		}
+	}
+}
+
+// relpAnswer is an answer for a non-syslog command. It is sent in txnr order
+// with the syslog answers.
+type relpAnswer struct {
+	txnr   int
+	answer string
+}
+
+func (h RelpHandler) HandleConnection(c net.Conn, config *conf.SyslogConfig) {
 	// http://www.rsyslog.com/doc/relp.html
 
 	var local_port int
-	var err error
 
 	s := h.Server
-	s.AddConnection(conn)
+	conn := newRelpConn(c)
 
 	raw_messages_chan := make(chan *model.RelpRawMessage)
 	parsed_messages_chan := make(chan *model.RelpParsedMessage)
 	other_successes_chan := make(chan int)
 	other_fails_chan := make(chan int)
+	answers_chan := make(chan *relpAnswer)
+	answersDone := make(chan struct{})
 
-	relpIsOpen := false
+	// window bounds the number of transactions that have not been answered yet
+	windowSize := config.WindowSize
+	if windowSize <= 0 {
+		windowSize = 128
+	}
+	window := make(chan struct{}, windowSize)
+
+	var session *RelpSession
 
 	client := ""
 	path := ""
@@ -278,20 +435,38 @@ func (h RelpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig)
 		local_port = 0
 		path = conn.LocalAddr().String()
 	} else {
-		client = strings.Split(remote.String(), ":")[0]
-		local := conn.LocalAddr()
-		if local != nil {
-			s := strings.Split(local.String(), ":")
-			local_port, _ = strconv.Atoi(s[len(s)-1])
-		}
+		client = remoteHost(remote)
+		local_port, _ = addrPort(conn.LocalAddr())
 	}
 	client = strings.TrimSpace(client)
 	path = strings.TrimSpace(path)
 	local_port_s := strconv.FormatInt(int64(local_port), 10)
 
+	if !s.AddConnection(conn, config, client) {
+		conn.Close()
+		s.wg.Done()
+		return
+	}
+
 	logger := s.logger.New("protocol", s.protocol, "client", client, "local_port", local_port, "unix_socket_path", path, "format", config.Format)
 	logger.Info("New client connection")
-	s.metrics.ClientConnectionCounter.WithLabelValues(s.protocol, client, local_port_s, path).Inc()
+	if s.metrics != nil {
+		s.metrics.ClientConnectionCounter.WithLabelValues(s.protocol, client, local_port_s, path).Inc()
+	}
+
+	// on unix sockets, the kernel tells us who is on the other side
+	creds := streamCredentials(c)
+	if creds != nil && config.UnixProcInfo {
+		creds.Comm, creds.Exe, creds.Cgroup = sys.ProcessInfo(creds.Pid)
+	}
+	// in TLS, the client may have presented a certificate
+	peer, err := handshake(c, config.Timeout)
+	if err != nil {
+		logger.Info("TLS handshake failed", "error", err)
+		s.RemoveConnection(conn)
+		s.wg.Done()
+		return
+	}
 
 	// pull messages from raw_messages_chan and push them to parsed_messages_chan
 	s.wg.Add(1)
@@ -300,27 +475,40 @@ func (h RelpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig)
 		e := NewParsersEnv(s.ParserConfigs, s.logger)
 		for m := range raw_messages_chan {
 
-			parser := e.GetParser(config.Format)
+			parser := e.GetSyslogParser(config)
 			if parser == nil {
 				logger.Error("Unknown parser")
+				other_fails_chan <- m.Txnr
 				continue
 			}
 			p, err := parser.Parse(m.Raw.Message, config.DontParseSD)
-			if err == nil {
-				parsed_msg := model.RelpParsedMessage{
-					Parsed: &model.ParsedMessage{
-						Fields:         p,
-						Client:         m.Raw.Client,
-						LocalPort:      m.Raw.LocalPort,
-						UnixSocketPath: m.Raw.UnixSocketPath,
-					},
-					Txnr: m.Txnr,
+			if err != nil {
+				if s.metrics != nil {
+					s.metrics.ParsingErrorCounter.WithLabelValues(config.Format, client).Inc()
 				}
-				parsed_messages_chan <- &parsed_msg
-			} else {
-				s.metrics.ParsingErrorCounter.WithLabelValues(config.Format, client).Inc()
-				logger.Warn("Parsing error", "message", m.Raw.Message, "error", err)
+				logger.Warn("Parsing error", "message", m.Raw.Message, "error", err, "txnr", m.Txnr, "policy", config.OnParseError)
+				p = onParseError(config, m.Raw.Message, err)
+				if p == nil {
+					// the message is dropped on purpose: the client must
+					// not send it again
+					other_successes_chan <- m.Txnr
+					continue
+				}
+			}
+			p.SetPeerCredentials(m.Raw.Creds)
+			p.SetTLSPeer(m.Raw.TLSPeer)
+			parsed_msg := model.RelpParsedMessage{
+				Parsed: &model.ParsedMessage{
+					Fields:         p,
+					Client:         m.Raw.Client,
+					LocalPort:      m.Raw.LocalPort,
+					UnixSocketPath: m.Raw.UnixSocketPath,
+					Creds:          m.Raw.Creds,
+					TLSPeer:        m.Raw.TLSPeer,
+				},
+				Txnr: m.Txnr,
 			}
+			parsed_messages_chan <- &parsed_msg
 		}
 		close(parsed_messages_chan)
 	}()
@@ -328,130 +516,136 @@ func (h RelpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig)
 	defer func() {
 		// closing raw_messages_chan causes parsed_messages_chan to be closed too, because of the goroutine just above
 		close(raw_messages_chan)
+		close(answers_chan)
+		// let the pending answers be written before closing the connection
+		<-answersDone
+		if conn.isDraining() {
+			conn.serverClose()
+		}
 		s.RemoveConnection(conn)
 		s.wg.Done()
 	}()
 
-	var producer sarama.AsyncProducer
+	var producer *RelpProducerHandle
+	var ackChan <-chan *relpAck
+	var poolClosed <-chan struct{}
+	var abandoned <-chan struct{}
 
-	if s.test {
-		s.wg.Add(1)
-		go func() {
-			defer s.wg.Done()
+	if !s.test {
+		// the connection shares the Kafka producers of the service
+		producer = s.producers.Register(windowSize)
+		ackChan = producer.Acks()
+		poolClosed = producer.PoolClosed()
+		abandoned = producer.Abandoned()
+	}
 
-			for {
-				if other_successes_chan == nil && other_fails_chan == nil {
-					return
-				}
-				select {
-				case other_txnr, more := <-other_successes_chan:
-					if more {
-						answer := fmt.Sprintf("%d rsp 6 200 OK\n", other_txnr)
-						conn.Write([]byte(answer))
-						s.metrics.RelpAnswersCounter.WithLabelValues("200", client).Inc()
-					} else {
-						other_successes_chan = nil
-					}
-				case other_txnr, more := <-other_fails_chan:
-					if more {
-						answer := fmt.Sprintf("%d rsp 6 500 KO\n", other_txnr)
-						conn.Write([]byte(answer))
-						s.metrics.RelpAnswersCounter.WithLabelValues("500", client).Inc()
-					} else {
-						other_fails_chan = nil
-					}
-				}
+	// answer to the client
+	// this goroutine ends when the reader and the push goroutine have ended
+	// and every message sent to Kafka has been answered
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			if producer != nil {
+				producer.Close()
 			}
-
+			if s.metrics != nil {
+				s.metrics.RelpInflightGauge.WithLabelValues(client).Sub(float64(len(window)))
+			}
+			close(answersDone)
+			s.wg.Done()
 		}()
-	} else {
-		producer, err = s.kafkaConf.GetAsyncProducer()
-		if err != nil {
-			s.metrics.KafkaConnectionErrorCounter.Inc()
-			logger.Warn("Can't get a kafka producer. Aborting handleConn.")
-			return
+
+		successes := map[int]bool{}
+		failures := map[int]bool{}
+		answers := map[int]string{}
+		releaseWindow := func() {
+			<-window
+			if s.metrics != nil {
+				s.metrics.RelpInflightGauge.WithLabelValues(client).Dec()
+			}
 		}
-		// AsyncClose will eventually terminate the goroutine just below
-		defer producer.AsyncClose()
-
-		// listen for the ACKs coming from Kafka
-		// this goroutine ends after producer.AsyncClose() is called
-		s.wg.Add(1)
-		go func() {
-			defer s.wg.Done()
-
-			fatal := false
-			successes := map[int]bool{}
-			failures := map[int]bool{}
-			successChan := producer.Successes()
-			failureChan := producer.Errors()
-			last_committed_txnr := 0
+		last_committed_txnr := 0
+		giveUp := false
 
-			for {
-				if successChan == nil && failureChan == nil && other_successes_chan == nil && other_fails_chan == nil {
+		for {
+			if other_successes_chan == nil && other_fails_chan == nil && answers_chan == nil {
+				if producer == nil || producer.Inflight() == 0 || giveUp {
 					return
 				}
-				select {
-				case succ, more := <-successChan:
-					if more {
-						// forward the ACK to rsyslog
-						txnr := succ.Metadata.(int)
-						successes[txnr] = true
-						s.metrics.KafkaAckNackCounter.WithLabelValues("ack", succ.Topic).Inc()
-					} else {
-						successChan = nil
-					}
-				case fail, more := <-failureChan:
-					if more {
-						txnr := fail.Msg.Metadata.(int)
-						failures[txnr] = true
-						logger.Info("NACK from Kafka", "error", fail.Error(), "txnr", txnr, "topic", fail.Msg.Topic)
-						fatal = model.IsFatalKafkaError(fail.Err)
-						s.metrics.KafkaAckNackCounter.WithLabelValues("nack", fail.Msg.Topic).Inc()
-					} else {
-						failureChan = nil
-					}
-				case other_txnr, more := <-other_successes_chan:
-					if more {
-						successes[other_txnr] = true
-					} else {
-						other_successes_chan = nil
+			}
+			select {
+			case <-abandoned:
+				// the drain timeout has expired: the messages that Kafka
+				// has not acknowledged yet are not answered
+				giveUp = true
+				abandoned = nil
+			case ack := <-ackChan:
+				producer.Done()
+				if ack.err == nil {
+					successes[ack.txnr] = true
+					if s.metrics != nil {
+						s.metrics.KafkaAckNackCounter.WithLabelValues("ack", ack.topic).Inc()
 					}
-				case other_txnr, more := <-other_fails_chan:
-					if more {
-						failures[other_txnr] = true
-					} else {
-						other_fails_chan = nil
+				} else {
+					failures[ack.txnr] = true
+					logger.Info("NACK from Kafka", "error", ack.err, "txnr", ack.txnr, "topic", ack.topic)
+					if s.metrics != nil {
+						s.metrics.KafkaAckNackCounter.WithLabelValues("nack", ack.topic).Inc()
 					}
 				}
+			case <-poolClosed:
+				return
+			case other_txnr, more := <-other_successes_chan:
+				if more {
+					successes[other_txnr] = true
+				} else {
+					other_successes_chan = nil
+				}
+			case other_txnr, more := <-other_fails_chan:
+				if more {
+					failures[other_txnr] = true
+				} else {
+					other_fails_chan = nil
+				}
+			case answer, more := <-answers_chan:
+				if more {
+					answers[answer.txnr] = answer.answer
+				} else {
+					answers_chan = nil
+				}
+			}
 
-				// rsyslog expects the ACK/txnr correctly and monotonously ordered
-				// so we need a bit of cooking to ensure that
-				for {
-					if _, ok := successes[last_committed_txnr+1]; ok {
-						last_committed_txnr++
-						delete(successes, last_committed_txnr)
-						answer := fmt.Sprintf("%d rsp 6 200 OK\n", last_committed_txnr)
-						conn.Write([]byte(answer))
+			// rsyslog expects the ACK/txnr correctly and monotonously ordered
+			// so we need a bit of cooking to ensure that
+			for {
+				next := nextTxnr(last_committed_txnr)
+				if _, ok := successes[next]; ok {
+					last_committed_txnr = next
+					releaseWindow()
+					delete(successes, last_committed_txnr)
+					conn.answer(relpResponse(last_committed_txnr, 200, "OK", ""))
+					if s.metrics != nil {
 						s.metrics.RelpAnswersCounter.WithLabelValues("200", client).Inc()
-					} else if _, ok := failures[last_committed_txnr+1]; ok {
-						last_committed_txnr++
-						delete(failures, last_committed_txnr)
-						answer := fmt.Sprintf("%d rsp 6 500 KO\n", last_committed_txnr)
-						conn.Write([]byte(answer))
+					}
+				} else if _, ok := failures[next]; ok {
+					last_committed_txnr = next
+					releaseWindow()
+					delete(failures, last_committed_txnr)
+					conn.answer(relpResponse(last_committed_txnr, 500, "KO", ""))
+					if s.metrics != nil {
 						s.metrics.RelpAnswersCounter.WithLabelValues("500", client).Inc()
-					} else {
-						break
 					}
-				}
-
-				if fatal {
-					s.StopAndWait()
-					return
+				} else if answer, ok := answers[next]; ok {
+					last_committed_txnr = next
+					releaseWindow()
+					delete(answers, last_committed_txnr)
+					conn.answer(answer)
+				} else {
+					break
 				}
 			}
-		}()
-	}
+		}
+	}()
 
 	// push parsed messages to Kafka
 	s.wg.Add(1)
@@ -465,50 +659,79 @@ func (h RelpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig)
 
 	ForParsedChan:
 		for m := range parsed_messages_chan {
-			topic, errs := e.Topic(m.Parsed.Fields)
-			for _, err := range errs {
-				logger.Info("Error calculating topic", "error", err, "txnr", m.Txnr)
-			}
-			partitionKey, errs := e.PartitionKey(m.Parsed.Fields)
-			for _, err := range errs {
-				logger.Info("Error calculating the partition key", "error", err, "txnr", m.Txnr)
-			}
+			var topic, partitionKey string
+			var errs []error
+			tmsg := m.Parsed.Fields
+			if config.Deadletter(m.Parsed.Fields) {
+				// the messages that could not be parsed skip the filter and
+				// the topic functions
+				topic = config.DeadletterTopic
+				partitionKey = m.Parsed.Client
+			} else {
+				topic, errs = e.Topic(m.Parsed.Fields)
+				for _, err := range errs {
+					logger.Info("Error calculating topic", "error", err, "txnr", m.Txnr)
+				}
+				partitionKey, errs = e.PartitionKey(m.Parsed.Fields)
+				for _, err := range errs {
+					logger.Info("Error calculating the partition key", "error", err, "txnr", m.Txnr)
+				}
 
-			if len(topic) == 0 || len(partitionKey) == 0 {
-				logger.Warn("Topic or PartitionKey could not be calculated", "txnr", m.Txnr)
-				other_fails_chan <- m.Txnr
-				continue ForParsedChan
-			}
+				if len(topic) == 0 || len(partitionKey) == 0 {
+					logger.Warn("Topic or PartitionKey could not be calculated", "txnr", m.Txnr)
+					other_fails_chan <- m.Txnr
+					continue ForParsedChan
+				}
+				if !config.TopicAllowed(m.Parsed.TLSPeer.Identities(), topic) {
+					logger.Warn("The client certificate is not allowed to write to the topic", "topic", topic, "txnr", m.Txnr)
+					if s.metrics != nil {
+						s.metrics.MessageFilteringCounter.WithLabelValues("unauthorized", client).Inc()
+					}
+					other_fails_chan <- m.Txnr
+					continue ForParsedChan
+				}
 
-			tmsg, filterResult, err := e.FilterMessage(m.Parsed.Fields)
+				filtered, filterResult, err := e.FilterMessage(m.Parsed.Fields)
 
-			switch filterResult {
-			case javascript.DROPPED:
-				other_successes_chan <- m.Txnr
-				s.metrics.MessageFilteringCounter.WithLabelValues("dropped", client).Inc()
-				continue ForParsedChan
-			case javascript.REJECTED:
-				other_fails_chan <- m.Txnr
-				s.metrics.MessageFilteringCounter.WithLabelValues("rejected", client).Inc()
-				continue ForParsedChan
-			case javascript.PASS:
-				s.metrics.MessageFilteringCounter.WithLabelValues("passing", client).Inc()
-				if tmsg == nil {
+				switch filterResult {
+				case javascript.DROPPED:
 					other_successes_chan <- m.Txnr
+					if s.metrics != nil {
+						s.metrics.MessageFilteringCounter.WithLabelValues("dropped", client).Inc()
+					}
+					continue ForParsedChan
+				case javascript.REJECTED:
+					other_fails_chan <- m.Txnr
+					if s.metrics != nil {
+						s.metrics.MessageFilteringCounter.WithLabelValues("rejected", client).Inc()
+					}
+					continue ForParsedChan
+				case javascript.PASS:
+					if s.metrics != nil {
+						s.metrics.MessageFilteringCounter.WithLabelValues("passing", client).Inc()
+					}
+					if filtered == nil {
+						other_successes_chan <- m.Txnr
+						continue ForParsedChan
+					}
+				default:
+					other_fails_chan <- m.Txnr
+					content, _ := json.Marshal(m.Parsed.Fields)
+					logger.Warn("Error happened processing message", "txnr", m.Txnr, "message", content, "error", err)
+					if s.metrics != nil {
+						s.metrics.MessageFilteringCounter.WithLabelValues("unknown", client).Inc()
+					}
 					continue ForParsedChan
 				}
-			default:
-				other_fails_chan <- m.Txnr
-				content, _ := json.Marshal(m.Parsed.Fields)
-				logger.Warn("Error happened processing message", "txnr", m.Txnr, "message", content, "error", err)
-				s.metrics.MessageFilteringCounter.WithLabelValues("unknown", client).Inc()
-				continue ForParsedChan
+				tmsg = filtered
 			}
 
 			nmsg := model.ParsedMessage{
 				Fields:    tmsg,
 				Client:    m.Parsed.Client,
 				LocalPort: m.Parsed.LocalPort,
+				Creds:     m.Parsed.Creds,
+				TLSPeer:   m.Parsed.TLSPeer,
 			}
 
 			kafkaMsg, err := nmsg.ToKafkaMessage(partitionKey, topic)
@@ -517,8 +740,6 @@ func (h RelpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig)
 				other_fails_chan <- m.Txnr
 				continue ForParsedChan
 			}
-			kafkaMsg.Metadata = m.Txnr
-
 			if s.test {
 				v, _ := kafkaMsg.Value.Encode()
 				pkey, _ := kafkaMsg.Key.Encode()
@@ -526,26 +747,84 @@ func (h RelpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig)
 				fmt.Fprintln(os.Stderr, string(v))
 				fmt.Fprintln(os.Stderr)
 				other_successes_chan <- m.Txnr
-			} else {
-				producer.Input() <- kafkaMsg
+			} else if !producer.Send(kafkaMsg, m.Txnr) {
+				other_fails_chan <- m.Txnr
 			}
 		}
 	}()
 
+	// protocolError answers a faulty command with a 500 error
+	protocolError := func(txnr int, message string) {
+		if s.metrics != nil {
+			s.metrics.RelpProtocolErrorsCounter.WithLabelValues(client).Inc()
+		}
+		answers_chan <- &relpAnswer{txnr: txnr, answer: relpResponse(txnr, 500, message, "")}
+	}
+
 	timeout := config.Timeout
-	if timeout > 0 {
-		conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.extendDeadline(timeout)
+	limits := s.limits(config)
+
+	// past max_connection_age, the session is drained and the client is
+	// asked to reconnect with serverclose
+	if age := connectionAge(config); age > 0 {
+		t := time.AfterFunc(age, func() {
+			logger.Info("Maximum connection age reached: closing the session")
+			conn.drain()
+		})
+		defer t.Stop()
 	}
 	scanner := bufio.NewScanner(conn)
 	scanner.Split(RelpSplit)
+	expectedTxnr := 1
 	for {
 		if scanner.Scan() {
-			if timeout > 0 {
-				conn.SetReadDeadline(time.Now().Add(timeout))
+			// each transaction takes a slot in the window until it is answered.
+			// when the window is full, stop reading from the client.
+			select {
+			case window <- struct{}{}:
+			default:
+				logger.Debug("RELP window is full", "window_size", windowSize)
+				// the read deadline does not apply while waiting for a slot
+				var expired <-chan time.Time
+				var timer *time.Timer
+				if timeout > 0 {
+					timer = time.NewTimer(timeout)
+					expired = timer.C
+				}
+				select {
+				case window <- struct{}{}:
+				case <-answersDone:
+					logger.Info("The RELP connection can not be answered anymore")
+					return
+				case <-expired:
+					logger.Info("The RELP window stayed full: closing the connection", "timeout", timeout)
+					return
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+			}
+			if s.metrics != nil {
+				s.metrics.RelpInflightGauge.WithLabelValues(client).Inc()
 			}
+			conn.extendDeadline(timeout)
 			line := scanner.Text()
 			splits := strings.SplitN(line, " ", 4)
 			txnr, _ := strconv.Atoi(splits[0])
+			if txnr != expectedTxnr {
+				// the answers could not be ordered anymore: answer an error
+				// after the previous transactions, then close the session
+				// with serverclose
+				logger.Warn("Unexpected RELP transaction number", "txnr", splits[0], "expected", expectedTxnr)
+				if s.metrics != nil {
+					s.metrics.RelpProtocolErrorsCounter.WithLabelValues(client).Inc()
+				}
+				answers_chan <- &relpAnswer{txnr: expectedTxnr, answer: relpResponse(txnr, 500, "invalid txnr", "")}
+				conn.drain()
+				return
+			}
+			expectedTxnr = nextTxnr(txnr)
 			command := splits[1]
 			datalen, _ := strconv.Atoi(splits[2])
 			data := ""
@@ -554,45 +833,68 @@ func (h RelpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig)
 			}
 			switch command {
 			case "open":
-				if relpIsOpen {
+				if session != nil {
 					logger.Warn("Received open command twice")
-					s.metrics.RelpProtocolErrorsCounter.WithLabelValues(client).Inc()
+					protocolError(txnr, "session already open")
 					return
 				}
-				answer := fmt.Sprintf("%d rsp %d 200 OK\n%s\n", txnr, len(data)+7, data)
-				conn.Write([]byte(answer))
-				relpIsOpen = true
-				logger.Info("Received 'open' command")
+				var offers string
+				var err error
+				session, offers, err = NegotiateRelpSession(data)
+				if err != nil {
+					logger.Warn("RELP session negotiation failed", "error", err, "offers", data)
+					protocolError(txnr, err.Error())
+					return
+				}
+				answers_chan <- &relpAnswer{txnr: txnr, answer: relpResponse(txnr, 200, "OK", offers)}
+				logger.Info("Received 'open' command", "relp_version", session.Version, "relp_software", session.Software)
 			case "close":
-				if !relpIsOpen {
+				if session == nil {
 					logger.Warn("Received close command before open")
-					s.metrics.RelpProtocolErrorsCounter.WithLabelValues(client).Inc()
+					protocolError(txnr, "session not open")
 					return
 				}
-				answer := fmt.Sprintf("%d rsp 0\n0 serverclose 0\n", txnr)
-				conn.Write([]byte(answer))
-				relpIsOpen = false
+				answers_chan <- &relpAnswer{txnr: txnr, answer: fmt.Sprintf("%d rsp 0\n0 serverclose 0\n", txnr)}
+				session = nil
 				logger.Info("Received 'close' command")
 			case "syslog":
-				if !relpIsOpen {
+				if session == nil {
 					logger.Warn("Received syslog command before open")
-					s.metrics.RelpProtocolErrorsCounter.WithLabelValues(client).Inc()
+					protocolError(txnr, "session not open")
 					return
 				}
+				if !session.Enabled(command) {
+					logger.Warn("Received syslog command, but it was not negotiated")
+					protocolError(txnr, "command not enabled")
+					continue
+				}
+				if ok, disconnect := limits.wait(client); !ok {
+					if disconnect {
+						logger.Info("Rate limit exceeded: disconnecting the client")
+						return
+					}
+					// the client will retry
+					answers_chan <- &relpAnswer{txnr: txnr, answer: relpResponse(txnr, 500, "rate limit exceeded", "")}
+					continue
+				}
 				raw := model.RelpRawMessage{
 					Txnr: txnr,
 					Raw: &model.RawMessage{
 						Message:   data,
 						Client:    client,
 						LocalPort: local_port,
+						Creds:     creds,
+						TLSPeer:   peer,
 					},
 				}
-				s.metrics.IncomingMsgsCounter.WithLabelValues(s.protocol, client, local_port_s, path).Inc()
+				if s.metrics != nil {
+					s.metrics.IncomingMsgsCounter.WithLabelValues(s.protocol, client, local_port_s, path).Inc()
+				}
 				raw_messages_chan <- &raw
 			default:
+				// as librelp does, answer an error and keep the session
 				logger.Warn("Unknown RELP command", "command", command)
-				s.metrics.RelpProtocolErrorsCounter.WithLabelValues(client).Inc()
-				return
+				protocolError(txnr, "invalid command")
 			}
 		} else {
 			logger.Info("Scanning the RELP stream has ended", "error", scanner.Err())
@@ -601,6 +903,17 @@ func (h RelpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig)
 	}
 }
 
+// relpMaxTxnr is the highest RELP transaction number: the next one is 1.
+const relpMaxTxnr = 999999999
+
+// nextTxnr returns the RELP transaction number that follows txnr.
+func nextTxnr(txnr int) int {
+	if txnr >= relpMaxTxnr {
+		return 1
+	}
+	return txnr + 1
+}
+
 func splitSpaceOrLF(r rune) bool {
 	return r == ' ' || r == '\n' || r == '\r'
 }
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RELP session negotiation, see http://www.rsyslog.com/doc/relp.html and
+// librelp's scopen.c.
+
+const relpProtocolVersion = 0
+const relpSoftware = "skewer,1,https://github.com/stephane-martin/skewer"
+
+// relpSupportedCommands lists the RELP commands, besides open and close, that
+// a session can enable.
+var relpSupportedCommands = []string{"syslog"}
+
+type RelpOfferError struct {
+	Message string
+}
+
+func (e *RelpOfferError) Error() string {
+	return e.Message
+}
+
+// RelpOffer is one line of the 'open' command data: a name and optional
+// comma separated values.
+type RelpOffer struct {
+	Name   string
+	Values []string
+}
+
+// RelpSession holds what has been negotiated with the client.
+type RelpSession struct {
+	Version  int
+	Software string
+	Commands map[string]bool
+}
+
+// ParseRelpOffers parses the offers sent by a client with the 'open' command.
+func ParseRelpOffers(data string) ([]*RelpOffer, error) {
+	offers := []*RelpOffer{}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.Trim(line, " \r")
+		if len(line) == 0 {
+			continue
+		}
+		offer := RelpOffer{Values: []string{}}
+		parts := strings.SplitN(line, "=", 2)
+		offer.Name = strings.TrimSpace(parts[0])
+		if len(offer.Name) == 0 {
+			return nil, &RelpOfferError{fmt.Sprintf("invalid offer '%s'", line)}
+		}
+		if len(parts) == 2 && len(parts[1]) > 0 {
+			for _, value := range strings.Split(parts[1], ",") {
+				offer.Values = append(offer.Values, strings.TrimSpace(value))
+			}
+		}
+		offers = append(offers, &offer)
+	}
+	return offers, nil
+}
+
+// NegotiateRelpSession checks the client offers, and returns the negotiated
+// session and the offers that the server sends back to the client.
+//
+// As librelp does, unknown offers are ignored. The relp_version offer is
+// mandatory. The commands offer must contain at least one command that skewer
+// supports, as the session would be useless otherwise.
+func NegotiateRelpSession(data string) (*RelpSession, string, error) {
+	offers, err := ParseRelpOffers(data)
+	if err != nil {
+		return nil, "", err
+	}
+	session := RelpSession{Version: -1, Commands: map[string]bool{}}
+	offeredCommands := []string{}
+	for _, offer := range offers {
+		switch offer.Name {
+		case "relp_version":
+			if len(offer.Values) != 1 {
+				return nil, "", &RelpOfferError{"invalid relp_version offer"}
+			}
+			v, err := strconv.Atoi(offer.Values[0])
+			if err != nil || v < 0 {
+				return nil, "", &RelpOfferError{fmt.Sprintf("invalid relp_version '%s'", offer.Values[0])}
+			}
+			session.Version = v
+		case "relp_software":
+			session.Software = strings.Join(offer.Values, ",")
+		case "commands":
+			offeredCommands = append(offeredCommands, offer.Values...)
+		default:
+		}
+	}
+	if session.Version == -1 {
+		return nil, "", &RelpOfferError{"relp_version offer is missing"}
+	}
+	if session.Version > relpProtocolVersion {
+		session.Version = relpProtocolVersion
+	}
+	for _, command := range offeredCommands {
+		for _, supported := range relpSupportedCommands {
+			if command == supported {
+				session.Commands[command] = true
+			}
+		}
+	}
+	if len(session.Commands) == 0 {
+		return nil, "", &RelpOfferError{fmt.Sprintf("no supported command in offer '%s'", strings.Join(offeredCommands, ","))}
+	}
+
+	enabled := make([]string, 0, len(session.Commands))
+	for _, command := range relpSupportedCommands {
+		if session.Commands[command] {
+			enabled = append(enabled, command)
+		}
+	}
+	answer := bytes.Buffer{}
+	answer.WriteString(fmt.Sprintf("relp_version=%d\n", session.Version))
+	answer.WriteString(fmt.Sprintf("relp_software=%s\n", relpSoftware))
+	answer.WriteString(fmt.Sprintf("commands=%s", strings.Join(enabled, ",")))
+	return &session, answer.String(), nil
+}
+
+// Enabled tells if the command can be used in the session.
+func (s *RelpSession) Enabled(command string) bool {
+	if s == nil {
+		return false
+	}
+	return s.Commands[command]
+}
+
+// relpResponse formats a RELP 'rsp' frame.
+func relpResponse(txnr int, code int, message string, data string) string {
+	body := fmt.Sprintf("%d %s", code, message)
+	if len(data) > 0 {
+		body = body + "\n" + data
+	}
+	return fmt.Sprintf("%d rsp %d %s\n", txnr, len(body), body)
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+)
+
+const relpOpenAnswer = "1 rsp 102 200 OK\nrelp_version=0\nrelp_software=skewer,1,https://github.com/stephane-martin/skewer\ncommands=syslog\n"
+
+func TestNegotiateRelpSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		offers   string
+		err      bool
+		version  int
+		commands []string
+		answer   string
+	}{
+		{
+			name:     "rsyslog offers",
+			offers:   "relp_version=0\nrelp_software=librelp,1.2.14,http://librelp.adiscon.com\ncommands=syslog",
+			version:  0,
+			commands: []string{"syslog"},
+			answer:   "relp_version=0\nrelp_software=skewer,1,https://github.com/stephane-martin/skewer\ncommands=syslog",
+		},
+		{
+			name:     "newer version is downgraded",
+			offers:   "relp_version=1\ncommands=syslog",
+			version:  0,
+			commands: []string{"syslog"},
+		},
+		{
+			name:   "missing relp_version",
+			offers: "commands=syslog",
+			err:    true,
+		},
+		{
+			name:   "invalid relp_version",
+			offers: "relp_version=abc\ncommands=syslog",
+			err:    true,
+		},
+		{
+			name:   "negative relp_version",
+			offers: "relp_version=-1\ncommands=syslog",
+			err:    true,
+		},
+		{
+			name:   "several relp_version values",
+			offers: "relp_version=0,1\ncommands=syslog",
+			err:    true,
+		},
+		{
+			name:     "unsupported commands are not enabled",
+			offers:   "relp_version=0\ncommands=syslog,starttls",
+			version:  0,
+			commands: []string{"syslog"},
+			answer:   "relp_version=0\nrelp_software=skewer,1,https://github.com/stephane-martin/skewer\ncommands=syslog",
+		},
+		{
+			name:   "only unsupported commands",
+			offers: "relp_version=0\ncommands=starttls",
+			err:    true,
+		},
+		{
+			name:   "no commands offer",
+			offers: "relp_version=0",
+			err:    true,
+		},
+		{
+			name:     "unknown offers are ignored",
+			offers:   "relp_version=0\nfoo=bar\ncommands=syslog",
+			version:  0,
+			commands: []string{"syslog"},
+		},
+		{
+			name:   "malformed offer",
+			offers: "relp_version=0\n=syslog\ncommands=syslog",
+			err:    true,
+		},
+	}
+	for _, tt := range tests {
+		session, answer, err := NegotiateRelpSession(tt.offers)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			} else if _, ok := err.(*RelpOfferError); !ok {
+				t.Errorf("%s: expected a RelpOfferError, got %T", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if session.Version != tt.version {
+			t.Errorf("%s: version = %d, expected %d", tt.name, session.Version, tt.version)
+		}
+		if len(session.Commands) != len(tt.commands) {
+			t.Errorf("%s: commands = %v, expected %v", tt.name, session.Commands, tt.commands)
+		}
+		for _, command := range tt.commands {
+			if !session.Enabled(command) {
+				t.Errorf("%s: command %s is not enabled", tt.name, command)
+			}
+		}
+		if len(tt.answer) > 0 && answer != tt.answer {
+			t.Errorf("%s: answer = %q, expected %q", tt.name, answer, tt.answer)
+		}
+	}
+}
+
+func TestRelpResponse(t *testing.T) {
+	tests := []struct {
+		txnr    int
+		code    int
+		message string
+		data    string
+		frame   string
+	}{
+		{1, 200, "OK", "", "1 rsp 6 200 OK\n"},
+		{42, 500, "KO", "", "42 rsp 6 500 KO\n"},
+		{3, 200, "OK", "commands=syslog", "3 rsp 22 200 OK\ncommands=syslog\n"},
+	}
+	for _, tt := range tests {
+		frame := relpResponse(tt.txnr, tt.code, tt.message, tt.data)
+		if frame != tt.frame {
+			t.Errorf("relpResponse(%d, %d, %q, %q) = %q, expected %q", tt.txnr, tt.code, tt.message, tt.data, frame, tt.frame)
+		}
+	}
+}
+
+// relpTestSession runs a RELP connection handler in test mode (no Kafka) on
+// a loopback connection, and returns the client side.
+func relpTestSession(t *testing.T) (*RelpServiceImpl, net.Conn) {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	s := NewRelpServiceImpl(nil, nil, logger)
+	s.test = true
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &conf.SyslogConfig{
+		Format:        "rfc5424",
+		Protocol:      "relp",
+		TopicTmpl:     "relp-{{.Appname}}",
+		PartitionTmpl: "pk-{{.Hostname}}",
+		OnParseError:  "drop",
+	}
+	s.wg.Add(1)
+	go s.handler.HandleConnection(server, config)
+	return s, client
+}
+
+// readRelp reads the expected number of bytes from the server.
+func readRelp(t *testing.T, conn net.Conn, n int) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, n)
+	read, err := io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatalf("reading the RELP answers: %s (got %q)", err, string(buf[:read]))
+	}
+	return string(buf)
+}
+
+// TestRelpSessions checks the frames that skewer sends back, as librelp
+// would send them.
+func TestRelpSessions(t *testing.T) {
+	syslogMsg := "<13>1 2017-01-01T00:00:00Z host app - - - hello"
+	tests := []struct {
+		name    string
+		frames  []string
+		answers string
+	}{
+		{
+			name: "open, syslog, close",
+			frames: []string{
+				"1 open 49 relp_version=0\nrelp_software=test\ncommands=syslog\n",
+				"2 syslog 47 " + syslogMsg + "\n",
+				"3 close 0\n",
+			},
+			// librelp answers close with an empty rsp, then serverclose
+			answers: relpOpenAnswer + "2 rsp 6 200 OK\n" + "3 rsp 0\n0 serverclose 0\n",
+		},
+		{
+			name: "unknown command keeps the session",
+			frames: []string{
+				"1 open 49 relp_version=0\nrelp_software=test\ncommands=syslog\n",
+				"2 foo 0\n",
+				"3 syslog 47 " + syslogMsg + "\n",
+			},
+			answers: relpOpenAnswer + "2 rsp 19 500 invalid command\n" + "3 rsp 6 200 OK\n",
+		},
+		{
+			name: "unparseable message is dropped and answered",
+			frames: []string{
+				"1 open 49 relp_version=0\nrelp_software=test\ncommands=syslog\n",
+				"2 syslog 7 garbage\n",
+				"3 syslog 47 " + syslogMsg + "\n",
+			},
+			answers: relpOpenAnswer + "2 rsp 6 200 OK\n" + "3 rsp 6 200 OK\n",
+		},
+		{
+			name: "transaction number gap closes the session",
+			frames: []string{
+				"1 open 49 relp_version=0\nrelp_software=test\ncommands=syslog\n",
+				"3 syslog 47 " + syslogMsg + "\n",
+			},
+			answers: relpOpenAnswer + "3 rsp 16 500 invalid txnr\n" + "0 serverclose 0\n",
+		},
+		{
+			name: "syslog before open",
+			frames: []string{
+				"1 syslog 47 " + syslogMsg + "\n",
+			},
+			answers: "1 rsp 20 500 session not open\n",
+		},
+		{
+			name: "unsupported commands offer",
+			frames: []string{
+				"1 open 51 relp_version=0\nrelp_software=test\ncommands=starttls\n",
+			},
+			answers: "1 rsp 44 500 no supported command in offer 'starttls'\n",
+		},
+	}
+	for _, tt := range tests {
+		s, client := relpTestSession(t)
+		for _, frame := range tt.frames {
+			_, err := client.Write([]byte(frame))
+			if err != nil {
+				t.Fatalf("%s: %s", tt.name, err)
+			}
+		}
+		answers := readRelp(t, client, len(tt.answers))
+		if answers != tt.answers {
+			t.Errorf("%s: answers = %q, expected %q", tt.name, answers, tt.answers)
+		}
+		client.Close()
+		s.wg.Wait()
+	}
+}
+
+// TestRelpDrain checks that a drained session gets its pending answers, then
+// 'serverclose', and is closed by the server.
+func TestRelpDrain(t *testing.T) {
+	s, client := relpTestSession(t)
+	defer client.Close()
+	_, err := client.Write([]byte("1 open 49 relp_version=0\nrelp_software=test\ncommands=syslog\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers := readRelp(t, client, len(relpOpenAnswer))
+	if answers != relpOpenAnswer {
+		t.Fatalf("answers = %q, expected %q", answers, relpOpenAnswer)
+	}
+	s.drainSessions()
+	expected := "0 serverclose 0\n"
+	answers = readRelp(t, client, len(expected))
+	if answers != expected {
+		t.Errorf("answers = %q, expected %q", answers, expected)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	rest, err := ioutil.ReadAll(client)
+	if err != nil || len(strings.TrimSpace(string(rest))) > 0 {
+		t.Errorf("the server did not close the connection: %q, %v", string(rest), err)
+	}
+	s.wg.Wait()
+}
+
+// TestRelpCloseDrained checks that a drained connection that is closed when
+// the drain timeout expires still gets 'serverclose'.
+func TestRelpCloseDrained(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newRelpConn(server)
+	conn.drain()
+	conn.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	rest, err := ioutil.ReadAll(client)
+	if err != nil || string(rest) != "0 serverclose 0\n" {
+		t.Errorf("answers = %q, %v, expected serverclose", string(rest), err)
+	}
+}
+
+func TestNextTxnr(t *testing.T) {
+	if n := nextTxnr(0); n != 1 {
+		t.Errorf("nextTxnr(0) = %d, expected 1", n)
+	}
+	if n := nextTxnr(41); n != 42 {
+		t.Errorf("nextTxnr(41) = %d, expected 42", n)
+	}
+	if n := nextTxnr(relpMaxTxnr); n != 1 {
+		t.Errorf("nextTxnr(%d) = %d, expected 1", relpMaxTxnr, n)
+	}
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+
+	sarama "gopkg.in/Shopify/sarama.v1"
+
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/model"
+)
+
+// relpMetadata is attached to the Kafka messages produced for RELP, so that
+// the ACKs/NACKs can be routed back to the right connection.
+type relpMetadata struct {
+	connID uint64
+	txnr   int
+}
+
+// relpAck is the Kafka outcome for one RELP transaction.
+type relpAck struct {
+	txnr  int
+	topic string
+	err   error
+}
+
+// RelpProducerPool shares a small number of Kafka producers between all the
+// connections of a RELP service. The producers are built from the service
+// Kafka client, so that they also share the broker connections and metadata.
+type RelpProducerPool struct {
+	producers []sarama.AsyncProducer
+	conns     map[uint64]*RelpProducerHandle
+	mu        *sync.Mutex
+	wg        *sync.WaitGroup
+	nextID    uint64
+	logger    log15.Logger
+	fatalChan chan struct{}
+	fatalOnce *sync.Once
+	closed    chan struct{}
+	abandoned chan struct{}
+	abandonMu *sync.Once
+}
+
+// RelpProducerHandle is the view of the pool given to one RELP connection.
+type RelpProducerHandle struct {
+	pool     *RelpProducerPool
+	id       uint64
+	producer sarama.AsyncProducer
+	acks     chan *relpAck
+	done     chan struct{}
+	inflight int64
+	once     *sync.Once
+}
+
+func NewRelpProducerPool(client sarama.Client, size int, logger log15.Logger) (*RelpProducerPool, error) {
+	if size < 1 {
+		size = 1
+	}
+	p := RelpProducerPool{
+		producers: make([]sarama.AsyncProducer, 0, size),
+		conns:     map[uint64]*RelpProducerHandle{},
+		mu:        &sync.Mutex{},
+		wg:        &sync.WaitGroup{},
+		logger:    logger.New("class", "RelpProducerPool"),
+		fatalChan: make(chan struct{}),
+		fatalOnce: &sync.Once{},
+		closed:    make(chan struct{}),
+		abandoned: make(chan struct{}),
+		abandonMu: &sync.Once{},
+	}
+	for i := 0; i < size; i++ {
+		producer, err := sarama.NewAsyncProducerFromClient(client)
+		if err != nil {
+			for _, prod := range p.producers {
+				prod.AsyncClose()
+			}
+			return nil, err
+		}
+		p.producers = append(p.producers, producer)
+	}
+	for _, producer := range p.producers {
+		p.wg.Add(1)
+		go p.dispatch(producer)
+	}
+	return &p, nil
+}
+
+// dispatch routes the Kafka responses of one producer to the connections.
+// It returns when the producer has been closed.
+func (p *RelpProducerPool) dispatch(producer sarama.AsyncProducer) {
+	defer p.wg.Done()
+	successChan := producer.Successes()
+	failureChan := producer.Errors()
+	for {
+		if successChan == nil && failureChan == nil {
+			return
+		}
+		select {
+		case succ, more := <-successChan:
+			if more {
+				meta := succ.Metadata.(relpMetadata)
+				p.route(meta.connID, &relpAck{txnr: meta.txnr, topic: succ.Topic})
+			} else {
+				successChan = nil
+			}
+		case fail, more := <-failureChan:
+			if more {
+				meta := fail.Msg.Metadata.(relpMetadata)
+				p.route(meta.connID, &relpAck{txnr: meta.txnr, topic: fail.Msg.Topic, err: fail.Err})
+				if model.IsFatalKafkaError(fail.Err) {
+					p.fatalOnce.Do(func() { close(p.fatalChan) })
+				}
+			} else {
+				failureChan = nil
+			}
+		}
+	}
+}
+
+func (p *RelpProducerPool) route(connID uint64, ack *relpAck) {
+	p.mu.Lock()
+	h, ok := p.conns[connID]
+	p.mu.Unlock()
+	if !ok {
+		// the connection is already gone: nobody to answer to
+		p.logger.Debug("Dropping Kafka response for a closed RELP connection", "txnr", ack.txnr)
+		return
+	}
+	// the dispatcher must not wait for a slow connection: the acks queue is
+	// as large as the RELP window, so it can not be full
+	select {
+	case h.acks <- ack:
+	case <-h.done:
+	default:
+		p.logger.Error("The Kafka responses queue of a RELP connection is full", "txnr", ack.txnr)
+	}
+}
+
+// Register gives a new connection its handle on the pool. The connections
+// are spread over the producers in a round-robin fashion. window is the
+// maximum number of messages that the connection sends before it receives
+// their Kafka responses.
+func (p *RelpProducerPool) Register(window int) *RelpProducerHandle {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextID++
+	h := RelpProducerHandle{
+		pool:     p,
+		id:       p.nextID,
+		producer: p.producers[p.nextID%uint64(len(p.producers))],
+		acks:     make(chan *relpAck, window),
+		done:     make(chan struct{}),
+		once:     &sync.Once{},
+	}
+	p.conns[h.id] = &h
+	return &h
+}
+
+func (p *RelpProducerPool) unregister(id uint64) {
+	p.mu.Lock()
+	delete(p.conns, id)
+	p.mu.Unlock()
+}
+
+// Fatal is closed when Kafka has returned a fatal error.
+func (p *RelpProducerPool) Fatal() <-chan struct{} {
+	return p.fatalChan
+}
+
+// Closed is closed after the pool has been closed.
+func (p *RelpProducerPool) Closed() <-chan struct{} {
+	return p.closed
+}
+
+// Abandon tells the connections to stop waiting for the pending Kafka
+// responses, and to stop sending new messages.
+func (p *RelpProducerPool) Abandon() {
+	p.abandonMu.Do(func() { close(p.abandoned) })
+}
+
+// Close closes the producers and waits for the pending Kafka responses to be
+// dispatched.
+func (p *RelpProducerPool) Close() {
+	for _, producer := range p.producers {
+		producer.AsyncClose()
+	}
+	p.wg.Wait()
+	close(p.closed)
+}
+
+// Send pushes a message to Kafka on behalf of the RELP transaction txnr. It
+// returns false when the pool has been abandoned.
+func (h *RelpProducerHandle) Send(m *sarama.ProducerMessage, txnr int) bool {
+	m.Metadata = relpMetadata{connID: h.id, txnr: txnr}
+	atomic.AddInt64(&h.inflight, 1)
+	select {
+	case h.producer.Input() <- m:
+		return true
+	case <-h.pool.abandoned:
+		atomic.AddInt64(&h.inflight, -1)
+		return false
+	}
+}
+
+// Acks returns the Kafka responses for the messages sent through the handle.
+func (h *RelpProducerHandle) Acks() <-chan *relpAck {
+	return h.acks
+}
+
+// Done must be called each time a response has been received from Acks().
+func (h *RelpProducerHandle) Done() {
+	atomic.AddInt64(&h.inflight, -1)
+}
+
+// Inflight returns the number of messages still waiting for a Kafka response.
+func (h *RelpProducerHandle) Inflight() int64 {
+	return atomic.LoadInt64(&h.inflight)
+}
+
+// Abandoned is closed when the pending Kafka responses should not be waited
+// for anymore.
+func (h *RelpProducerHandle) Abandoned() <-chan struct{} {
+	return h.pool.abandoned
+}
+
+// PoolClosed is closed when the underlying pool has been closed.
+func (h *RelpProducerHandle) PoolClosed() <-chan struct{} {
+	return h.pool.closed
+}
+
+// Close detaches the handle from the pool. Responses that arrive later are
+// dropped.
+func (h *RelpProducerHandle) Close() {
+	h.once.Do(func() {
+		h.pool.unregister(h.id)
+		close(h.done)
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/inconshreveable/log15"
@@ -29,7 +30,6 @@ type tcpServerImpl struct {
 	status     TcpServerStatus
 	statusChan chan TcpServerStatus
 	stasher    model.Stasher
-	metrics    *metrics.Metrics
 	generator  chan ulid.ULID
 }
 
@@ -41,9 +41,9 @@ func NewTcpService(stasher model.Stasher, gen chan ulid.ULID, b *sys.BinderClien
 	s := tcpServerImpl{
 		status:    TcpStopped,
 		stasher:   stasher,
-		metrics:   m,
 		generator: gen,
 	}
+	s.metrics = m
 	s.logger = l.New("class", "TcpServer")
 	s.binder = b
 	s.protocol = "tcp"
@@ -56,6 +56,9 @@ func (s *tcpServerImpl) SetKafkaConf(kc *conf.KafkaConfig) {}
 
 func (s *tcpServerImpl) SetAuditConf(ac *conf.AuditConfig) {}
 
+func (s *tcpServerImpl) SetWatcherConf(wc []conf.WatcherConfig, offsets map[string]*model.FileOffset) {
+}
+
 func (s *tcpServerImpl) WaitClosed() {
 	var more bool
 	for {
@@ -112,15 +115,6 @@ func (h tcpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig) {
 	var local_port int
 
 	s := h.Server
-	s.AddConnection(conn)
-
-	raw_messages_chan := make(chan *model.RawMessage)
-
-	defer func() {
-		close(raw_messages_chan)
-		s.RemoveConnection(conn)
-		s.wg.Done()
-	}()
 
 	client := ""
 	path := ""
@@ -131,55 +125,87 @@ func (h tcpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig) {
 		local_port = 0
 		path = conn.LocalAddr().String()
 	} else {
-		client = strings.Split(remote.String(), ":")[0]
-		local := conn.LocalAddr()
-		if local != nil {
-			s := strings.Split(local.String(), ":")
-			local_port, _ = strconv.Atoi(s[len(s)-1])
-		}
+		client = remoteHost(remote)
+		local_port, _ = addrPort(conn.LocalAddr())
 	}
 	client = strings.TrimSpace(client)
 	path = strings.TrimSpace(path)
 	local_port_s := strconv.FormatInt(int64(local_port), 10)
 
+	if !s.AddConnection(conn, config, client) {
+		conn.Close()
+		s.wg.Done()
+		return
+	}
+
+	raw_messages_chan := make(chan *model.RawMessage)
+
+	defer func() {
+		close(raw_messages_chan)
+		s.RemoveConnection(conn)
+		s.wg.Done()
+	}()
+
 	logger := s.logger.New("protocol", s.protocol, "client", client, "local_port", local_port, "unix_socket_path", path, "format", config.Format)
 	logger.Info("New client")
 	if s.metrics != nil {
 		s.metrics.ClientConnectionCounter.WithLabelValues(s.protocol, client, local_port_s, path).Inc()
 	}
 
+	// on unix sockets, the kernel tells us who is on the other side
+	creds := streamCredentials(conn)
+	if creds != nil && config.UnixProcInfo {
+		creds.Comm, creds.Exe, creds.Cgroup = sys.ProcessInfo(creds.Pid)
+	}
+	// in TLS, the client may have presented a certificate
+	peer, err := handshake(conn, config.Timeout)
+	if err != nil {
+		logger.Info("TLS handshake failed", "error", err)
+		return
+	}
+
 	// pull messages from raw_messages_chan, parse them and push them to the Store
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
 		e := NewParsersEnv(s.ParserConfigs, s.logger)
+		// the multiline settings have been checked in conf.Complete()
+		multiline, _ := config.GetMultiline()
+		stasher, closeStasher := withMultiline(s.stasher, multiline)
+		defer closeStasher()
 		for m := range raw_messages_chan {
-			parser := e.GetParser(config.Format)
+			parser := e.GetSyslogParser(config)
 			if parser == nil {
 				logger.Error("Unknown parser")
 				continue
 			}
 			p, err := parser.Parse(m.Message, config.DontParseSD)
-
-			if err == nil {
-				uid := <-s.generator
-				parsed_msg := model.TcpUdpParsedMessage{
-					Parsed: &model.ParsedMessage{
-						Fields:         p,
-						Client:         m.Client,
-						LocalPort:      m.LocalPort,
-						UnixSocketPath: m.UnixSocketPath,
-					},
-					Uid:    uid.String(),
-					ConfId: config.ConfID,
-				}
-				s.stasher.Stash(&parsed_msg)
-			} else {
+			if err != nil {
 				if s.metrics != nil {
 					s.metrics.ParsingErrorCounter.WithLabelValues(config.Format, client).Inc()
 				}
-				logger.Info("Parsing error", "Message", m.Message, "error", err)
+				logger.Info("Parsing error", "Message", m.Message, "error", err, "policy", config.OnParseError)
+				p = onParseError(config, m.Message, err)
+				if p == nil {
+					continue
+				}
 			}
+			p.SetPeerCredentials(m.Creds)
+			p.SetTLSPeer(m.TLSPeer)
+			uid := <-s.generator
+			parsed_msg := model.TcpUdpParsedMessage{
+				Parsed: &model.ParsedMessage{
+					Fields:         p,
+					Client:         m.Client,
+					LocalPort:      m.LocalPort,
+					UnixSocketPath: m.UnixSocketPath,
+					Creds:          m.Creds,
+					TLSPeer:        m.TLSPeer,
+				},
+				Uid:    uid.String(),
+				ConfId: config.ConfID,
+			}
+			stasher.Stash(&parsed_msg)
 		}
 	}()
 
@@ -187,16 +213,46 @@ func (h tcpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig) {
 	if timeout > 0 {
 		conn.SetReadDeadline(time.Now().Add(timeout))
 	}
+	// conf.Complete sets the default framing of the format
+	framer := newTcpFramer(
+		config.Framing,
+		config.MaxFrameSize,
+		func() {
+			logger.Warn("Oversized frame discarded", "max_frame_size", config.MaxFrameSize)
+			if s.metrics != nil {
+				s.metrics.FramingErrorsCounter.WithLabelValues("oversized").Inc()
+			}
+		},
+		func() {
+			if s.metrics != nil {
+				s.metrics.FramingErrorsCounter.WithLabelValues("malformed").Inc()
+			}
+		},
+	)
 	scanner := bufio.NewScanner(conn)
-	switch config.Format {
-	case "rfc5424", "rfc3164", "json", "auto":
-		scanner.Split(TcpSplit)
-	default:
-		scanner.Split(LFTcpSplit)
+	scanner.Buffer(make([]byte, 0, 4096), framer.BufferSize())
+	scanner.Split(framer.Split)
+	limits := s.limits(config)
+
+	// past max_connection_age, the reads are interrupted
+	var aged int32
+	if age := connectionAge(config); age > 0 {
+		t := time.AfterFunc(age, func() {
+			atomic.StoreInt32(&aged, 1)
+			conn.SetReadDeadline(time.Now())
+		})
+		defer t.Stop()
 	}
 
 	for {
 		if scanner.Scan() {
+			if ok, disconnect := limits.wait(client); !ok {
+				if disconnect {
+					logger.Info("Rate limit exceeded: disconnecting the client")
+					return
+				}
+				continue
+			}
 			if timeout > 0 {
 				conn.SetReadDeadline(time.Now().Add(timeout))
 			}
@@ -204,11 +260,17 @@ func (h tcpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig) {
 				Client:    client,
 				LocalPort: local_port,
 				Message:   scanner.Text(),
+				Creds:     creds,
+				TLSPeer:   peer,
 			}
 			if s.metrics != nil {
 				s.metrics.IncomingMsgsCounter.WithLabelValues(s.protocol, client, local_port_s, path).Inc()
 			}
 			raw_messages_chan <- &raw
+			if atomic.LoadInt32(&aged) == 1 {
+				logger.Info("Maximum connection age reached: disconnecting the client")
+				return
+			}
 		} else {
 			logger.Info("End of TCP client connection", "error", scanner.Err())
 			return
@@ -216,23 +278,6 @@ func (h tcpHandler) HandleConnection(conn net.Conn, config *conf.SyslogConfig) {
 	}
 }
 
-func LFTcpSplit(data []byte, atEOF bool) (int, []byte, error) {
-	trimmed_data := bytes.TrimLeft(data, " \r\n")
-	if len(trimmed_data) == 0 {
-		return 0, nil, nil
-	}
-	trimmed := len(data) - len(trimmed_data)
-	lf := bytes.IndexByte(trimmed_data, '\n')
-	if lf >= 0 {
-		token := bytes.Trim(trimmed_data[0:lf], " \r\n")
-		advance := trimmed + lf + 1
-		return advance, token, nil
-	} else {
-		// data does not contain a full syslog line
-		return 0, nil, nil
-	}
-}
-
 func PluginSplit(data []byte, atEOF bool) (int, []byte, error) {
 	trimmed_data := bytes.TrimLeft(data, " \r\n")
 	if len(trimmed_data) < 11 {
@@ -259,42 +304,3 @@ func PluginSplit(data []byte, atEOF bool) (int, []byte, error) {
 	token := bytes.Trim(trimmed_data[11:11+datalen], " \r\n")
 	return advance, token, nil
 }
-
-func TcpSplit(data []byte, atEOF bool) (int, []byte, error) {
-	trimmed_data := bytes.TrimLeft(data, " \r\n")
-	if len(trimmed_data) == 0 {
-		return 0, nil, nil
-	}
-	trimmed := len(data) - len(trimmed_data)
-	if trimmed_data[0] == byte('<') {
-		// LF framing
-		lf := bytes.IndexByte(trimmed_data, '\n')
-		if lf >= 0 {
-			token := bytes.Trim(trimmed_data[0:lf], " \r\n")
-			advance := trimmed + lf + 1
-			return advance, token, nil
-		} else {
-			// data does not contain a full syslog line
-			return 0, nil, nil
-		}
-	} else {
-		// octet counting framing
-		sp := bytes.IndexAny(trimmed_data, " \n")
-		if sp <= 0 {
-			return 0, nil, nil
-		}
-		datalen_s := bytes.Trim(trimmed_data[0:sp], " \r\n")
-		datalen, err := strconv.Atoi(string(datalen_s))
-		if err != nil {
-			return 0, nil, err
-		}
-		advance := trimmed + sp + 1 + datalen
-		if len(data) >= advance {
-			token := bytes.Trim(trimmed_data[sp+1:sp+1+datalen], " \r\n")
-			return advance, token, nil
-		} else {
-			return 0, nil, nil
-		}
-
-	}
-}
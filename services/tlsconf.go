@@ -0,0 +1,135 @@
+package services
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/utils"
+)
+
+// tlsCheckInterval is how often the certificate files are checked for
+// changes.
+const tlsCheckInterval = 5 * time.Second
+
+// listenerTLS builds the TLS configuration of a listener. The CA,
+// certificate, key and OCSP staple files are reloaded when they change on
+// disk, so that the new connections use the new certificate without a
+// restart.
+type listenerTLS struct {
+	config  *conf.SyslogConfig
+	logger  log15.Logger
+	mu      sync.Mutex
+	current *tls.Config
+	stamps  map[string]time.Time
+	checked time.Time
+}
+
+// tlsConfig returns the TLS configuration of a listener. An error is
+// returned if the certificates can not be loaded yet.
+func (s *GenericService) tlsConfig(config *conf.SyslogConfig) (*tls.Config, error) {
+	s.connMutex.Lock()
+	if s.listenersTLS == nil {
+		s.listenersTLS = map[*conf.SyslogConfig]*listenerTLS{}
+	}
+	l, ok := s.listenersTLS[config]
+	if !ok {
+		l = &listenerTLS{config: config, logger: s.logger}
+		s.listenersTLS[config] = l
+	}
+	s.connMutex.Unlock()
+	_, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return l.get()
+		},
+	}, nil
+}
+
+// get returns the current configuration, after reloading the files if they
+// have changed. If the new files can not be loaded, the previous
+// configuration is kept.
+func (l *listenerTLS) get() (*tls.Config, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.current != nil && now.Sub(l.checked) < tlsCheckInterval {
+		return l.current, nil
+	}
+	l.checked = now
+	stamps := l.fileStamps()
+	if l.current != nil && sameStamps(stamps, l.stamps) {
+		return l.current, nil
+	}
+	tlsConf, err := l.load()
+	if err != nil {
+		if l.current == nil {
+			return nil, err
+		}
+		l.logger.Warn("Error reloading the TLS certificates: keeping the previous ones", "error", err)
+		return l.current, nil
+	}
+	if l.current != nil {
+		l.logger.Info("TLS certificates reloaded", "cert_file", l.config.CertFile)
+	}
+	l.current = tlsConf
+	l.stamps = stamps
+	return l.current, nil
+}
+
+func (l *listenerTLS) load() (*tls.Config, error) {
+	c := l.config
+	tlsConf, err := utils.NewTLSConfig("", c.CAFile, c.CAPath, c.CertFile, c.KeyFile, false)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf.ClientAuth = c.GetClientAuthType()
+	tlsConf.ClientCAs = tlsConf.RootCAs
+	// the options have been checked in conf.Complete()
+	tlsConf.MinVersion, _ = c.GetMinTLSVersion()
+	tlsConf.CipherSuites, _ = c.GetCipherSuites()
+	tlsConf.CurvePreferences, _ = c.GetCurves()
+	if len(c.OCSPStapleFile) > 0 && len(tlsConf.Certificates) > 0 {
+		staple, err := ioutil.ReadFile(c.OCSPStapleFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.Certificates[0].OCSPStaple = staple
+	}
+	return tlsConf, nil
+}
+
+// fileStamps returns the modification times of the files the
+// configuration is built from.
+func (l *listenerTLS) fileStamps() map[string]time.Time {
+	stamps := map[string]time.Time{}
+	for _, fname := range []string{l.config.CAFile, l.config.CAPath, l.config.CertFile, l.config.KeyFile, l.config.OCSPStapleFile} {
+		if len(fname) == 0 {
+			continue
+		}
+		infos, err := os.Stat(fname)
+		if err == nil {
+			stamps[fname] = infos.ModTime()
+		}
+	}
+	return stamps
+}
+
+func sameStamps(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for fname, t := range a {
+		if !t.Equal(b[fname]) {
+			return false
+		}
+	}
+	return true
+}
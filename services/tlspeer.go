@@ -0,0 +1,53 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/stephane-martin/skewer/model"
+)
+
+// handshake completes the TLS handshake of a connection, and returns the
+// verified certificate of the client. It returns nil for the connections
+// that are not in TLS, or when the client did not present a certificate.
+func handshake(conn net.Conn, timeout time.Duration) (*model.TLSPeer, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+	if timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+		defer tlsConn.SetDeadline(time.Time{})
+	}
+	err := tlsConn.Handshake()
+	if err != nil {
+		return nil, err
+	}
+	return tlsPeer(tlsConn.ConnectionState()), nil
+}
+
+// tlsPeer extracts the identity of the client from a TLS connection state.
+func tlsPeer(state tls.ConnectionState) *model.TLSPeer {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return newTLSPeer(state.VerifiedChains[0][0])
+}
+
+func newTLSPeer(cert *x509.Certificate) *model.TLSPeer {
+	fingerprint := sha256.Sum256(cert.Raw)
+	peer := model.TLSPeer{
+		CN:          cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Emails:      cert.EmailAddresses,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+	for _, ip := range cert.IPAddresses {
+		peer.IPAddresses = append(peer.IPAddresses, ip.String())
+	}
+	return &peer
+}
@@ -1,9 +1,13 @@
 package services
 
 import (
+	"fmt"
+	"hash/fnv"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/inconshreveable/log15"
 	"github.com/oklog/ulid"
@@ -26,12 +30,11 @@ type udpServiceImpl struct {
 	statusChan chan UdpServerStatus
 	stasher    model.Stasher
 	handler    PacketHandler
-	metrics    *metrics.Metrics
 	generator  chan ulid.ULID
 }
 
 type PacketHandler interface {
-	HandleConnection(conn net.PacketConn, config *conf.SyslogConfig)
+	HandleConnection(sock *udpSocket, config *conf.SyslogConfig, raw_messages_chan chan *model.RawMessage)
 }
 
 type UdpHandler struct {
@@ -43,7 +46,8 @@ func (s *udpServiceImpl) init() {
 }
 
 func NewUdpService(stasher model.Stasher, gen chan ulid.ULID, b *sys.BinderClient, m *metrics.Metrics, l log15.Logger) NetworkService {
-	s := udpServiceImpl{status: UdpStopped, metrics: m, stasher: stasher, generator: gen}
+	s := udpServiceImpl{status: UdpStopped, stasher: stasher, generator: gen}
+	s.metrics = m
 	s.logger = l.New("class", "UdpServer")
 	s.binder = b
 	s.init()
@@ -52,14 +56,13 @@ func NewUdpService(stasher model.Stasher, gen chan ulid.ULID, b *sys.BinderClien
 	return &s
 }
 
-func (s *udpServiceImpl) handleConnection(conn net.PacketConn, config *conf.SyslogConfig) {
-	s.handler.HandleConnection(conn, config)
-}
-
 func (s *udpServiceImpl) SetKafkaConf(kc *conf.KafkaConfig) {}
 
 func (s *udpServiceImpl) SetAuditConf(ac *conf.AuditConfig) {}
 
+func (s *udpServiceImpl) SetWatcherConf(wc []conf.WatcherConfig, offsets map[string]*model.FileOffset) {
+}
+
 func (s *udpServiceImpl) Start(test bool) ([]*model.ListenerInfo, error) {
 	s.statusMutex.Lock()
 	defer s.statusMutex.Unlock()
@@ -68,7 +71,7 @@ func (s *udpServiceImpl) Start(test bool) ([]*model.ListenerInfo, error) {
 	}
 	s.statusChan = make(chan UdpServerStatus, 1)
 
-	s.connections = map[Connection]bool{}
+	s.connections = map[Connection]*trackedConn{}
 	infos := s.ListenPacket()
 	if len(infos) > 0 {
 		s.status = UdpStarted
@@ -108,11 +111,131 @@ func (s *udpServiceImpl) WaitClosed() {
 	}
 }
 
+// udpSocket is a listening UDP or unixgram socket. Several goroutines may
+// read the same socket.
+type udpSocket struct {
+	conn      net.PacketConn
+	udp       *net.UDPConn
+	unix      *net.UnixConn
+	drops     uint32
+	processes *keyedLimiter
+	gelf      *gelfAssembler
+}
+
+// rawPacketConn returns the socket under the wrappers of the binder.
+func rawPacketConn(conn net.PacketConn) net.PacketConn {
+	switch c := conn.(type) {
+	case *sys.FilePacketConn:
+		// the socket was given by the binder
+		return c.PacketConn
+	case *sys.SharedPacketConn:
+		return c.PacketConn
+	default:
+		return conn
+	}
+}
+
+func newUdpSocket(conn net.PacketConn) *udpSocket {
+	sock := udpSocket{conn: conn}
+	raw := rawPacketConn(conn)
+	if udpConn, ok := raw.(*net.UDPConn); ok {
+		if sys.EnableDropsCount(udpConn) == nil {
+			sock.udp = udpConn
+		}
+	}
+	if unixConn, ok := raw.(*net.UnixConn); ok {
+		// the sender credentials come with each datagram
+		if sys.EnablePassCred(unixConn) == nil {
+			sock.unix = unixConn
+		}
+	}
+	return &sock
+}
+
+// setReadBuffer sets the socket receive buffer size.
+func (sock *udpSocket) setReadBuffer(size int) error {
+	raw := rawPacketConn(sock.conn)
+	if rconn, ok := raw.(interface {
+		SetReadBuffer(int) error
+	}); ok {
+		return rconn.SetReadBuffer(size)
+	}
+	return nil
+}
+
+// newDrops returns the number of datagrams dropped by the kernel since the
+// last call.
+func (sock *udpSocket) newDrops(count uint32) uint32 {
+	for {
+		last := atomic.LoadUint32(&sock.drops)
+		if count <= last {
+			return 0
+		}
+		if atomic.CompareAndSwapUint32(&sock.drops, last, count) {
+			return count - last
+		}
+	}
+}
+
+var udpBufferPool = &sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 65536)
+	},
+}
+
+func (s *udpServiceImpl) listenUDP(listenAddr string, reuse bool, viaBinder bool) (net.PacketConn, error) {
+	if viaBinder {
+		if reuse {
+			return s.binder.ListenPacketReusePort("udp", listenAddr)
+		}
+		return s.binder.ListenPacket("udp", listenAddr)
+	}
+	if reuse {
+		return sys.ListenPacketReusePort("udp", listenAddr)
+	}
+	if s.binder != nil {
+		// the root parent keeps the socket open across upgrades
+		return s.binder.ListenPacketShared("udp", listenAddr, "")
+	}
+	return net.ListenPacket("udp", listenAddr)
+}
+
+// listenLocal creates the local syslog socket. It usually needs root
+// privileges, so it is asked to the binder.
+func (s *udpServiceImpl) listenLocal(config *conf.SyslogConfig) (net.PacketConn, error) {
+	mode, err := config.GetSocketMode()
+	if err != nil {
+		return nil, err
+	}
+	o := sys.LocalSocketOptions{
+		Path:  config.UnixSocketPath,
+		Owner: config.SocketOwner,
+		Group: config.SocketGroup,
+		Mode:  mode,
+	}
+	if s.binder == nil {
+		return sys.ListenLocalSocket(o)
+	}
+	return s.binder.ListenLocal(o)
+}
+
 func (s *udpServiceImpl) ListenPacket() []*model.ListenerInfo {
 	udpinfos := []*model.ListenerInfo{}
 	s.unixSocketPaths = []string{}
 	for _, syslogConf := range s.SyslogConfigs {
-		if syslogConf.Protocol == "udp" {
+		if syslogConf.Protocol == "local" {
+			conn, err := s.listenLocal(syslogConf)
+			if err != nil {
+				s.logger.Warn("Error listening on the local syslog socket", "path", syslogConf.UnixSocketPath, "error", err)
+				continue
+			}
+			s.logger.Debug("Listener", "protocol", "local", "path", syslogConf.UnixSocketPath, "format", syslogConf.Format)
+			udpinfos = append(udpinfos, &model.ListenerInfo{
+				UnixSocketPath: syslogConf.UnixSocketPath,
+				Protocol:       "local",
+			})
+			s.startListener([]net.PacketConn{conn}, syslogConf)
+		} else if syslogConf.Protocol == "udp" {
 			if len(syslogConf.UnixSocketPath) > 0 {
 				conn, err := net.ListenPacket("unixgram", syslogConf.UnixSocketPath)
 				if err != nil {
@@ -141,41 +264,72 @@ func (s *udpServiceImpl) ListenPacket() []*model.ListenerInfo {
 						Protocol:       s.protocol,
 					})
 					s.unixSocketPaths = append(s.unixSocketPaths, syslogConf.UnixSocketPath)
-					s.wg.Add(1)
-					go s.handleConnection(conn, syslogConf)
+					s.startListener([]net.PacketConn{conn}, syslogConf)
+				}
+			} else if len(syslogConf.SocketName) > 0 {
+				// the sockets given by systemd replace bind_addr and port
+				for _, conn := range s.activatedPacketConns(syslogConf) {
+					s.logger.Debug("Listener", "protocol", s.protocol, "socket_name", syslogConf.SocketName, "format", syslogConf.Format)
+					if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+						udpinfos = append(udpinfos, &model.ListenerInfo{
+							BindAddr: addr.IP.String(),
+							Port:     addr.Port,
+							Protocol: syslogConf.Protocol,
+						})
+					}
+					s.startListener([]net.PacketConn{conn}, syslogConf)
 				}
 			} else {
-				listenAddr, _ := syslogConf.GetListenAddr()
-				conn, err := net.ListenPacket("udp", listenAddr)
-				if err != nil {
-					switch err.(type) {
-					case *net.OpError:
-						if s.binder == nil || syslogConf.Port > 1024 {
-							s.logger.Warn("Listen UDP OpError", "error", err)
-							conn = nil
-						} else {
-							s.logger.Info("Listen unixgram OpError. Retrying as root.", "error", err)
-							conn, err = s.binder.ListenPacket("udp", listenAddr)
-							if err != nil {
+				// the addresses have been checked in conf.Complete()
+				listenAddrs, _ := syslogConf.GetListenAddrs()
+				for _, listenAddr := range listenAddrs {
+					// with SO_REUSEPORT, each reader gets its own socket
+					reuse := syslogConf.UDPReaders > 1 && sys.ReusePortSupported
+					viaBinder := false
+					conn, err := s.listenUDP(listenAddr, reuse, false)
+					if err != nil {
+						switch err.(type) {
+						case *net.OpError:
+							if s.binder == nil || syslogConf.Port > 1024 {
 								s.logger.Warn("Listen UDP OpError", "error", err)
 								conn = nil
+							} else {
+								s.logger.Info("Listen UDP OpError. Retrying as root.", "error", err)
+								// all the sockets bound with SO_REUSEPORT must belong to the same user
+								viaBinder = true
+								conn, err = s.listenUDP(listenAddr, reuse, true)
+								if err != nil {
+									s.logger.Warn("Listen UDP OpError", "error", err)
+									conn = nil
+								}
 							}
+						default:
+							s.logger.Warn("Listen UDP error", "error", err)
+							conn = nil
 						}
-					default:
-						s.logger.Warn("Listen UDP error", "error", err)
-						conn = nil
-					}
 
-				}
-				if conn != nil && err == nil {
-					s.logger.Debug("Listener", "protocol", s.protocol, "bind_addr", syslogConf.BindAddr, "port", syslogConf.Port, "format", syslogConf.Format)
-					udpinfos = append(udpinfos, &model.ListenerInfo{
-						BindAddr: syslogConf.BindAddr,
-						Port:     syslogConf.Port,
-						Protocol: syslogConf.Protocol,
-					})
-					s.wg.Add(1)
-					go s.handleConnection(conn, syslogConf)
+					}
+					if conn != nil && err == nil {
+						s.logger.Debug("Listener", "protocol", s.protocol, "listen_addr", listenAddr, "format", syslogConf.Format)
+						host, _, _ := net.SplitHostPort(listenAddr)
+						udpinfos = append(udpinfos, &model.ListenerInfo{
+							BindAddr: host,
+							Port:     syslogConf.Port,
+							Protocol: syslogConf.Protocol,
+						})
+						conns := []net.PacketConn{conn}
+						if reuse {
+							for i := 1; i < syslogConf.UDPReaders; i++ {
+								conn, err = s.listenUDP(listenAddr, true, viaBinder)
+								if err != nil {
+									s.logger.Warn("Error opening another UDP socket with SO_REUSEPORT", "error", err)
+									break
+								}
+								conns = append(conns, conn)
+							}
+						}
+						s.startListener(conns, syslogConf)
+					}
 				}
 			}
 		}
@@ -183,17 +337,202 @@ func (s *udpServiceImpl) ListenPacket() []*model.ListenerInfo {
 	return udpinfos
 }
 
-func (h UdpHandler) HandleConnection(conn net.PacketConn, config *conf.SyslogConfig) {
+// activatedPacketConns returns the sockets that systemd gave with the
+// socket_name of config.
+func (s *udpServiceImpl) activatedPacketConns(config *conf.SyslogConfig) []net.PacketConn {
+	conns := []net.PacketConn{}
+	if s.binder == nil {
+		s.logger.Warn("socket_name needs the root parent", "socket_name", config.SocketName)
+		return conns
+	}
+	for i := 0; ; i++ {
+		conn, err := s.binder.ListenPacketShared("udp", fmt.Sprintf("%s#%d", config.SocketName, i), config.SocketName)
+		if err != nil {
+			if i == 0 {
+				s.logger.Warn("Error getting the activated socket", "socket_name", config.SocketName, "error", err)
+			}
+			return conns
+		}
+		conns = append(conns, conn)
+	}
+}
+
+// startListener starts the readers and the parsing workers of a listener.
+func (s *udpServiceImpl) startListener(conns []net.PacketConn, config *conf.SyslogConfig) {
+	readers := config.UDPReaders
+	if readers <= 0 {
+		readers = 1
+	}
+	workers := config.UDPWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	goroutinesPerSocket := 1
+	if len(conns) == 1 {
+		// no SO_REUSEPORT: the readers share the socket
+		goroutinesPerSocket = readers
+	}
+
+	var processes *keyedLimiter
+	if config.Protocol == "local" {
+		processes = newKeyedLimiter(config.ProcessRate, config.ProcessBurst)
+	}
+	var gelf *gelfAssembler
+	if config.Format == "gelf" {
+		gelf = newGelfAssembler(func(client string) {
+			s.logger.Debug("Incomplete GELF message dropped", "client", client)
+			if s.metrics != nil {
+				s.metrics.FramingErrorsCounter.WithLabelValues("gelf_incomplete").Inc()
+			}
+		})
+	}
+
+	raw_messages_chan := make(chan *model.RawMessage, 4096)
+	readersWg := &sync.WaitGroup{}
+	for _, conn := range conns {
+		sock := newUdpSocket(conn)
+		sock.processes = processes
+		sock.gelf = gelf
+		if config.UDPRcvBuf > 0 {
+			err := sock.setReadBuffer(config.UDPRcvBuf)
+			if err != nil {
+				s.logger.Warn("Error setting the UDP receive buffer size", "error", err)
+			}
+		}
+		for i := 0; i < goroutinesPerSocket; i++ {
+			readersWg.Add(1)
+			s.wg.Add(1)
+			go func() {
+				s.handler.HandleConnection(sock, config, raw_messages_chan)
+				readersWg.Done()
+			}()
+		}
+	}
+
+	// the workers end when all the readers have ended
+	s.wg.Add(1)
+	go func() {
+		readersWg.Wait()
+		close(raw_messages_chan)
+		s.wg.Done()
+	}()
+
+	// the multiline settings have been checked in conf.Complete()
+	multiline, _ := config.GetMultiline()
+	stasher, closeStasher := withMultiline(s.stasher, multiline)
+	// the lines of a multiline message must be joined in the order they were
+	// received: the messages of a client are always parsed by the same worker
+	workersChans := []chan *model.RawMessage{raw_messages_chan}
+	if multiline != nil && workers > 1 {
+		workersChans = s.shardByClient(raw_messages_chan, workers)
+	}
+	procs := newProcInfoCache()
+	workersWg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		workersWg.Add(1)
+		s.wg.Add(1)
+		c := workersChans[i%len(workersChans)]
+		go func() {
+			s.parse(c, config, procs, stasher)
+			workersWg.Done()
+		}()
+	}
+
+	// the pending multiline messages are stashed when the workers have ended
+	s.wg.Add(1)
+	go func() {
+		workersWg.Wait()
+		closeStasher()
+		s.wg.Done()
+	}()
+}
+
+// shardByClient dispatches the raw messages to n channels, so that the
+// messages of a client always go to the same channel. The channels are closed
+// when raw_messages_chan is closed.
+func (s *udpServiceImpl) shardByClient(raw_messages_chan chan *model.RawMessage, n int) []chan *model.RawMessage {
+	shards := make([]chan *model.RawMessage, n)
+	for i := range shards {
+		shards[i] = make(chan *model.RawMessage, 4096/n+1)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for m := range raw_messages_chan {
+			h := fnv.New32a()
+			h.Write([]byte(m.Client))
+			shards[h.Sum32()%uint32(n)] <- m
+		}
+		for _, shard := range shards {
+			close(shard)
+		}
+	}()
+	return shards
+}
+
+// parse pulls messages from raw_messages_chan, parses them and pushes them to the Store
+func (s *udpServiceImpl) parse(raw_messages_chan chan *model.RawMessage, config *conf.SyslogConfig, procs *procInfoCache, stasher model.Stasher) {
+	defer s.wg.Done()
+	logger := s.logger.New("protocol", s.protocol, "format", config.Format)
+	e := NewParsersEnv(s.ParserConfigs, s.logger)
+	var err error
+	for m := range raw_messages_chan {
+		parser := e.GetSyslogParser(config)
+		if parser == nil {
+			logger.Error("Unknown parser", "client", m.Client)
+			continue
+		}
+		var p *model.SyslogMessage
+		if config.Format == "gelf" {
+			// GELF datagrams may be compressed
+			var payload []byte
+			payload, err = model.DecompressGelf([]byte(m.Message))
+			if err == nil {
+				p, err = parser.Parse(string(payload), config.DontParseSD)
+			}
+		} else {
+			p, err = parser.Parse(m.Message, config.DontParseSD)
+		}
+
+		if err != nil {
+			if s.metrics != nil {
+				s.metrics.ParsingErrorCounter.WithLabelValues(config.Format, m.Client).Inc()
+			}
+			logger.Info("Parsing error", "client", m.Client, "message", m.Message, "error", err, "policy", config.OnParseError)
+			p = onParseError(config, m.Message, err)
+			if p == nil {
+				continue
+			}
+		}
+		if m.Creds != nil && config.UnixProcInfo {
+			procs.fill(m.Creds)
+		}
+		p.SetPeerCredentials(m.Creds)
+		uid := <-s.generator
+		parsed_msg := model.TcpUdpParsedMessage{
+			Parsed: &model.ParsedMessage{
+				Fields:         p,
+				Client:         m.Client,
+				LocalPort:      m.LocalPort,
+				UnixSocketPath: m.UnixSocketPath,
+				Creds:          m.Creds,
+			},
+			Uid:    uid.String(),
+			ConfId: config.ConfID,
+		}
+		stasher.Stash(&parsed_msg)
+	}
+}
+
+func (h UdpHandler) HandleConnection(sock *udpSocket, config *conf.SyslogConfig, raw_messages_chan chan *model.RawMessage) {
 	var local_port int
 	var err error
 
 	s := h.Server
-	s.AddConnection(conn)
-
-	raw_messages_chan := make(chan *model.RawMessage)
+	conn := sock.conn
+	s.AddConnection(conn, nil, "")
 
 	defer func() {
-		close(raw_messages_chan)
 		s.RemoveConnection(conn)
 		s.wg.Done()
 	}()
@@ -201,11 +540,10 @@ func (h UdpHandler) HandleConnection(conn net.PacketConn, config *conf.SyslogCon
 	path := ""
 	local := conn.LocalAddr()
 	if local != nil {
-		l := local.String()
-		s := strings.Split(l, ":")
-		local_port, err = strconv.Atoi(s[len(s)-1])
-		if err != nil {
-			path = l
+		var ok bool
+		local_port, ok = addrPort(local)
+		if !ok {
+			path = local.String()
 		}
 	}
 	path = strings.TrimSpace(path)
@@ -213,44 +551,55 @@ func (h UdpHandler) HandleConnection(conn net.PacketConn, config *conf.SyslogCon
 
 	logger := s.logger.New("protocol", s.protocol, "local_port", local_port, "unix_socket_path", path, "format", config.Format)
 
-	// pull messages from raw_messages_chan, parse them and push them to the Store
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		e := NewParsersEnv(s.ParserConfigs, s.logger)
-		for m := range raw_messages_chan {
-			parser := e.GetParser(config.Format)
-			if parser == nil {
-				logger.Error("Unknown parser", "client", m.Client)
-				continue
-			}
-			p, err := parser.Parse(m.Message, config.DontParseSD)
+	limits := s.limits(config)
+	var oob []byte
+	if sock.udp != nil {
+		oob = make([]byte, sys.DropsOOBSize)
+	} else if sock.unix != nil {
+		oob = make([]byte, sys.CredentialsOOBSize)
+	}
 
+	// Syslog UDP server
+	for {
+		var size int
+		var remote net.Addr
+		var creds *model.PeerCredentials
+		packet := udpBufferPool.Get().([]byte)
+		if sock.udp != nil {
+			var oobn int
+			var udpRemote *net.UDPAddr
+			size, oobn, _, udpRemote, err = sock.udp.ReadMsgUDP(packet, oob)
+			if udpRemote != nil {
+				remote = udpRemote
+			}
 			if err == nil {
-				uid := <-s.generator
-				parsed_msg := model.TcpUdpParsedMessage{
-					Parsed: &model.ParsedMessage{
-						Fields:         p,
-						Client:         m.Client,
-						LocalPort:      m.LocalPort,
-						UnixSocketPath: m.UnixSocketPath,
-					},
-					Uid:    uid.String(),
-					ConfId: config.ConfID,
+				if count, ok := sys.DropsCount(oob[:oobn]); ok {
+					drops := sock.newDrops(count)
+					if drops > 0 && s.metrics != nil {
+						s.metrics.UdpKernelDropsCounter.WithLabelValues(local_port_s).Add(float64(drops))
+					}
 				}
-				s.stasher.Stash(&parsed_msg)
-			} else {
-				s.metrics.ParsingErrorCounter.WithLabelValues(config.Format, m.Client).Inc()
-				logger.Info("Parsing error", "client", m.Client, "message", m.Message, "error", err)
 			}
+		} else if sock.unix != nil {
+			var oobn int
+			size, oobn, _, _, err = sock.unix.ReadMsgUnix(packet, oob)
+			if err == nil {
+				if pid, uid, gid, ok := sys.ParseCredentials(oob[:oobn]); ok {
+					creds = &model.PeerCredentials{Pid: pid, Uid: uid, Gid: gid}
+				}
+			}
+			if err == nil && creds != nil && !sock.processes.allow(strconv.FormatInt(int64(creds.Pid), 10)) {
+				udpBufferPool.Put(packet)
+				if s.metrics != nil {
+					s.metrics.RateLimitedCounter.WithLabelValues(config.Protocol, "drop").Inc()
+				}
+				continue
+			}
+		} else {
+			size, remote, err = conn.ReadFrom(packet)
 		}
-	}()
-
-	// Syslog UDP server
-	for {
-		packet := make([]byte, 65536)
-		size, remote, err := conn.ReadFrom(packet)
 		if err != nil {
+			udpBufferPool.Put(packet)
 			logger.Debug("Error reading UDP", "error", err)
 			return
 		}
@@ -259,16 +608,48 @@ func (h UdpHandler) HandleConnection(conn net.PacketConn, config *conf.SyslogCon
 			// unix socket
 			client = "localhost"
 		} else {
-			client = strings.Split(remote.String(), ":")[0]
+			client = remoteHost(remote)
+		}
+		if !limits.permitted(clientIP(remote)) {
+			udpBufferPool.Put(packet)
+			continue
+		}
+		// rate_limit_action = "delay" is rejected for the datagram
+		// listeners: wait never sleeps in the reader
+		if ok, _ := limits.wait(client); !ok {
+			udpBufferPool.Put(packet)
+			continue
+		}
+
+		data := packet[:size]
+		if sock.gelf != nil {
+			data, err = sock.gelf.add(client, data)
+			if err != nil {
+				udpBufferPool.Put(packet)
+				if s.metrics != nil {
+					s.metrics.FramingErrorsCounter.WithLabelValues("gelf_invalid").Inc()
+				}
+				logger.Info("Invalid GELF chunk", "client", client, "error", err)
+				continue
+			}
+			if data == nil {
+				// waiting for the other chunks
+				udpBufferPool.Put(packet)
+				continue
+			}
 		}
 
 		raw := model.RawMessage{
 			Client:         client,
 			LocalPort:      local_port,
 			UnixSocketPath: path,
-			Message:        string(packet[:size]),
+			Message:        string(data),
+			Creds:          creds,
+		}
+		udpBufferPool.Put(packet)
+		if s.metrics != nil {
+			s.metrics.IncomingMsgsCounter.WithLabelValues(s.protocol, client, local_port_s, path).Inc()
 		}
-		s.metrics.IncomingMsgsCounter.WithLabelValues(s.protocol, client, local_port_s, path).Inc()
 		raw_messages_chan <- &raw
 	}
 
@@ -0,0 +1,411 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/inconshreveable/log15"
+	"github.com/oklog/ulid"
+	"github.com/stephane-martin/skewer/conf"
+	"github.com/stephane-martin/skewer/metrics"
+	"github.com/stephane-martin/skewer/model"
+)
+
+const (
+	maxTailedLineSize = 65536
+	tailPollInterval  = time.Second
+)
+
+// WatcherService tails the files of the [[watcher]] sections. The position
+// after each line travels with the message, and the Store records it once
+// the message is stored: after a restart, the files are read again from
+// there.
+type WatcherService struct {
+	stasher   model.Stasher
+	generator chan ulid.ULID
+	metrics   *metrics.Metrics
+	logger    log15.Logger
+	watchers  []conf.WatcherConfig
+	parsers   []conf.ParserConfig
+	wg        *sync.WaitGroup
+	stopchan  chan struct{}
+
+	mu sync.Mutex
+	// tailers is indexed by path, and files lists the files being read, so
+	// that a rotated file is not read twice
+	tailers map[string]*tailer
+	files   map[fileID]bool
+	// positions is indexed by device and inode: a rotated file is found again
+	// under its new name
+	positions map[fileID]*model.FileOffset
+}
+
+// fileID identifies a file independently of its name.
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+func NewWatcherService(stasher model.Stasher, generator chan ulid.ULID, m *metrics.Metrics, l log15.Logger) *WatcherService {
+	s := WatcherService{stasher: stasher, generator: generator, metrics: m}
+	s.logger = l.New("class", "watcher")
+	s.wg = &sync.WaitGroup{}
+	s.positions = map[fileID]*model.FileOffset{}
+	return &s
+}
+
+func (s *WatcherService) SetConf(sc []*conf.SyslogConfig, pc []conf.ParserConfig) {
+	s.parsers = pc
+}
+
+func (s *WatcherService) SetKafkaConf(kc *conf.KafkaConfig) {}
+
+func (s *WatcherService) SetAuditConf(ac *conf.AuditConfig) {}
+
+func (s *WatcherService) SetWatcherConf(wc []conf.WatcherConfig, offsets map[string]*model.FileOffset) {
+	s.watchers = wc
+	s.positions = map[fileID]*model.FileOffset{}
+	for _, offset := range offsets {
+		s.positions[fileID{device: offset.Device, inode: offset.Inode}] = offset
+	}
+}
+
+func (s *WatcherService) Start(test bool) ([]*model.ListenerInfo, error) {
+	s.stopchan = make(chan struct{})
+	s.tailers = map[string]*tailer{}
+	s.files = map[fileID]bool{}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.logger.Warn("Can't watch the files, they will be polled", "error", err)
+		fsw = nil
+	} else {
+		for _, watcher := range s.watchers {
+			dir := filepath.Dir(watcher.Filename)
+			err := fsw.Add(dir)
+			if err != nil {
+				s.logger.Warn("Can't watch directory", "directory", dir, "error", err)
+			}
+		}
+	}
+
+	s.scan(true)
+	s.wg.Add(1)
+	go s.watch(fsw)
+	s.logger.Debug("Watcher service is started")
+	return []*model.ListenerInfo{}, nil
+}
+
+func (s *WatcherService) Stop() {
+	close(s.stopchan)
+	s.wg.Wait()
+}
+
+func (s *WatcherService) WaitClosed() {
+	s.wg.Wait()
+}
+
+// watch wakes up the tailers when their file changes, and looks for new
+// files. The files are also polled, in case some events were missed.
+func (s *WatcherService) watch(fsw *fsnotify.Watcher) {
+	defer s.wg.Done()
+	var events chan fsnotify.Event
+	var errors chan error
+	if fsw != nil {
+		defer fsw.Close()
+		events = fsw.Events
+		errors = fsw.Errors
+	}
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopchan:
+			return
+		case ev := <-events:
+			if ev.Op&(fsnotify.Create|fsnotify.Rename) != 0 {
+				s.scan(false)
+			}
+			s.wakeup(ev.Name)
+		case err := <-errors:
+			s.logger.Debug("Error watching files", "error", err)
+		case <-ticker.C:
+			s.scan(false)
+			s.wakeup("")
+		}
+	}
+}
+
+// wakeup signals the tailer of path, or all of them if path is empty.
+func (s *WatcherService) wakeup(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p, t := range s.tailers {
+		if path == "" || p == path {
+			select {
+			case t.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// scan starts a tailer for each file that matches a watcher pattern and is
+// not read yet. At startup, the files that were never read start at the
+// position given by whence. The files that appear later are read from the
+// beginning.
+func (s *WatcherService) scan(startup bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.watchers {
+		config := &s.watchers[i]
+		paths, err := filepath.Glob(config.Filename)
+		if err != nil {
+			continue
+		}
+		for _, path := range paths {
+			if _, ok := s.tailers[path]; ok {
+				continue
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				s.logger.Debug("Can't open file", "filename", path, "error", err)
+				continue
+			}
+			fi, err := f.Stat()
+			if err != nil || !fi.Mode().IsRegular() {
+				f.Close()
+				continue
+			}
+			id := getFileID(fi)
+			if s.files[id] {
+				// already read under another name
+				f.Close()
+				continue
+			}
+			var offset int64
+			if pos := s.position(config, id); pos != nil {
+				if pos.Offset <= fi.Size() {
+					offset = pos.Offset
+				}
+			} else if startup && config.Whence == io.SeekEnd {
+				offset = fi.Size()
+			}
+			if offset > 0 {
+				_, err = f.Seek(offset, io.SeekStart)
+				if err != nil {
+					f.Close()
+					continue
+				}
+			}
+			t := &tailer{
+				service: s,
+				config:  config,
+				path:    path,
+				file:    f,
+				id:      id,
+				offset:  offset,
+				wake:    make(chan struct{}, 1),
+				logger:  s.logger.New("filename", path),
+			}
+			s.tailers[path] = t
+			s.files[id] = true
+			s.logger.Debug("Tailing file", "filename", path, "offset", offset)
+			s.wg.Add(1)
+			go t.run()
+		}
+	}
+}
+
+// release forgets a tailer, and remembers where it stopped reading.
+func (s *WatcherService) release(t *tailer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tailers[t.path] == t {
+		delete(s.tailers, t.path)
+	}
+	delete(s.files, t.id)
+	s.positions[t.id] = t.position()
+}
+
+// position returns the saved offset of the file, or nil if the file has not
+// been read yet. Inodes are reused after a file is deleted: the offset is
+// only trusted if it was saved for a file of the same watcher, either under
+// the same name or under a name of a rotation.
+func (s *WatcherService) position(config *conf.WatcherConfig, id fileID) *model.FileOffset {
+	pos, ok := s.positions[id]
+	if !ok {
+		// offsets saved before the device was recorded
+		pos, ok = s.positions[fileID{inode: id.inode}]
+		if !ok {
+			return nil
+		}
+	}
+	if matched, _ := filepath.Match(config.Filename, pos.Filename); !matched {
+		return nil
+	}
+	return pos
+}
+
+func getFileID(fi os.FileInfo) fileID {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return fileID{device: uint64(st.Dev), inode: uint64(st.Ino)}
+	}
+	return fileID{}
+}
+
+// tailer reads the lines of one file, and follows the file when it is
+// rotated by rename or by truncation.
+type tailer struct {
+	service *WatcherService
+	config  *conf.WatcherConfig
+	path    string
+	file    *os.File
+	id      fileID
+	offset  int64
+	partial []byte
+	wake    chan struct{}
+	logger  log15.Logger
+	parser  Parser
+	stasher model.Stasher
+}
+
+func (t *tailer) run() {
+	s := t.service
+	defer s.wg.Done()
+	env := NewParsersEnv(s.parsers, s.logger)
+	t.parser = env.GetParser(t.config.Format)
+	if t.parser == nil {
+		t.logger.Error("Unknown parser", "format", t.config.Format)
+		t.file.Close()
+		s.release(t)
+		return
+	}
+	// the body parser has been checked in conf.Complete()
+	body, _ := t.config.GetBodyParser()
+	t.parser = withBodyParser(t.parser, body)
+	// the multiline settings have been checked in conf.Complete()
+	multiline, _ := t.config.GetMultiline()
+	var closeStasher func()
+	t.stasher, closeStasher = withMultiline(s.stasher, multiline)
+	defer closeStasher()
+	reader := bufio.NewReaderSize(t.file, maxTailedLineSize)
+
+	for {
+		t.read(reader)
+
+		fi, err := os.Stat(t.path)
+		if err != nil || getFileID(fi) != t.id {
+			// rotated or removed: the writer may have written a few more
+			// lines before moving to the new file
+			t.read(reader)
+			t.flush()
+			t.file.Close()
+			s.release(t)
+			if err == nil {
+				// the new file is picked up by the next scan
+				s.scan(false)
+			}
+			return
+		}
+		if fi.Size() < t.offset+int64(len(t.partial)) {
+			t.logger.Info("File was truncated")
+			_, err = t.file.Seek(0, io.SeekStart)
+			if err != nil {
+				t.logger.Warn("Error rewinding file", "error", err)
+				t.file.Close()
+				s.release(t)
+				return
+			}
+			t.offset = 0
+			t.partial = t.partial[:0]
+			reader.Reset(t.file)
+			continue
+		}
+
+		select {
+		case <-s.stopchan:
+			t.file.Close()
+			s.release(t)
+			return
+		case <-t.wake:
+		}
+	}
+}
+
+// read consumes the complete lines until the end of the file. An incomplete
+// last line is kept until the writer terminates it.
+func (t *tailer) read(reader *bufio.Reader) {
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		t.partial = append(t.partial, chunk...)
+		switch err {
+		case nil:
+			t.emit()
+		case bufio.ErrBufferFull:
+			if len(t.partial) >= maxTailedLineSize {
+				t.emit()
+			}
+		case io.EOF:
+			return
+		default:
+			t.logger.Warn("Error reading file", "error", err)
+			return
+		}
+	}
+}
+
+// flush emits the incomplete last line of a file that won't grow anymore.
+func (t *tailer) flush() {
+	if len(t.partial) > 0 {
+		t.emit()
+	}
+}
+
+func (t *tailer) emit() {
+	s := t.service
+	t.offset += int64(len(t.partial))
+	line := string(bytes.TrimRight(t.partial, "\r\n"))
+	t.partial = t.partial[:0]
+	if len(line) == 0 {
+		return
+	}
+	if s.metrics != nil {
+		s.metrics.IncomingMsgsCounter.WithLabelValues("watcher", "watcher", "", t.path).Inc()
+	}
+	p, err := t.parser.Parse(line, t.config.DontParseSD)
+	if err != nil {
+		if s.metrics != nil {
+			s.metrics.ParsingErrorCounter.WithLabelValues(t.config.Format, "watcher").Inc()
+		}
+		t.logger.Info("Parsing error", "message", line, "error", err)
+		return
+	}
+	if p.Properties == nil {
+		p.Properties = map[string]interface{}{}
+	}
+	p.Properties["watcher"] = map[string]interface{}{"filename": t.path}
+	uid := <-s.generator
+	t.stasher.Stash(&model.TcpUdpParsedMessage{
+		Parsed: &model.ParsedMessage{
+			Fields: p,
+			Client: "watcher",
+		},
+		Uid:    uid.String(),
+		ConfId: t.config.ConfID,
+		Offset: t.position(),
+	})
+}
+
+// position returns the current offset of the tailer in its file.
+func (t *tailer) position() *model.FileOffset {
+	return &model.FileOffset{Filename: t.path, Device: t.id.device, Inode: t.id.inode, Offset: t.offset}
+}
@@ -95,6 +95,7 @@ func (fwder *kafkaForwarder) getAndSendMessages(ctx context.Context, from Store,
 	}()
 
 	jsenvs := map[string]javascript.FilterEnvironment{}
+	configs := map[string]*conf.SyslogConfig{}
 
 ForOutputs:
 	for {
@@ -121,46 +122,64 @@ ForOutputs:
 					config.PartitionTmpl,
 					fwder.logger,
 				)
+				configs[message.ConfId] = config
 				env = jsenvs[message.ConfId]
 			}
 
-			topic, errs := env.Topic(message.Parsed.Fields)
-			for _, err := range errs {
-				fwder.logger.Info("Error calculating topic", "error", err, "uid", message.Uid)
-			}
-			partitionKey, errs := env.PartitionKey(message.Parsed.Fields)
-			for _, err := range errs {
-				fwder.logger.Info("Error calculating the partition key", "error", err, "uid", message.Uid)
-			}
+			var topic, partitionKey string
+			var errs []error
+			tmsg := message.Parsed.Fields
+			if configs[message.ConfId].Deadletter(message.Parsed.Fields) {
+				// the messages that could not be parsed skip the filter and
+				// the topic functions
+				topic = configs[message.ConfId].DeadletterTopic
+				partitionKey = message.Parsed.Client
+			} else {
+				topic, errs = env.Topic(message.Parsed.Fields)
+				for _, err := range errs {
+					fwder.logger.Info("Error calculating topic", "error", err, "uid", message.Uid)
+				}
+				partitionKey, errs = env.PartitionKey(message.Parsed.Fields)
+				for _, err := range errs {
+					fwder.logger.Info("Error calculating the partition key", "error", err, "uid", message.Uid)
+				}
 
-			if len(topic) == 0 || len(partitionKey) == 0 {
-				fwder.logger.Warn("Topic or PartitionKey could not be calculated", "uid", message.Uid)
-				from.PermError(message.Uid)
-				continue ForOutputs
-			}
+				if len(topic) == 0 || len(partitionKey) == 0 {
+					fwder.logger.Warn("Topic or PartitionKey could not be calculated", "uid", message.Uid)
+					from.PermError(message.Uid)
+					continue ForOutputs
+				}
+				if !configs[message.ConfId].TopicAllowed(message.Parsed.TLSPeer.Identities(), topic) {
+					fwder.logger.Warn("The client certificate is not allowed to write to the topic", "topic", topic, "uid", message.Uid)
+					fwder.metrics.MessageFilteringCounter.WithLabelValues("unauthorized", message.Parsed.Client).Inc()
+					from.PermError(message.Uid)
+					continue ForOutputs
+				}
 
-			tmsg, filterResult, err := env.FilterMessage(message.Parsed.Fields)
+				filtered, filterResult, err := env.FilterMessage(message.Parsed.Fields)
 
-			switch filterResult {
-			case javascript.DROPPED:
-				from.ACK(message.Uid)
-				fwder.metrics.MessageFilteringCounter.WithLabelValues("dropped", message.Parsed.Client).Inc()
-				continue ForOutputs
-			case javascript.REJECTED:
-				fwder.metrics.MessageFilteringCounter.WithLabelValues("rejected", message.Parsed.Client).Inc()
-				from.NACK(message.Uid)
-				continue ForOutputs
-			case javascript.PASS:
-				fwder.metrics.MessageFilteringCounter.WithLabelValues("passing", message.Parsed.Client).Inc()
-				if tmsg == nil {
+				switch filterResult {
+				case javascript.DROPPED:
 					from.ACK(message.Uid)
+					fwder.metrics.MessageFilteringCounter.WithLabelValues("dropped", message.Parsed.Client).Inc()
+					continue ForOutputs
+				case javascript.REJECTED:
+					fwder.metrics.MessageFilteringCounter.WithLabelValues("rejected", message.Parsed.Client).Inc()
+					from.NACK(message.Uid)
+					continue ForOutputs
+				case javascript.PASS:
+					fwder.metrics.MessageFilteringCounter.WithLabelValues("passing", message.Parsed.Client).Inc()
+					if filtered == nil {
+						from.ACK(message.Uid)
+						continue ForOutputs
+					}
+				default:
+					from.PermError(message.Uid)
+					fwder.logger.Warn("Error happened processing message", "uid", message.Uid, "error", err)
+					fwder.metrics.MessageFilteringCounter.WithLabelValues("unknown", message.Parsed.Client).Inc()
 					continue ForOutputs
 				}
-			default:
-				from.PermError(message.Uid)
-				fwder.logger.Warn("Error happened processing message", "uid", message.Uid, "error", err)
-				fwder.metrics.MessageFilteringCounter.WithLabelValues("unknown", message.Parsed.Client).Inc()
-				continue ForOutputs
+				tmsg = filtered
 			}
 
 			nmsg := model.ParsedMessage{
@@ -168,6 +187,8 @@ ForOutputs:
 				Client:         message.Parsed.Client,
 				LocalPort:      message.Parsed.LocalPort,
 				UnixSocketPath: message.Parsed.UnixSocketPath,
+				Creds:          message.Parsed.Creds,
+				TLSPeer:        message.Parsed.TLSPeer,
 			}
 
 			kafkaMsg, err := nmsg.ToKafkaMessage(partitionKey, topic)
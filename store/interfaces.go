@@ -18,6 +18,7 @@ type Store interface {
 	GetSyslogConfig(configID string) (*conf.SyslogConfig, error)
 	//StoreSyslogConfig(config *conf.SyslogConfig) error
 	StoreAllSyslogConfigs(c *conf.GConfig) error
+	FileOffsets() (map[string]*model.FileOffset, error)
 	ReadAllBadgers() (map[string]string, map[string]string, map[string]string)
 }
 
@@ -26,6 +26,7 @@ type MessageStore struct {
 	failedDB        utils.Partition
 	permerrorsDB    utils.Partition
 	syslogConfigsDB utils.Partition
+	offsetsDB       utils.Partition
 
 	metrics *metrics.Metrics
 
@@ -48,6 +49,7 @@ type MessageStore struct {
 	FatalErrorChan chan struct{}
 
 	toStashQueue    []*model.TcpUdpParsedMessage
+	toConfirm       map[string]func(bool)
 	ackQueue        []string
 	nackQueue       []string
 	permerrorsQueue []string
@@ -80,6 +82,7 @@ func NewStore(ctx context.Context, cfg conf.StoreConfig, m *metrics.Metrics, l l
 	store.logger = l.New("class", "MessageStore")
 
 	store.toStashQueue = make([]*model.TcpUdpParsedMessage, 0, 1000)
+	store.toConfirm = map[string]func(bool){}
 	store.ackQueue = make([]string, 0, 300)
 	store.nackQueue = make([]string, 0, 300)
 	store.permerrorsQueue = make([]string, 0, 300)
@@ -114,6 +117,7 @@ func NewStore(ctx context.Context, cfg conf.StoreConfig, m *metrics.Metrics, l l
 	store.failedDB = utils.NewPartition(kv, "failed")
 	store.permerrorsDB = utils.NewPartition(kv, "permerrors")
 	store.syslogConfigsDB = utils.NewPartition(kv, "configs")
+	store.offsetsDB = utils.NewPartition(kv, "offsets")
 
 	// only once, push back messages from previous run that may have been stuck in the sent queue
 	store.resetStuckInSent()
@@ -199,8 +203,16 @@ func NewStore(ctx context.Context, cfg conf.StoreConfig, m *metrics.Metrics, l l
 			if len(store.toStashQueue) > 0 {
 				copyQueue := store.toStashQueue
 				store.toStashQueue = make([]*model.TcpUdpParsedMessage, 0, 1000)
+				confirms := store.toConfirm
+				if len(confirms) > 0 {
+					store.toConfirm = map[string]func(bool){}
+				}
 				store.stashqueue_mu.Unlock() // while we ingest the previous queue, clients can send more into the new queue
-				store.ingest(copyQueue)
+				ingested, _ := store.ingest(copyQueue)
+				for uid, confirm := range confirms {
+					_, stored := ingested[uid]
+					confirm(stored)
+				}
 				store.stashqueue_mu.Lock()
 			}
 		}
@@ -272,11 +284,18 @@ func NewStore(ctx context.Context, cfg conf.StoreConfig, m *metrics.Metrics, l l
 			if len(messages) > 0 {
 				store.ready_mu.Unlock()
 				// loop on the available messages, but immediately stop if the context is canceled
-				for _, msg := range messages {
+				for uid, msg := range messages {
 					select {
 					case store.OutputsChan <- msg:
+						delete(messages, uid)
 					case <-doneChan:
 						store.ready_mu.Lock()
+						// the remaining messages were never handed to the forwarder
+						remaining := make([]string, 0, len(messages))
+						for uid := range messages {
+							remaining = append(remaining, uid)
+						}
+						store.pushBackToReady(remaining)
 						return
 					}
 				}
@@ -287,6 +306,7 @@ func NewStore(ctx context.Context, cfg conf.StoreConfig, m *metrics.Metrics, l l
 
 	go func() {
 		store.wg.Wait()
+		store.logSummary()
 		store.closeBadgers()
 		close(store.closedChan)
 	}()
@@ -332,6 +352,21 @@ func (s *MessageStore) StoreAllSyslogConfigs(c *conf.GConfig) (err error) {
 	}
 	c.Journald.ConfID = journalSyslogConf.ConfID
 
+	for i, watcher := range c.Watchers {
+		watcherSyslogConf := conf.SyslogConfig{
+			TopicTmpl:     watcher.TopicTmpl,
+			TopicFunc:     watcher.TopicFunc,
+			PartitionTmpl: watcher.PartitionTmpl,
+			PartitionFunc: watcher.PartitionFunc,
+			FilterFunc:    watcher.FilterFunc,
+		}
+		err = s.StoreSyslogConfig(&watcherSyslogConf)
+		if err != nil {
+			return err
+		}
+		c.Watchers[i].ConfID = watcherSyslogConf.ConfID
+	}
+
 	return nil
 }
 
@@ -386,6 +421,20 @@ func (s *MessageStore) closeBadgers() {
 	s.logger.Debug("Badger databases are closed")
 }
 
+// logSummary reports what is left in the Store when it is closed.
+func (s *MessageStore) logSummary() {
+	s.stashqueue_mu.Lock()
+	notStashed := len(s.toStashQueue)
+	s.stashqueue_mu.Unlock()
+	s.logger.Info("Store summary at closing",
+		"ready", s.readyDB.Count(),
+		"sent", s.sentDB.Count(),
+		"failed", s.failedDB.Count(),
+		"permerrors", s.permerrorsDB.Count(),
+		"not_stashed", notStashed,
+	)
+}
+
 func (s *MessageStore) pruneOrphaned() {
 	// find if we have some old full messages
 
@@ -424,6 +473,38 @@ func (s *MessageStore) resetStuckInSent() {
 
 }
 
+// pushBackToReady moves messages from "sent" back to "ready". The caller must
+// hold ready_mu.
+func (s *MessageStore) pushBackToReady(uids []string) {
+	if len(uids) == 0 {
+		return
+	}
+	s.messages_mu.Lock()
+	defer s.messages_mu.Unlock()
+	readyBatch := map[string][]byte{}
+	for _, uid := range uids {
+		readyBatch[uid] = []byte("true")
+	}
+	errs, err := s.readyDB.AddMany(readyBatch)
+	if err != nil {
+		s.logger.Warn("Error pushing back messages to the 'ready' queue", "error", err)
+	}
+	s.metrics.BadgerGauge.WithLabelValues("ready").Add(float64(len(readyBatch) - len(errs)))
+	for _, uid := range errs {
+		delete(readyBatch, uid)
+	}
+	sentBatch := make([]string, 0, len(readyBatch))
+	for uid := range readyBatch {
+		sentBatch = append(sentBatch, uid)
+	}
+	errs, err = s.sentDB.DeleteMany(sentBatch)
+	if err != nil {
+		s.logger.Warn("Error deleting messages from the 'sent' queue", "error", err)
+	}
+	s.metrics.BadgerGauge.WithLabelValues("sent").Sub(float64(len(sentBatch) - len(errs)))
+	s.logger.Debug("Messages pushed back from 'sent' to 'ready'", "nb_messages", len(sentBatch)-len(errs))
+}
+
 func (s *MessageStore) ReadAllBadgers() (map[string]string, map[string]string, map[string]string) {
 	return nil, nil, nil // FIXME
 }
@@ -497,6 +578,19 @@ func (s *MessageStore) resetFailures() {
 	}
 }
 
+// maxStashBacklog is the number of stashed messages waiting to be written
+// in the badger databases above which the Store is congested.
+const maxStashBacklog = 10000
+
+// Congested reports that the messages are stashed faster than they are
+// written in the badger databases.
+func (s *MessageStore) Congested() bool {
+	s.stashqueue_mu.Lock()
+	backlog := len(s.toStashQueue)
+	s.stashqueue_mu.Unlock()
+	return backlog > maxStashBacklog
+}
+
 func (s *MessageStore) Stash(m *model.TcpUdpParsedMessage) {
 	s.stashqueue_mu.Lock()
 	s.toStashQueue = append(s.toStashQueue, m)
@@ -504,11 +598,23 @@ func (s *MessageStore) Stash(m *model.TcpUdpParsedMessage) {
 	s.stashqueue_mu.Unlock()
 }
 
-func (s *MessageStore) ingest(queue []*model.TcpUdpParsedMessage) (int, error) {
+// StashConfirm stashes m, and calls confirm when m has been written in the
+// badger databases, or could not be.
+func (s *MessageStore) StashConfirm(m *model.TcpUdpParsedMessage, confirm func(stored bool)) {
+	s.stashqueue_mu.Lock()
+	s.toStashQueue = append(s.toStashQueue, m)
+	s.toConfirm[m.Uid] = confirm
+	s.toStashCond.Signal()
+	s.stashqueue_mu.Unlock()
+}
+
+// ingest writes the queue in the badger databases. It returns the uids of
+// the messages that were written.
+func (s *MessageStore) ingest(queue []*model.TcpUdpParsedMessage) (map[string][]byte, error) {
 	// we avoid "defer" as a performance optim
 
 	if len(queue) == 0 {
-		return 0, nil
+		return nil, nil
 	}
 
 	marshalledQueue := map[string][]byte{}
@@ -522,7 +628,7 @@ func (s *MessageStore) ingest(queue []*model.TcpUdpParsedMessage) (int, error) {
 	}
 
 	if len(marshalledQueue) == 0 {
-		return 0, nil
+		return nil, nil
 	}
 
 	s.ready_mu.Lock()
@@ -533,7 +639,7 @@ func (s *MessageStore) ingest(queue []*model.TcpUdpParsedMessage) (int, error) {
 	if len(errorMsgKeys) == len(marshalledQueue) {
 		s.messages_mu.Unlock()
 		s.ready_mu.Unlock()
-		return 0, errMsg
+		return nil, errMsg
 	}
 
 	s.metrics.BadgerGauge.WithLabelValues("messages").Add(float64(len(marshalledQueue) - len(errorMsgKeys)))
@@ -563,8 +669,60 @@ func (s *MessageStore) ingest(queue []*model.TcpUdpParsedMessage) (int, error) {
 	if errMsg == nil {
 		errMsg = errReady
 	}
+	for _, k := range errReadyKeys {
+		delete(marshalledQueue, k)
+	}
+	if ingested > 0 {
+		s.storeOffsets(queue, marshalledQueue)
+	}
+
+	return marshalledQueue, errMsg
+}
+
+// storeOffsets records the position of the tailed files after the last
+// ingested message of each file. It must be called after the messages have
+// been stored, so that a restart does not lose lines.
+func (s *MessageStore) storeOffsets(queue []*model.TcpUdpParsedMessage, ingested map[string][]byte) {
+	offsets := map[string]*model.FileOffset{}
+	for _, m := range queue {
+		if m.Offset == nil {
+			continue
+		}
+		if _, ok := ingested[m.Uid]; ok {
+			offsets[m.Offset.Filename] = m.Offset
+		}
+	}
+	if len(offsets) == 0 {
+		return
+	}
+	batch := map[string][]byte{}
+	for filename, offset := range offsets {
+		b, err := json.Marshal(offset)
+		if err == nil {
+			batch[filename] = b
+		}
+	}
+	_, err := s.offsetsDB.AddMany(batch)
+	if err != nil {
+		s.logger.Warn("Error storing the offsets of the tailed files", "error", err)
+	}
+}
 
-	return ingested, errMsg
+// FileOffsets returns the last recorded positions of the tailed files.
+func (s *MessageStore) FileOffsets() (map[string]*model.FileOffset, error) {
+	offsets := map[string]*model.FileOffset{}
+	iter := s.offsetsDB.KeyValueIterator(100)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		offset := &model.FileOffset{}
+		err := json.Unmarshal(iter.Value(), offset)
+		if err != nil {
+			s.logger.Warn("Invalid offset in the store", "filename", iter.Key(), "error", err)
+			continue
+		}
+		offsets[iter.Key()] = offset
+	}
+	return offsets, nil
 }
 
 func (s *MessageStore) retrieve(n int) (messages map[string]*model.TcpUdpParsedMessage) {
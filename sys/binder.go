@@ -1,6 +1,7 @@
 package sys
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -56,8 +57,16 @@ type BinderClient struct {
 	parentConn         *net.UnixConn
 	IncomingConn       map[string]chan *FileConn
 	IncomingPacketConn map[string]chan *FilePacketConn
+	incomingShared     map[string]chan sharedAnswer
 	iconnMu            *sync.Mutex
 	ipacketMu          *sync.Mutex
+	isharedMu          *sync.Mutex
+}
+
+// sharedAnswer is the answer of the root parent to listenshared.
+type sharedAnswer struct {
+	f   *os.File
+	err string
 }
 
 func NewBinderClient(binderFile *os.File, logger log15.Logger) (*BinderClient, error) {
@@ -71,6 +80,8 @@ func NewBinderClient(binderFile *os.File, logger log15.Logger) (*BinderClient, e
 	c.IncomingPacketConn = map[string]chan *FilePacketConn{}
 	c.iconnMu = &sync.Mutex{}
 	c.ipacketMu = &sync.Mutex{}
+	c.incomingShared = map[string]chan sharedAnswer{}
+	c.isharedMu = &sync.Mutex{}
 
 	go func() {
 		for {
@@ -86,6 +97,12 @@ func NewBinderClient(binderFile *os.File, logger log15.Logger) (*BinderClient, e
 				for _, ichan := range c.IncomingPacketConn {
 					close(ichan)
 				}
+				c.isharedMu.Lock()
+				for _, ichan := range c.incomingShared {
+					close(ichan)
+				}
+				c.incomingShared = map[string]chan sharedAnswer{}
+				c.isharedMu.Unlock()
 				return
 			}
 			if n > 0 {
@@ -117,6 +134,31 @@ func NewBinderClient(binderFile *os.File, logger log15.Logger) (*BinderClient, e
 					}
 				}
 
+				if strings.HasPrefix(msg, "sharederror ") {
+					parts := strings.SplitN(msg, " ", 3)
+					if len(parts) == 3 {
+						c.answerShared(parts[1], sharedAnswer{err: parts[2]})
+					}
+				}
+
+				if strings.HasPrefix(msg, "shared ") && oobn > 0 {
+					addr := strings.TrimSpace(msg[len("shared "):])
+					cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+					if err == nil && len(cmsgs) > 0 {
+						fds, err := syscall.ParseUnixRights(&cmsgs[0])
+						if err == nil && len(fds) > 0 {
+							logger.Debug("Received a shared socket from root parent", "addr", addr)
+							c.answerShared(addr, sharedAnswer{f: os.NewFile(uintptr(fds[0]), "shared_"+addr)})
+						} else {
+							logger.Warn("ParseUnixRights() error", "error", err)
+							c.answerShared(addr, sharedAnswer{err: "no file descriptor"})
+						}
+					} else {
+						logger.Warn("ParseSocketControlMessage() error", "error", err)
+						c.answerShared(addr, sharedAnswer{err: "no file descriptor"})
+					}
+				}
+
 				if strings.HasPrefix(msg, "confirmlisten ") {
 					parts := strings.SplitN(msg, " ", 2)
 					addr := parts[1]
@@ -253,8 +295,118 @@ func (c *BinderClient) Listen(lnet string, laddr string) (net.Listener, error) {
 	}
 }
 
+func (c *BinderClient) answerShared(addr string, answer sharedAnswer) {
+	c.isharedMu.Lock()
+	defer c.isharedMu.Unlock()
+	if i, ok := c.incomingShared[addr]; ok {
+		delete(c.incomingShared, addr)
+		i <- answer
+		close(i)
+	} else if answer.f != nil {
+		answer.f.Close()
+	}
+}
+
+// getShared asks the root parent for a copy of a shared listening socket.
+// When name is not empty, the socket is the one systemd gave with this name.
+func (c *BinderClient) getShared(addr string, name string) (*os.File, error) {
+	ichan := make(chan sharedAnswer, 1)
+	c.isharedMu.Lock()
+	if _, ok := c.incomingShared[addr]; ok {
+		c.isharedMu.Unlock()
+		return nil, &net.OpError{Err: fmt.Errorf("Already waiting for that address"), Op: "Listen"}
+	}
+	c.incomingShared[addr] = ichan
+	c.isharedMu.Unlock()
+	c.parentConn.Write([]byte(strings.TrimSpace(fmt.Sprintf("listenshared %s %s", addr, name)) + "\n"))
+	answer, more := <-ichan
+	if !more {
+		return nil, &net.OpError{Err: fmt.Errorf("Closed ichan?!"), Op: "Listen"}
+	}
+	if len(answer.err) > 0 {
+		return nil, &net.OpError{Err: errors.New(answer.err), Op: "Listen"}
+	}
+	return answer.f, nil
+}
+
+// sharedListener is a listening socket received from the root parent. The
+// parent keeps the socket open across skewer upgrades.
+type sharedListener struct {
+	net.Listener
+	addr   string
+	client *BinderClient
+}
+
+func (l *sharedListener) Close() error {
+	err := l.Listener.Close()
+	l.client.parentConn.Write([]byte(fmt.Sprintf("releaseshared %s\n", l.addr)))
+	return err
+}
+
+// SharedPacketConn is a datagram socket received from the root parent.
+type SharedPacketConn struct {
+	net.PacketConn
+	addr   string
+	client *BinderClient
+}
+
+func (c *SharedPacketConn) Close() error {
+	err := c.PacketConn.Close()
+	c.client.parentConn.Write([]byte(fmt.Sprintf("releaseshared %s\n", c.addr)))
+	return err
+}
+
+// ListenShared returns a stream listener on a socket owned by the root
+// parent. Contrary to Listen, the connections are accepted by the child.
+func (c *BinderClient) ListenShared(lnet string, laddr string, name string) (net.Listener, error) {
+	addr := fmt.Sprintf("%s:%s", lnet, laddr)
+	f, err := c.getShared(addr, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		c.parentConn.Write([]byte(fmt.Sprintf("releaseshared %s\n", addr)))
+		return nil, err
+	}
+	return &sharedListener{Listener: l, addr: addr, client: c}, nil
+}
+
+// ListenPacketShared returns a datagram socket owned by the root parent.
+func (c *BinderClient) ListenPacketShared(lnet string, laddr string, name string) (net.PacketConn, error) {
+	addr := fmt.Sprintf("%s:%s", lnet, laddr)
+	f, err := c.getShared(addr, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		c.parentConn.Write([]byte(fmt.Sprintf("releaseshared %s\n", addr)))
+		return nil, err
+	}
+	return &SharedPacketConn{PacketConn: conn, addr: addr, client: c}, nil
+}
+
 func (c *BinderClient) ListenPacket(lnet string, laddr string) (net.PacketConn, error) {
 	addr := fmt.Sprintf("%s:%s", lnet, laddr)
+	return c.listenPacket("listen", addr, addr)
+}
+
+// ListenPacketReusePort asks the root parent for a UDP socket with
+// SO_REUSEPORT. It must not be called concurrently for the same address.
+func (c *BinderClient) ListenPacketReusePort(lnet string, laddr string) (net.PacketConn, error) {
+	addr := fmt.Sprintf("%s:%s", lnet, laddr)
+	return c.listenPacket("listenreuse", addr, addr)
+}
+
+// ListenLocal asks the root parent for the local syslog unixgram socket.
+func (c *BinderClient) ListenLocal(o LocalSocketOptions) (net.PacketConn, error) {
+	return c.listenPacket("listenlocal", "unixgram:"+o.Path, o.Encode())
+}
+
+func (c *BinderClient) listenPacket(command string, addr string, args string) (net.PacketConn, error) {
 	c.ipacketMu.Lock()
 	ichan, ok := c.IncomingPacketConn[addr]
 	if !ok {
@@ -262,7 +414,7 @@ func (c *BinderClient) ListenPacket(lnet string, laddr string) (net.PacketConn,
 		ichan = c.IncomingPacketConn[addr]
 	}
 	c.ipacketMu.Unlock()
-	c.parentConn.Write([]byte(fmt.Sprintf("listen %s\n", addr)))
+	c.parentConn.Write([]byte(fmt.Sprintf("%s %s\n", command, args)))
 	conn, more := <-ichan
 	c.ipacketMu.Lock()
 	delete(c.IncomingPacketConn, addr)
@@ -86,9 +86,28 @@ func BinderPacket(addr string) (net.PacketConn, error) {
 	return conn, nil
 }
 
-func Binder(parentsHandles []int, logger log15.Logger) (err error) {
+func BinderPacketReusePort(addr string) (net.PacketConn, error) {
+	parts := strings.SplitN(addr, ":", 2)
+	return ListenPacketReusePort(parts[0], parts[1])
+}
+
+func BinderLocal(args string) (addr string, conn net.PacketConn, err error) {
+	if i := strings.Index(args, "unixgram:"); i >= 0 {
+		addr = args[i:]
+	}
+	o, err := DecodeLocalSocketOptions(args)
+	if err != nil {
+		return addr, nil, err
+	}
+	conn, err = ListenLocalSocket(o)
+	return addr, conn, err
+}
+
+// Binder serves the requests of the children on the given sockets. The
+// shared listening sockets are taken from pool.
+func Binder(parentsHandles []int, pool *SocketPool, logger log15.Logger) (err error) {
 	for _, handle := range parentsHandles {
-		err = binderOne(handle, logger)
+		err = binderOne(handle, pool, logger)
 		if err != nil {
 			return err
 		}
@@ -96,7 +115,7 @@ func Binder(parentsHandles []int, logger log15.Logger) (err error) {
 	return nil
 }
 
-func binderOne(parentFD int, logger log15.Logger) error {
+func binderOne(parentFD int, pool *SocketPool, logger log15.Logger) error {
 	logger = logger.New("class", "binder")
 	parentFile := os.NewFile(uintptr(parentFD), "parent_file")
 
@@ -239,6 +258,15 @@ func binderOne(parentFD int, logger log15.Logger) error {
 		scanner := bufio.NewScanner(childConn)
 
 		listeners := map[string]net.Listener{}
+		// the shared sockets given to the child
+		shared := map[string]*os.File{}
+		releaseShared := func() {
+			for addr, f := range shared {
+				pool.Release(f)
+				delete(shared, addr)
+			}
+		}
+		defer releaseShared()
 		var rmsg string
 		for scanner.Scan() {
 			rmsg = strings.Trim(scanner.Text(), " \r\n")
@@ -247,8 +275,8 @@ func binderOne(parentFD int, logger log15.Logger) error {
 			logger.Debug("Received message", "message", rmsg)
 
 			switch command {
-			case "listen":
-				logger.Debug("asked to listen", "addr", args)
+			case "listen", "listenreuse":
+				logger.Debug("asked to listen", "addr", args, "reuseport", command == "listenreuse")
 				for _, addr := range strings.Split(args, " ") {
 					lnet := strings.SplitN(addr, ":", 2)[0]
 					if IsStream(lnet) {
@@ -261,7 +289,13 @@ func binderOne(parentFD int, logger log15.Logger) error {
 							childConn.Write([]byte(fmt.Sprintf("error %s %s", addr, err.Error())))
 						}
 					} else {
-						c, err := BinderPacket(addr)
+						var c net.PacketConn
+						var err error
+						if command == "listenreuse" {
+							c, err = BinderPacketReusePort(addr)
+						} else {
+							c, err = BinderPacket(addr)
+						}
 						if err == nil {
 							uid := <-generator
 							pchan <- &BinderPacketConn{Addr: addr, Conn: c, Uid: uid.String()}
@@ -271,6 +305,39 @@ func binderOne(parentFD int, logger log15.Logger) error {
 						}
 					}
 				}
+			case "listenlocal":
+				logger.Debug("asked to listen on the local syslog socket", "args", args)
+				addr, c, err := BinderLocal(args)
+				if err == nil {
+					uid := <-generator
+					pchan <- &BinderPacketConn{Addr: addr, Conn: c, Uid: uid.String()}
+				} else {
+					logger.Warn("ListenLocal error", "error", err, "args", args)
+					childConn.Write([]byte(fmt.Sprintf("error %s %s", addr, err.Error())))
+				}
+			case "listenshared":
+				// listenshared addr [name]
+				parts := strings.SplitN(args, " ", 2)
+				addr := parts[0]
+				name := ""
+				if len(parts) == 2 {
+					name = strings.TrimSpace(parts[1])
+				}
+				logger.Debug("asked for a shared socket", "addr", addr, "name", name)
+				f, err := pool.Take(addr, name)
+				if err != nil {
+					logger.Warn("Shared socket error", "error", err, "addr", addr, "name", name)
+					childConn.Write([]byte(fmt.Sprintf("sharederror %s %s\n", addr, err.Error())))
+					continue
+				}
+				shared[addr] = f
+				rights := syscall.UnixRights(int(f.Fd()))
+				childConn.WriteMsgUnix([]byte(fmt.Sprintf("shared %s\n", addr)), rights, nil)
+			case "releaseshared":
+				if f, ok := shared[args]; ok {
+					pool.Release(f)
+					delete(shared, args)
+				}
 			case "closeconn":
 				schan <- &BinderConn{Uid: args}
 				pchan <- &BinderPacketConn{Uid: args}
@@ -288,6 +355,7 @@ func binderOne(parentFD int, logger log15.Logger) error {
 					l.Close()
 				}
 				listeners = map[string]net.Listener{}
+				releaseShared()
 				schan <- &BinderConn{}
 				pchan <- &BinderPacketConn{}
 
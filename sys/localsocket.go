@@ -0,0 +1,115 @@
+package sys
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalSocketOptions describes the unix datagram socket of the local syslog
+// service (usually /dev/log).
+type LocalSocketOptions struct {
+	Path  string
+	Owner string
+	Group string
+	Mode  os.FileMode
+}
+
+// Encode serializes the options for the binder. The path comes last, so that
+// it may contain spaces.
+func (o LocalSocketOptions) Encode() string {
+	owner := o.Owner
+	if len(owner) == 0 {
+		owner = "-"
+	}
+	group := o.Group
+	if len(group) == 0 {
+		group = "-"
+	}
+	return fmt.Sprintf("%04o %s %s unixgram:%s", o.Mode, owner, group, o.Path)
+}
+
+func DecodeLocalSocketOptions(s string) (o LocalSocketOptions, err error) {
+	parts := strings.SplitN(s, " ", 4)
+	if len(parts) != 4 || !strings.HasPrefix(parts[3], "unixgram:") {
+		return o, fmt.Errorf("Invalid local socket options: '%s'", s)
+	}
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return o, fmt.Errorf("Invalid local socket mode: '%s'", parts[0])
+	}
+	o.Mode = os.FileMode(mode)
+	if parts[1] != "-" {
+		o.Owner = parts[1]
+	}
+	if parts[2] != "-" {
+		o.Group = parts[2]
+	}
+	o.Path = strings.TrimPrefix(parts[3], "unixgram:")
+	return o, nil
+}
+
+// RemoveStaleSocket removes a unix socket file that nobody listens to
+// anymore, for example after a crash. It refuses to remove anything else.
+func RemoveStaleSocket(path string) error {
+	if strings.HasPrefix(path, "@") {
+		// abstract socket
+		return nil
+	}
+	infos, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if infos.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unixgram", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already used by another process", path)
+	}
+	return os.Remove(path)
+}
+
+// ListenLocalSocket creates the local syslog socket, replacing a stale one,
+// and applies the requested owner, group and mode.
+func ListenLocalSocket(o LocalSocketOptions) (net.PacketConn, error) {
+	err := RemoveStaleSocket(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("unixgram", o.Path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(o.Path, "@") {
+		return conn, nil
+	}
+	err = os.Chmod(o.Path, o.Mode)
+	if err == nil && (len(o.Owner) > 0 || len(o.Group) > 0) {
+		uid, gid := -1, -1
+		var numuid, numgid int
+		numuid, numgid, err = LookupUid(o.Owner, o.Group)
+		if err == nil {
+			if len(o.Owner) > 0 {
+				uid = numuid
+			}
+			if len(o.Group) > 0 {
+				gid = numgid
+			}
+			err = os.Chown(o.Path, uid, gid)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		os.Remove(o.Path)
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,87 @@
+// +build linux
+
+package sys
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var ReusePortSupported bool = true
+
+// ListenPacketReusePort opens a UDP socket with SO_REUSEPORT, so that several
+// sockets can be bound to the same address and be read concurrently.
+func ListenPacketReusePort(lnet string, laddr string) (net.PacketConn, error) {
+	addr, err := net.ResolveUDPAddr(lnet, laddr)
+	if err != nil {
+		return nil, err
+	}
+	var family int
+	var sa unix.Sockaddr
+	if lnet != "udp6" && (addr.IP == nil || addr.IP.To4() != nil) {
+		family = unix.AF_INET
+		sa4 := &unix.SockaddrInet4{Port: addr.Port}
+		if addr.IP != nil {
+			copy(sa4.Addr[:], addr.IP.To4())
+		}
+		sa = sa4
+	} else {
+		family = unix.AF_INET6
+		sa6 := &unix.SockaddrInet6{Port: addr.Port}
+		copy(sa6.Addr[:], addr.IP.To16())
+		sa = sa6
+	}
+	opErr := func(err error) error {
+		return &net.OpError{Op: "listen", Net: lnet, Addr: addr, Err: os.NewSyscallError("socket", err)}
+	}
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, opErr(err)
+	}
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	if err != nil {
+		unix.Close(fd)
+		return nil, opErr(err)
+	}
+	err = unix.Bind(fd, sa)
+	if err != nil {
+		unix.Close(fd)
+		return nil, opErr(err)
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("reuseport-%s", laddr))
+	conn, err := net.FilePacketConn(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// EnableDropsCount asks the kernel to report, with each datagram, the number
+// of datagrams that were dropped on the socket (SO_RXQ_OVFL).
+func EnableDropsCount(conn *net.UDPConn) error {
+	return setsockoptInt(conn, unix.SOL_SOCKET, unix.SO_RXQ_OVFL, 1)
+}
+
+// DropsOOBSize is the size of the out-of-band buffer needed by DropsCount.
+var DropsOOBSize = unix.CmsgSpace(4)
+
+// DropsCount extracts the kernel drop counter from the control messages of
+// a datagram.
+func DropsCount(oob []byte) (uint32, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.SOL_SOCKET && msg.Header.Type == unix.SO_RXQ_OVFL && len(msg.Data) >= 4 {
+			return *(*uint32)(unsafe.Pointer(&msg.Data[0])), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,21 @@
+// +build !linux
+
+package sys
+
+import "net"
+
+var ReusePortSupported bool = false
+
+func ListenPacketReusePort(lnet string, laddr string) (net.PacketConn, error) {
+	return net.ListenPacket(lnet, laddr)
+}
+
+func EnableDropsCount(conn *net.UDPConn) error {
+	return NotLinuxError{}
+}
+
+var DropsOOBSize = 0
+
+func DropsCount(oob []byte) (uint32, bool) {
+	return 0, false
+}
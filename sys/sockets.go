@@ -0,0 +1,362 @@
+package sys
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const listenFDsStart = 3
+
+// maxHandoverSockets is the maximum number of file descriptors that can be
+// passed in one SCM_RIGHTS message.
+const maxHandoverSockets = 253
+
+// SharedSocket is a listening socket owned by the root parent. The plugins
+// receive a copy of it, and accept the connections themselves.
+type SharedSocket struct {
+	Name string
+	Addr net.Addr
+	file *os.File
+	// activated sockets were given by systemd: they are never closed
+	activated bool
+	inUse     bool
+}
+
+// SocketPool keeps the listening sockets of the root parent: the sockets
+// inherited from systemd (socket activation) or from a previous skewer
+// binary (upgrade), and the sockets opened on behalf of the plugins.
+type SocketPool struct {
+	mu        sync.Mutex
+	sockets   []*SharedSocket
+	upgrading bool
+}
+
+// NewSocketPool returns a pool with the sockets inherited by the process.
+// They are given by systemd in LISTEN_FDS and LISTEN_FDNAMES, or sent by
+// the previous skewer binary over the socket in SKEWER_UPGRADE_FD. In the
+// latter case, NewSocketPool returns when the previous binary has stopped
+// its child. The environment variables are cleared, so that the children
+// do not inherit them.
+func NewSocketPool() (*SocketPool, error) {
+	p := &SocketPool{sockets: []*SharedSocket{}}
+	err := p.inheritSystemd()
+	if err != nil {
+		return p, err
+	}
+	return p, p.inheritUpgrade()
+}
+
+func (p *SocketPool) inheritSystemd() error {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	nb, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nb <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < nb; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		err = p.inherit(listenFDsStart+i, name, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *SocketPool) inheritUpgrade() error {
+	defer os.Unsetenv("SKEWER_UPGRADE_FD")
+	fd, err := strconv.Atoi(os.Getenv("SKEWER_UPGRADE_FD"))
+	if err != nil {
+		return nil
+	}
+	syscall.CloseOnExec(fd)
+	f := os.NewFile(uintptr(fd), "upgrade")
+	genconn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("Invalid SKEWER_UPGRADE_FD: %s", err)
+	}
+	conn, ok := genconn.(*net.UnixConn)
+	if !ok {
+		genconn.Close()
+		return fmt.Errorf("SKEWER_UPGRADE_FD is not a unix socket")
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 65536)
+	oob := make([]byte, syscall.CmsgSpace(4*maxHandoverSockets))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return fmt.Errorf("Error receiving the sockets from the previous binary: %s", err)
+	}
+	fds := []int{}
+	if oobn > 0 {
+		cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return err
+		}
+		for i := range cmsgs {
+			rights, err := syscall.ParseUnixRights(&cmsgs[i])
+			if err != nil {
+				return err
+			}
+			fds = append(fds, rights...)
+		}
+	}
+	// one "kind name" line per socket
+	text := string(buf[:n])
+	if !strings.HasSuffix(text, "\n") {
+		return fmt.Errorf("Truncated socket list from the previous binary")
+	}
+	lines := []string{}
+	if text != "\n" {
+		lines = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	}
+	if len(lines) != len(fds) {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return fmt.Errorf("The previous binary sent %d sockets for %d names", len(fds), len(lines))
+	}
+	for i, line := range lines {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid socket description from the previous binary: %s", line)
+		}
+		err = p.inherit(fds[i], parts[1], parts[0] == "activated")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.Write([]byte("ready\n"))
+	if err != nil {
+		return err
+	}
+	// the Store can only be opened by one process: the previous binary
+	// closes the connection when its child has stopped
+	_, err = io.Copy(ioutil.Discard, conn)
+	return err
+}
+
+func (p *SocketPool) inherit(fd int, name string, activated bool) error {
+	syscall.CloseOnExec(fd)
+	f := os.NewFile(uintptr(fd), "listen_fd_"+name)
+	addr, err := socketAddr(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Inherited file descriptor %d is not a socket: %s", fd, err)
+	}
+	p.sockets = append(p.sockets, &SharedSocket{Name: name, Addr: addr, file: f, activated: activated})
+	return nil
+}
+
+// socketAddr returns the local address of a listening socket.
+func socketAddr(f *os.File) (net.Addr, error) {
+	if l, err := net.FileListener(f); err == nil {
+		defer l.Close()
+		return l.Addr(), nil
+	}
+	c, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr(), nil
+}
+
+// Sockets returns the sockets of the pool.
+func (p *SocketPool) Sockets() []*SharedSocket {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*SharedSocket{}, p.sockets...)
+}
+
+// Take returns the socket for addr ("tcp:host:port" or "udp:host:port").
+// When name is not empty, the socket is the one that systemd gave with
+// this name. Otherwise an inherited socket bound to the same address is
+// reused, or a new socket is opened.
+func (p *SocketPool) Take(addr string, name string) (*os.File, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	parts := strings.SplitN(addr, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid address: %s", addr)
+	}
+	lnet, laddr := parts[0], parts[1]
+	for _, s := range p.sockets {
+		if s.inUse {
+			continue
+		}
+		if len(name) > 0 {
+			if s.Name == name && sameKind(lnet, s.Addr) {
+				s.inUse = true
+				return s.file, nil
+			}
+		} else if sameAddr(lnet, laddr, s.Addr) {
+			s.inUse = true
+			return s.file, nil
+		}
+	}
+	if len(name) > 0 {
+		return nil, fmt.Errorf("No socket named '%s' was given by systemd", name)
+	}
+	f, err := listenFile(lnet, laddr)
+	if err != nil {
+		return nil, err
+	}
+	addrObj, err := socketAddr(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	p.sockets = append(p.sockets, &SharedSocket{Name: "skewer", Addr: addrObj, file: f, inUse: true})
+	return f, nil
+}
+
+// Release tells that the plugins do not use the socket of f anymore. It is
+// closed, unless it was given by systemd or an upgrade is in progress.
+func (p *SocketPool) Release(f *os.File) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, s := range p.sockets {
+		if s.file != f {
+			continue
+		}
+		s.inUse = false
+		if !s.activated && !p.upgrading {
+			s.file.Close()
+			p.sockets = append(p.sockets[:i], p.sockets[i+1:]...)
+		}
+		return
+	}
+}
+
+// HandoverTo sends the sockets of the pool to a new skewer binary over
+// conn, in one SCM_RIGHTS message. From now on, the released sockets are
+// kept open, until CancelHandover is called.
+func (p *SocketPool) HandoverTo(conn *net.UnixConn) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.sockets) > maxHandoverSockets {
+		return fmt.Errorf("Too many sockets to hand over: %d", len(p.sockets))
+	}
+	p.upgrading = true
+	specs := ""
+	fds := make([]int, 0, len(p.sockets))
+	for _, s := range p.sockets {
+		kind := "opened"
+		if s.activated {
+			kind = "activated"
+		}
+		specs += kind + " " + s.Name + "\n"
+		fds = append(fds, int(s.file.Fd()))
+	}
+	if len(fds) == 0 {
+		_, err := conn.Write([]byte("\n"))
+		return err
+	}
+	_, _, err := conn.WriteMsgUnix([]byte(specs), syscall.UnixRights(fds...), nil)
+	return err
+}
+
+// CancelHandover is called when the new skewer binary could not take over:
+// the sockets released from now on are closed again.
+func (p *SocketPool) CancelHandover() {
+	p.mu.Lock()
+	p.upgrading = false
+	p.mu.Unlock()
+}
+
+func listenFile(lnet, laddr string) (*os.File, error) {
+	if IsStream(lnet) {
+		l, err := net.Listen(lnet, laddr)
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		if tl, ok := l.(*net.TCPListener); ok {
+			return tl.File()
+		}
+		return nil, fmt.Errorf("Unsupported network: %s", lnet)
+	}
+	c, err := net.ListenPacket(lnet, laddr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if uc, ok := c.(*net.UDPConn); ok {
+		return uc.File()
+	}
+	return nil, fmt.Errorf("Unsupported network: %s", lnet)
+}
+
+// sameAddr tells if a socket bound to actual can be used to listen on
+// lnet:laddr. The unspecified addresses (0.0.0.0 and ::) are equivalent.
+func sameAddr(lnet, laddr string, actual net.Addr) bool {
+	switch a := actual.(type) {
+	case *net.TCPAddr:
+		if !IsStream(lnet) {
+			return false
+		}
+		wanted, err := net.ResolveTCPAddr(lnet, laddr)
+		if err != nil {
+			return false
+		}
+		return sameIPPort(wanted.IP, wanted.Port, a.IP, a.Port)
+	case *net.UDPAddr:
+		if IsStream(lnet) {
+			return false
+		}
+		wanted, err := net.ResolveUDPAddr(lnet, laddr)
+		if err != nil {
+			return false
+		}
+		return sameIPPort(wanted.IP, wanted.Port, a.IP, a.Port)
+	default:
+		return false
+	}
+}
+
+// sameKind tells if a socket bound to actual is a stream socket when lnet
+// is a stream network, and a datagram socket otherwise.
+func sameKind(lnet string, actual net.Addr) bool {
+	switch actual.(type) {
+	case *net.TCPAddr:
+		return IsStream(lnet)
+	case *net.UDPAddr:
+		return !IsStream(lnet)
+	default:
+		return false
+	}
+}
+
+func sameIPPort(ip1 net.IP, port1 int, ip2 net.IP, port2 int) bool {
+	if port1 != port2 {
+		return false
+	}
+	unspecified1 := ip1 == nil || ip1.IsUnspecified()
+	unspecified2 := ip2 == nil || ip2.IsUnspecified()
+	if unspecified1 || unspecified2 {
+		return unspecified1 && unspecified2
+	}
+	return ip1.Equal(ip2)
+}
@@ -2,18 +2,120 @@
 
 package sys
 
-import "golang.org/x/sys/unix"
-import "net"
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var CredentialsSupported bool = true
 
 func GetCredentials(conn *net.UnixConn) (int32, uint32, uint32, error) {
-	f, err := conn.File()
+	raw, err := conn.SyscallConn()
 	if err != nil {
 		return 0, 0, 0, err
 	}
-	creds, err := unix.GetsockoptUcred(int(f.Fd()), unix.SOL_SOCKET, unix.SO_PEERCRED)
-	f.Close()
+	var creds *unix.Ucred
+	var credsErr error
+	err = raw.Control(func(fd uintptr) {
+		creds, credsErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credsErr
+	}
 	if err != nil {
 		return 0, 0, 0, err
 	}
 	return creds.Pid, creds.Uid, creds.Gid, nil
 }
+
+// EnablePassCred asks the kernel to attach the sender credentials to each
+// datagram received on a unixgram socket (SO_PASSCRED).
+func EnablePassCred(conn *net.UnixConn) error {
+	return setsockoptInt(conn, unix.SOL_SOCKET, unix.SO_PASSCRED, 1)
+}
+
+// setsockoptInt sets a socket option on the file descriptor of conn, without
+// duplicating it like File() does.
+func setsockoptInt(conn syscall.Conn, level int, opt int, value int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var optErr error
+	err = raw.Control(func(fd uintptr) {
+		optErr = unix.SetsockoptInt(int(fd), level, opt, value)
+	})
+	if err != nil {
+		return err
+	}
+	return optErr
+}
+
+// CredentialsOOBSize is the size of the out-of-band buffer needed by
+// ParseCredentials.
+var CredentialsOOBSize = unix.CmsgSpace(unix.SizeofUcred)
+
+// ParseCredentials extracts the SCM_CREDENTIALS control message of a datagram.
+func ParseCredentials(oob []byte) (int32, uint32, uint32, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	for i, msg := range msgs {
+		if msg.Header.Level == unix.SOL_SOCKET && msg.Header.Type == unix.SCM_CREDENTIALS {
+			creds, err := unix.ParseUnixCredentials(&msgs[i])
+			if err == nil {
+				return creds.Pid, creds.Uid, creds.Gid, true
+			}
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// ProcessInfo reads the command name, the executable and the cgroup of a
+// process from /proc. The fields that can't be read are left empty.
+func ProcessInfo(pid int32) (comm string, exe string, cgroup string) {
+	dir := "/proc/" + strconv.FormatInt(int64(pid), 10)
+	b, err := ioutil.ReadFile(dir + "/comm")
+	if err == nil {
+		comm = strings.TrimSpace(string(b))
+	}
+	exe, _ = os.Readlink(dir + "/exe")
+	b, err = ioutil.ReadFile(dir + "/cgroup")
+	if err == nil {
+		cgroup = parseCgroup(string(b))
+	}
+	return comm, exe, cgroup
+}
+
+// parseCgroup returns the path of the process in the unified hierarchy, or in
+// the systemd hierarchy, or else in the first listed hierarchy.
+func parseCgroup(content string) string {
+	first := ""
+	systemd := ""
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && len(parts[1]) == 0 {
+			return parts[2]
+		}
+		if parts[1] == "name=systemd" {
+			systemd = parts[2]
+		}
+		if len(first) == 0 {
+			first = parts[2]
+		}
+	}
+	if len(systemd) > 0 {
+		return systemd
+	}
+	return first
+}
@@ -0,0 +1,25 @@
+// +build !linux
+
+package sys
+
+import "net"
+
+var CredentialsSupported bool = false
+
+func GetCredentials(conn *net.UnixConn) (int32, uint32, uint32, error) {
+	return 0, 0, 0, NotLinuxError{}
+}
+
+func EnablePassCred(conn *net.UnixConn) error {
+	return NotLinuxError{}
+}
+
+var CredentialsOOBSize = 0
+
+func ParseCredentials(oob []byte) (int32, uint32, uint32, bool) {
+	return 0, 0, 0, false
+}
+
+func ProcessInfo(pid int32) (comm string, exe string, cgroup string) {
+	return "", "", ""
+}